@@ -1,21 +1,36 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type AWS struct {
 	s3bucket struct {
 		text  string
 		cvmp3 string
+		voice string
 	}
 	s3Region                 string
 	s3CloudFrontDistribution string
 	accessKeyId              string
 	secretAccessKey          string
+	cloudFront               struct {
+		keyPairId      string
+		privateKeyPath string
+	}
+	signedURL struct {
+		signer string
+		ttl    time.Duration
+	}
 }
 
 type RabbitMQ struct {
@@ -24,8 +39,15 @@ type RabbitMQ struct {
 	password string
 	port     string
 	exchange string
-	route    struct {
+	// eventExchange is where the job service publishes job status events.
+	eventExchange string
+	channels      int
+	route         struct {
 		text string
+
+		// voice is the route key of voice conversions, and cancel the one of cancellations.
+		voice  string
+		cancel string
 	}
 }
 
@@ -38,14 +60,101 @@ type GRPC struct {
 		host string
 		port string
 	}
+	auth struct {
+		host string
+		port string
+	}
+}
+
+type Encryption struct {
+	key          string
+	version      uint
+	previousKeys string
+	// envelope encrypts stored files under per-object data keys that only the gateway can unwrap.
+	// The workers read the texts and uploads the gateway stores and store the audio it serves, and signed URLs
+	// hand objects out as they are stored, so it is only allowed with a file store the workers don't share
+	// and with signed URLs disabled. As the workers only read from S3, that rules it out of deployments:
+	// texts uploaded for narration are not encrypted at rest until the workers can unwrap data keys.
+	envelope bool
+}
+
+// keyRing returns the active key and the previous keys still accepted for decryption.
+// Previous keys are given as a comma separated list of version:secret pairs.
+func (e Encryption) keyRing() (encryptor.Key, []encryptor.Key, error) {
+	if e.version == 0 || e.version > math.MaxUint8 {
+		return encryptor.Key{}, nil, fmt.Errorf("invalid encryption key version %d", e.version)
+	}
+	active := encryptor.Key{Version: uint8(e.version), Secret: e.key}
+
+	var previous []encryptor.Key
+	for _, entry := range strings.Split(e.previousKeys, ",") {
+		if entry == "" {
+			continue
+		}
+
+		v, secret, ok := strings.Cut(entry, ":")
+		if !ok {
+			return encryptor.Key{}, nil, errors.New("previous encryption keys must be version:secret pairs")
+		}
+
+		version, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || version == 0 {
+			return encryptor.Key{}, nil, fmt.Errorf("invalid previous encryption key version %q", v)
+		}
+
+		previous = append(previous, encryptor.Key{Version: uint8(version), Secret: secret})
+	}
+
+	return active, previous, nil
+}
+
+type Storage struct {
+	backend string
+	root    string
+}
+
+type Idempotency struct {
+	store string
+	table string
 }
 
 type Config struct {
 	port       int
-	encryptKey string
+	relay      time.Duration
+	encryption Encryption
+	storage    Storage
 	aws        AWS
-	rabbit     RabbitMQ
-	grpc       GRPC
+	// idempotency is where the responses replayed to retried submissions are kept.
+	idempotency Idempotency
+	rabbit      RabbitMQ
+	grpc        GRPC
+
+	// chapterPause is the silence between the chapters of split jobs downloaded as a single file.
+	chapterPause time.Duration
+	// paragraphPause is the silence between paragraphs. It is put in by the worker, which assembles the audio
+	// of a job from those of its paragraphs, so that every job has a single file to serve.
+	paragraphPause time.Duration
+
+	// voiceCatalog is the path of the JSON voice catalog submissions are validated against,
+	// empty for the catalog of Kokoro's voices.
+	voiceCatalog string
+	// modelCatalog is the path of the JSON catalog of the vetted voice models audio may be converted with,
+	// empty for the model bundled with the RVC worker only.
+	modelCatalog string
+}
+
+// validate reports settings that can't work together.
+func (c Config) validate() error {
+	if c.encryption.envelope {
+		if c.storage.backend == "s3" {
+			return errors.New("envelope encryption can't be used with the s3 file store, whose buckets the workers share")
+		}
+		if c.aws.signedURL.signer != "none" {
+			return errors.New("envelope encryption can't be used with signed urls, which would hand out encrypted objects")
+		}
+	}
+
+	return nil
 }
 
 var (
@@ -59,27 +168,84 @@ func getConfig() Config {
 
 		flag.IntVar(&instance.port, "port", 8080, "Server Port")
 
-		flag.StringVar(&instance.encryptKey, "key", os.Getenv("ENCRYPT_KEY"), "Encryption key")
+		flag.StringVar(&instance.encryption.key, "key", os.Getenv("ENCRYPT_KEY"), "Encryption key")
+		flag.UintVar(&instance.encryption.version, "key-version", envUint("ENCRYPT_KEY_VERSION", 1), "Encryption key version")
+		flag.StringVar(&instance.encryption.previousKeys, "previous-keys", os.Getenv("ENCRYPT_PREVIOUS_KEYS"), "Comma separated version:key pairs of rotated out encryption keys")
+		flag.BoolVar(&instance.encryption.envelope, "envelope-encryption", envBool("ENVELOPE_ENCRYPTION", false), "Encrypt stored files client-side under per-object data keys. Only allowed with the disk and memory file stores and signed URLs disabled, which the workers can't read from, so that deployments can't encrypt uploads at rest yet")
+
+		flag.DurationVar(&instance.chapterPause, "chapter-pause", envDuration("CHAPTER_PAUSE", 2*time.Second), "Silence between the chapters of split jobs downloaded as a single file")
+		flag.DurationVar(&instance.paragraphPause, "paragraph-pause", envDuration("PARAGRAPH_PAUSE", 500*time.Millisecond), "Silence between paragraphs, put in by the worker as it assembles the audio of a job")
+
+		flag.StringVar(&instance.voiceCatalog, "voice-catalog", os.Getenv("VOICE_CATALOG"), "Path of the JSON voice catalog, defaults to Kokoro's voices")
+		flag.StringVar(&instance.modelCatalog, "model-catalog", os.Getenv("MODEL_CATALOG"), "Path of the JSON catalog of the vetted RVC voice models stored in the voice bucket, defaults to none but the model bundled with the worker")
+
+		flag.StringVar(&instance.storage.backend, "file-store", envString("FILE_STORE", "s3"), "File store backend (s3|disk|memory)")
+		flag.StringVar(&instance.storage.root, "file-store-root", envString("FILE_STORE_ROOT", "data"), "Root directory of the disk file store")
+
+		flag.StringVar(&instance.idempotency.store, "idempotency-store", envString("IDEMPOTENCY_STORE", "memory"), "Idempotency key store (memory|dynamodb)")
+		flag.StringVar(&instance.idempotency.table, "idempotency-table", envString("IDEMPOTENCY_TABLE", "gateway-idempotency-keys"), "DynamoDB table of the idempotency key store")
 
 		flag.StringVar(&instance.aws.s3bucket.text, "s3-text-bucket", os.Getenv("S3_TEXT_BUCKET"), "S3 text bucket name")
 		flag.StringVar(&instance.aws.s3bucket.cvmp3, "s3-converted-mp3-bucket", os.Getenv("S3_CONVERTED_MP3_BUCKET"), "S3 converted mp3 bucket name")
+		flag.StringVar(&instance.aws.s3bucket.voice, "s3-voice-bucket", os.Getenv("S3_VOICE_BUCKET"), "S3 bucket name of the audio uploaded for voice conversion and of the voice models of the catalog, which the RVC worker reads from the same setting")
 
 		flag.StringVar(&instance.aws.s3Region, "s3-region", os.Getenv("S3_REGION"), "S3 region")
 		flag.StringVar(&instance.aws.accessKeyId, "aws-access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS access key ID")
 		flag.StringVar(&instance.aws.secretAccessKey, "aws-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key")
 
+		flag.StringVar(&instance.aws.s3CloudFrontDistribution, "s3-cloudfront-distribution", os.Getenv("S3_CLOUDFRONT_DISTRIBUTION"), "CloudFront distribution domain in front of the converted mp3 bucket")
+		flag.StringVar(&instance.aws.cloudFront.keyPairId, "cloudfront-key-pair-id", os.Getenv("CLOUDFRONT_KEY_PAIR_ID"), "CloudFront public key ID used to sign URLs")
+		flag.StringVar(&instance.aws.cloudFront.privateKeyPath, "cloudfront-private-key", os.Getenv("CLOUDFRONT_PRIVATE_KEY_PATH"), "Path to the PEM encoded CloudFront private key")
+		flag.StringVar(&instance.aws.signedURL.signer, "signed-url-signer", envString("SIGNED_URL_SIGNER", "s3"), "Signed audio URL issuer (s3|cloudfront|none)")
+		flag.DurationVar(&instance.aws.signedURL.ttl, "signed-url-ttl", envDuration("SIGNED_URL_TTL", 15*time.Minute), "Signed audio URL lifetime")
+
 		flag.StringVar(&instance.rabbit.host, "rabbit-host", os.Getenv("AMQP_HOST"), "RabbitMQ host")
 		flag.StringVar(&instance.rabbit.username, "rabbit-username", os.Getenv("AMQP_USERNAME"), "RabbitMQ username")
 		flag.StringVar(&instance.rabbit.password, "rabbit-password", os.Getenv("AMQP_PASSWORD"), "RabbitMQ password")
 		flag.StringVar(&instance.rabbit.port, "rabbit-port", os.Getenv("AMQP_PORT"), "RabbitMQ password")
 		flag.StringVar(&instance.rabbit.exchange, "rabbit-exchange", os.Getenv("EXCHANGE_KEY"), "RabbitMQ exchange name")
+		flag.StringVar(&instance.rabbit.eventExchange, "rabbit-event-exchange", envString("EVENT_EXCHANGE_KEY", "job-events"), "RabbitMQ exchange job status events are consumed from")
+		flag.DurationVar(&instance.relay, "outbox-poll-interval", envDuration("OUTBOX_POLL_INTERVAL", 5*time.Second), "Interval between polls of the job outbox for conversions to publish")
+		flag.IntVar(&instance.rabbit.channels, "rabbit-channels", int(envUint("AMQP_CHANNELS", 4)), "Idle RabbitMQ publishing channels kept open")
 		flag.StringVar(&instance.rabbit.route.text, "rabbit-text-route", os.Getenv("TTS_ROUTE_KEY"), "RabbitMQ text exchange route key")
+		flag.StringVar(&instance.rabbit.route.voice, "rabbit-voice-route", envString("VC_ROUTE_KEY", "file.audio"), "RabbitMQ voice conversion route key")
+		flag.StringVar(&instance.rabbit.route.cancel, "rabbit-cancel-route", envString("CANCEL_ROUTE_KEY", "job.cancel"), "RabbitMQ job cancellation route key")
 
 		flag.StringVar(&instance.grpc.job.host, "grpc-job-host", os.Getenv("GRPC_JOB_HOST"), "Job service host")
 		flag.StringVar(&instance.grpc.job.port, "grpc-job-port", os.Getenv("GRPC_JOB_PORT"), "Job service port")
+		flag.StringVar(&instance.grpc.auth.host, "grpc-auth-host", os.Getenv("GRPC_AUTH_HOST"), "Auth service host")
+		flag.StringVar(&instance.grpc.auth.port, "grpc-auth-port", os.Getenv("GRPC_AUTH_PORT"), "Auth service port")
 
 		flag.Parse()
 	})
 
 	return instance
 }
+
+func envString(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+	if b, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return b
+	}
+	return fallback
+}
+
+func envUint(key string, fallback uint) uint {
+	if v, err := strconv.ParseUint(os.Getenv(key), 10, 0); err == nil {
+		return uint(v)
+	}
+	return fallback
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/ziliscite/bard_narate/gateway/internal/controller"
 	"github.com/ziliscite/bard_narate/gateway/internal/repository"
 	"github.com/ziliscite/bard_narate/gateway/internal/service"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
 	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -19,29 +23,87 @@ import (
 
 func main() {
 	cfg := getConfig()
+	if err := cfg.validate(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
 
-	s3c := s3.NewFromConfig(aws.Config{
+	awsCfg := aws.Config{
 		Region: cfg.aws.s3Region,
 		Credentials: credentials.NewStaticCredentialsProvider(
 			cfg.aws.accessKeyId,
 			cfg.aws.secretAccessKey,
 			"",
 		),
-	})
-	fs := repository.NewStore(s3c)
-	ts := service.NewTextService(fs, cfg.aws.s3bucket.text)
+	}
+	s3c := s3.NewFromConfig(awsCfg)
+	activeKey, previousKeys, err := cfg.encryption.keyRing()
+	if err != nil {
+		slog.Error("Invalid encryption keys", "error", err)
+		os.Exit(1)
+	}
 
-	conn, err := amqp.Dial(cfg.rabbit.dsn())
+	enc, err := encryptor.NewKeyRing(activeKey, previousKeys...)
 	if err != nil {
-		slog.Error(err.Error())
+		slog.Error("Failed to create encryptor", "error", err)
+		os.Exit(1)
+	}
+
+	var fs repository.FileStore
+	switch cfg.storage.backend {
+	case "s3":
+		fs = repository.NewStore(s3c)
+	case "disk":
+		if fs, err = repository.NewDiskStore(cfg.storage.root); err != nil {
+			slog.Error("Failed to create disk file store", "error", err)
+			os.Exit(1)
+		}
+	case "memory":
+		fs = repository.NewMemoryStore()
+	default:
+		slog.Error("Unknown file store", "backend", cfg.storage.backend)
 		os.Exit(1)
 	}
-	defer conn.Close()
 
-	ps, err := service.NewPublisher(conn, cfg.rabbit.exchange, cfg.rabbit.route.text)
+	if cfg.encryption.envelope {
+		fs = repository.NewEnvelopeStore(fs, enc)
+	}
+	ts := service.NewTextService(fs, enc, cfg.aws.s3bucket.text)
+
+	var us repository.URLSigner
+	switch cfg.aws.signedURL.signer {
+	case "cloudfront":
+		key, err := sign.LoadPEMPrivKeyFile(cfg.aws.cloudFront.privateKeyPath)
+		if err != nil {
+			slog.Error("Failed to load cloudfront private key", "error", err)
+			os.Exit(1)
+		}
+		us = repository.NewCloudFrontSigner(cfg.aws.s3CloudFrontDistribution, cfg.aws.cloudFront.keyPairId, key)
+	case "s3":
+		us = repository.NewS3Signer(s3c)
+	case "none":
+		// signed urls are disabled
+	default:
+		slog.Error("Unknown signed url signer", "signer", cfg.aws.signedURL.signer)
+		os.Exit(1)
+	}
+	as := service.NewAudioService(fs, us, enc, cfg.aws.s3bucket.cvmp3, cfg.aws.signedURL.ttl, cfg.chapterPause)
+
+	mc, err := service.LoadModelCatalog(cfg.modelCatalog)
 	if err != nil {
-		panic(err)
+		slog.Error("Failed to load model catalog", "error", err)
+		os.Exit(1)
+	}
+	cs := service.NewConversionService(fs, enc, mc, cfg.aws.s3bucket.voice)
+
+	ps, err := service.NewPublisher(func() (*amqp.Connection, error) {
+		return amqp.Dial(cfg.rabbit.dsn())
+	}, cfg.rabbit.exchange, cfg.rabbit.route.text, cfg.rabbit.route.voice, cfg.rabbit.route.cancel, cfg.paragraphPause, cfg.rabbit.channels)
+	if err != nil {
+		slog.Error("Failed to create publisher", "error", err)
+		os.Exit(1)
 	}
+	defer ps.Close()
 
 	jobClient, err := grpc.NewClient(fmt.Sprintf("%s:%s", cfg.grpc.job.host, cfg.grpc.job.port), grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
@@ -51,7 +113,49 @@ func main() {
 	defer jobClient.Close()
 	jsc := pb.NewJobServiceClient(jobClient)
 
-	cv := controller.NewConverter(ts, ps, jsc)
+	authClient, err := grpc.NewClient(fmt.Sprintf("%s:%s", cfg.grpc.auth.host, cfg.grpc.auth.port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		slog.Error("Failed to connect to auth service client", "error", err)
+		os.Exit(1)
+	}
+	defer authClient.Close()
+	asc := pb.NewServerAuthServiceClient(authClient)
+
+	rl := service.NewRelay(jsc, ps, enc, cfg.relay)
+	go rl.Run(context.Background())
+
+	ev := service.NewJobEvents(func() (*amqp.Connection, error) {
+		return amqp.Dial(cfg.rabbit.dsn())
+	}, cfg.rabbit.eventExchange)
+	go ev.Run(context.Background())
+
+	vc, err := service.LoadVoiceCatalog(cfg.voiceCatalog)
+	if err != nil {
+		slog.Error("Failed to load voice catalog", "error", err)
+		os.Exit(1)
+	}
+
+	var is repository.IdempotencyStore
+	switch cfg.idempotency.store {
+	case "memory":
+		is = repository.NewMemoryIdempotencyStore()
+	case "dynamodb":
+		dis := repository.NewDynamoIdempotencyStore(dynamodb.NewFromConfig(awsCfg), cfg.idempotency.table)
+		if err = dis.AutoMigrate(context.Background()); err != nil {
+			slog.Error("Failed to migrate idempotency key table", "error", err)
+			os.Exit(1)
+		}
+		is = dis
+	default:
+		slog.Error("Unknown idempotency key store", "store", cfg.idempotency.store)
+		os.Exit(1)
+	}
+
+	cv := controller.NewConverter(ts, as, rl, ev, vc, jsc)
+	vcv := controller.NewVoiceConverter(cs, mc, rl, jsc)
+	wh := controller.NewWebhooks(jsc)
+	au := controller.NewAuthenticator(asc)
+	idm := controller.NewIdempotency(service.NewIdempotencyService(is))
 
 	router := gin.New()
 	router.MaxMultipartMemory = 1 << 30 // 1GB
@@ -62,8 +166,43 @@ func main() {
 		})
 	})
 
-	router.POST("/text-to-audio", cv.TextToAudio)
-	router.GET("/text-to-audio/:id", cv.JobStatus)
+	router.GET("/voices", cv.Voices)
+	router.GET("/models", vcv.Models)
+
+	tta := router.Group("/text-to-audio", au.Authenticate)
+	tta.POST("", idm.Idempotent, cv.TextToAudio)
+	tta.POST("/batch", idm.Idempotent, cv.TextToAudioBatch)
+	tta.GET("", cv.ListJobs)
+	tta.GET("/:id", cv.JobStatus)
+	tta.DELETE("/:id", cv.CancelJob)
+	tta.POST("/:id/retry", cv.RetryJob)
+	tta.GET("/:id/audio", cv.DownloadAudio)
+	tta.HEAD("/:id/audio", cv.DownloadAudio)
+	tta.GET("/:id/chapters", cv.Chapters)
+	tta.GET("/:id/url", cv.AudioURL)
+	tta.GET("/:id/events", cv.JobEvents)
+
+	// voice conversions are jobs like any other, followed and downloaded the same way
+	vcr := router.Group("/voice-conversion", au.Authenticate)
+	vcr.POST("", vcv.VoiceConversion)
+	vcr.GET("/:id", cv.JobStatus)
+	vcr.DELETE("/:id", cv.CancelJob)
+	vcr.POST("/:id/retry", cv.RetryJob)
+	vcr.GET("/:id/audio", cv.DownloadAudio)
+	vcr.HEAD("/:id/audio", cv.DownloadAudio)
+	vcr.GET("/:id/url", cv.AudioURL)
+	vcr.GET("/:id/events", cv.JobEvents)
+
+	bat := router.Group("/batches", au.Authenticate)
+	bat.GET("/:id", cv.BatchStatus)
+	bat.GET("/:id/archive", cv.BatchArchive)
+
+	whk := router.Group("/webhook", au.Authenticate)
+	whk.PUT("", wh.SetWebhook)
+	whk.GET("", wh.GetWebhook)
+	whk.DELETE("", wh.DeleteWebhook)
+	whk.GET("/deliveries", wh.Deliveries)
+	whk.POST("/deliveries/:id/replay", wh.ReplayDelivery)
 
 	if err := router.Run(":8080"); err != nil {
 		panic(err)
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+// userKey is the gin context key under which the authenticated user is stored.
+const userKey = "user"
+
+// bearerProtocol is the websocket subprotocol browsers, which can't set headers on websocket requests,
+// offer their bearer token after, as in "Sec-WebSocket-Protocol: bearer, <token>".
+const bearerProtocol = "bearer"
+
+type Authenticator interface {
+	// Authenticate is a gin middleware that resolves the bearer token of the request
+	// into a user through the auth service and stores it in the request context.
+	// Websocket requests may offer the token as a subprotocol after bearerProtocol instead.
+	// Requests without a valid token are aborted with 401.
+	Authenticate(c *gin.Context)
+}
+
+type authenticator struct {
+	asc pb.ServerAuthServiceClient
+}
+
+func NewAuthenticator(asc pb.ServerAuthServiceClient) Authenticator {
+	return &authenticator{
+		asc: asc,
+	}
+}
+
+func (a *authenticator) Authenticate(c *gin.Context) {
+	token, ok := bearerToken(c.Request)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+		return
+	}
+
+	resp, err := a.asc.UserInfo(c.Request.Context(), &pb.UserInfoRequest{
+		AccessToken: token,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "authentication service unavailable"})
+		default:
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		}
+		return
+	}
+
+	c.Set(userKey, &domain.User{
+		ID:       resp.GetId(),
+		Email:    resp.GetEmail(),
+		Name:     resp.GetName(),
+		Username: resp.Username,
+		Picture:  resp.Picture,
+	})
+
+	c.Next()
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok {
+		return token, strings.EqualFold(scheme, "Bearer") && token != ""
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		protocols := websocket.Subprotocols(r)
+		if len(protocols) == 2 && protocols[0] == bearerProtocol && protocols[1] != "" {
+			return protocols[1], true
+		}
+	}
+
+	return "", false
+}
+
+// currentUser returns the user stored by Authenticate.
+// It aborts the request with 401 when the route is not behind the middleware.
+func currentUser(c *gin.Context) (*domain.User, bool) {
+	if v, ok := c.Get(userKey); ok {
+		if user, ok := v.(*domain.User); ok {
+			return user, true
+		}
+	}
+
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthenticated"})
+	return nil, false
+}
@@ -0,0 +1,370 @@
+package controller
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/pkg/extractor"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxBatchEntries is the most documents the job service accepts for a batch.
+const maxBatchEntries = 100
+
+// batchDocument is a document of a batch upload, named after the file it came in or its path within the archive.
+type batchDocument struct {
+	name   string
+	doc    *extractor.Document
+	format extractor.Format
+	err    error
+}
+
+// archiveFile is an audio file put in the archive of a batch.
+type archiveFile struct {
+	name    string
+	fileKey string
+}
+
+func (cv *converter) TextToAudioBatch(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get files"})
+		return
+	}
+
+	callbackURL := c.PostForm("callback_url")
+	if callbackURL != "" && !validCallbackURL(callbackURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "callback_url must be an absolute https url"})
+		return
+	}
+
+	voice, ok := cv.voiceSettings(c)
+	if !ok {
+		return
+	}
+
+	var docs []batchDocument
+	for _, file := range form.File["file"] {
+		extracted, err := extractBatchFile(file)
+		if err != nil {
+			switch {
+			case errors.Is(err, extractor.ErrTooManyDocuments):
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("batch has more than %d documents", maxBatchEntries)})
+			case errors.Is(err, extractor.ErrNoText):
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": file.Filename + " has no documents"})
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read " + file.Filename})
+			}
+			return
+		}
+
+		docs = append(docs, extracted...)
+		if len(docs) > maxBatchEntries {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("batch has more than %d documents", maxBatchEntries)})
+			return
+		}
+	}
+
+	var invalid []gin.H
+	for _, d := range docs {
+		if d.err != nil {
+			_, msg := extractError(d.name, d.format, d.err)
+			invalid = append(invalid, gin.H{"name": d.name, "error": msg})
+		}
+	}
+	if len(invalid) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "some documents can't be converted", "documents": invalid})
+		return
+	}
+
+	req := &pb.NewBatchRequest{
+		UserId:      user.ID,
+		Entries:     make([]*pb.NewBatchEntry, 0, len(docs)),
+		CallbackUrl: callbackURL,
+		Voice:       voice,
+	}
+
+	for _, d := range docs {
+		entry := &pb.NewBatchEntry{Title: d.name}
+		filename := path.Base(d.name)
+
+		if chapters := d.doc.Split(maxChapterSize, maxChapters); len(chapters) > 1 {
+			for _, chapter := range chapters {
+				key, err := cv.saveText(c.Request.Context(), user, filename, &chapter.Document)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save text to S3"})
+					return
+				}
+
+				entry.Chapters = append(entry.Chapters, &pb.NewChapter{
+					Title:      chapter.Title,
+					FileKey:    key,
+					TextHash:   chapter.Hash(),
+					Paragraphs: chapter.ParagraphHashes(),
+				})
+			}
+		} else {
+			key, err := cv.saveText(c.Request.Context(), user, filename, d.doc)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save text to S3"})
+				return
+			}
+			entry.FileKey = key
+			entry.TextHash = d.doc.Hash()
+			entry.Paragraphs = d.doc.ParagraphHashes()
+		}
+
+		req.Entries = append(req.Entries, entry)
+	}
+
+	resp, err := cv.jsc.NewBatch(c.Request.Context(), req)
+	if err != nil {
+		createJobError(c, err)
+		return
+	}
+
+	// every job of the batch has its conversion request in the outbox
+	cv.rl.Nudge()
+
+	jobs := make([]gin.H, 0, len(resp.Batch.Items))
+	for _, item := range resp.Batch.Items {
+		jobs = append(jobs, gin.H{"id": item.Job.Id, "title": item.Title})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": resp.Batch.Id, "jobs": jobs})
+}
+
+// extractBatchFile extracts the documents of a file of a batch upload, every document of it when it is a zip archive.
+func extractBatchFile(file *multipart.FileHeader) ([]batchDocument, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	if !extractor.IsArchive(src, file.Size) {
+		doc, format, err := extractor.Extract(src, file.Size, file.Filename)
+		return []batchDocument{{name: file.Filename, doc: doc, format: format, err: err}}, nil
+	}
+
+	entries, err := extractor.ExtractArchive(src, file.Size, maxBatchEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]batchDocument, 0, len(entries))
+	for _, e := range entries {
+		docs = append(docs, batchDocument{name: e.Name, doc: e.Document, format: e.Format, err: e.Err})
+	}
+
+	return docs, nil
+}
+
+func (cv *converter) BatchStatus(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	batch, ok := cv.ownedBatch(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	counts := make(map[string]int)
+	done := 0
+	jobs := make([]gin.H, 0, len(batch.Items))
+	for _, item := range batch.Items {
+		job := item.Job
+		counts[job.Status.String()]++
+		if finished(job.Status.String()) {
+			done++
+		}
+
+		view := jobView(job)
+		view["title"] = item.Title
+		jobs = append(jobs, view)
+	}
+
+	total := len(batch.Items)
+	code := http.StatusAccepted
+	if done == total {
+		code = http.StatusOK
+	}
+
+	c.JSON(code, gin.H{
+		"id":         batch.Id,
+		"created_at": time.UnixMilli(batch.CreatedAt).UTC(),
+		"total":      total,
+		"finished":   done,
+		"progress":   float64(done) / float64(max(total, 1)),
+		"statuses":   counts,
+		"jobs":       jobs,
+	})
+}
+
+func (cv *converter) BatchArchive(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	batch, ok := cv.ownedBatch(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	files, err := cv.archiveFiles(c, batch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get job status"})
+		return
+	}
+
+	if len(files) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "batch has no completed audio yet"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": "batch-" + batch.Id + ".zip",
+	}))
+
+	// the archive is streamed as the audio is read, a failure midway leaving it truncated
+	zw := zip.NewWriter(c.Writer)
+	for _, f := range files {
+		if err = cv.archiveAudio(c, zw, user, f); err != nil {
+			slog.Error("Failed to archive batch audio", "batch", batch.Id, "file", f.name, "error", err)
+			if !c.Writer.Written() {
+				c.Writer.Header().Del("Content-Disposition")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get audio"})
+				return
+			}
+			c.Abort()
+			return
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		slog.Error("Failed to finish batch archive", "batch", batch.Id, "error", err)
+	}
+}
+
+// archiveFiles lists the completed audio of a batch, named after the document of each job,
+// and the chapters of split jobs within a folder of their document.
+func (cv *converter) archiveFiles(c *gin.Context, batch *pb.Batch) ([]archiveFile, error) {
+	names := make(map[string]bool)
+	unique := func(name string) string {
+		n := name
+		for i := 2; names[n]; i++ {
+			n = fmt.Sprintf("%s (%d)", name, i)
+		}
+		names[n] = true
+		return n
+	}
+
+	var files []archiveFile
+	for _, item := range batch.Items {
+		job := item.Job
+		base := archiveName(strings.TrimSuffix(path.Base(item.Title), path.Ext(item.Title)))
+		if len(job.ChapterIds) == 0 {
+			if job.Status == pb.Status_Completed {
+				files = append(files, archiveFile{name: unique(base), fileKey: job.FileKey})
+			}
+			continue
+		}
+
+		resp, err := cv.jsc.Get(c.Request.Context(), &pb.GetJobRequest{Id: job.Id})
+		if err != nil {
+			return nil, err
+		}
+
+		dir := unique(base)
+		for _, chapter := range resp.Chapters {
+			if chapter.Status == pb.Status_Completed {
+				name := fmt.Sprintf("%s/%02d %s", dir, chapter.Index+1, archiveName(chapter.Title))
+				files = append(files, archiveFile{name: strings.TrimSpace(name), fileKey: chapter.FileKey})
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// archiveAudio copies an audio file into the archive, stored as is since audio doesn't compress.
+func (cv *converter) archiveAudio(c *gin.Context, zw *zip.Writer, user *domain.User, f archiveFile) error {
+	key, err := cv.as.Key(user.ID, f.fileKey)
+	if err != nil {
+		return err
+	}
+
+	file, err := cv.as.Get(c.Request.Context(), user.ID, key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     f.name + file.Extension(),
+		Method:   zip.Store,
+		Modified: file.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, file.Body())
+	return err
+}
+
+// ownedBatch gets a batch of the user, writing the error response and returning false if there is none.
+func (cv *converter) ownedBatch(c *gin.Context, user *domain.User, id string) (*pb.Batch, bool) {
+	resp, err := cv.jsc.GetBatch(c.Request.Context(), &pb.GetBatchRequest{
+		UserId: user.ID,
+		Id:     id,
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "batch not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get batch"})
+		return nil, false
+	}
+
+	return resp.Batch, true
+}
+
+// archiveName makes a document or chapter title safe to name an entry of an archive with.
+func archiveName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', 0:
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(title))
+
+	if name == "" || name == "." || name == ".." {
+		return "untitled"
+	}
+
+	return name
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/ziliscite/bard_narate/gateway/internal/service"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+	"mime/multipart"
+	"net/http"
+)
+
+type VoiceConverter interface {
+	// VoiceConversion should take a multipart request of an audio file and an RVC voice model, and return a job id.
+	// The audio is the file field, a WAV or MP3 file, and the model the model field, the id of a model
+	// of the catalog. Without one, the audio is converted with the model bundled with the worker.
+	//
+	// Pipeline as follows:
+	//
+	// send audio to S3 ->
+	// create new voice conversion job, recording its conversion request in the outbox ->
+	// nudge the relay to publish the request on the voice conversion route key ->
+	// return job id to client
+	//
+	// The job is then followed and downloaded through the same endpoints as any other job.
+	// An https callback_url form field is notified once the job completes or fails, instead of the user's webhook.
+	VoiceConversion(c *gin.Context)
+
+	// Models lists the voice models of the catalog audio may be converted with.
+	Models(c *gin.Context)
+}
+
+type voiceConverter struct {
+	cs  service.ConversionService
+	mc  service.ModelCatalog
+	rl  service.Relay
+	jsc pb.JobServiceClient
+}
+
+func NewVoiceConverter(cs service.ConversionService, mc service.ModelCatalog, rl service.Relay, jsc pb.JobServiceClient) VoiceConverter {
+	return &voiceConverter{
+		cs:  cs,
+		mc:  mc,
+		rl:  rl,
+		jsc: jsc,
+	}
+}
+
+func (vcv *voiceConverter) VoiceConversion(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	audio, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file"})
+		return
+	}
+
+	modelKey, err := vcv.cs.ModelKey(user.ID, c.PostForm("model"))
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownModel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve model"})
+		return
+	}
+
+	callbackURL := c.PostForm("callback_url")
+	if callbackURL != "" && !validCallbackURL(callbackURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "callback_url must be an absolute https url"})
+		return
+	}
+
+	fileKey, ok := vcv.save(c, audio, func(src multipart.File) (string, error) {
+		return vcv.cs.SaveSource(c.Request.Context(), user.ID, audio.Filename, audio.Header.Get("Content-Type"), src)
+	})
+	if !ok {
+		return
+	}
+
+	resp, err := vcv.jsc.NewVoiceConversion(c.Request.Context(), &pb.NewVoiceConversionRequest{
+		UserId:      user.ID,
+		FileKey:     fileKey,
+		ModelKey:    modelKey,
+		CallbackUrl: callbackURL,
+	})
+	if err != nil {
+		createJobError(c, err)
+		return
+	}
+
+	// the relay publishes the request on the voice conversion route key
+	vcv.rl.Nudge()
+
+	c.JSON(http.StatusOK, gin.H{"id": resp.Job.Id})
+}
+
+// save opens an uploaded file and saves it with the given function, returning its key.
+// On failure the error response is written and false is returned.
+func (vcv *voiceConverter) save(c *gin.Context, file *multipart.FileHeader, save func(src multipart.File) (string, error)) (string, bool) {
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file"})
+		return "", false
+	}
+	defer src.Close()
+
+	key, err := save(src)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnsupportedAudio):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported file type. must be wav or mp3"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file to S3"})
+		}
+		return "", false
+	}
+
+	return key, true
+}
+
+func (vcv *voiceConverter) Models(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"models": vcv.mc.Models()})
+}
@@ -1,42 +1,144 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
 	"github.com/ziliscite/bard_narate/gateway/internal/service"
+	"github.com/ziliscite/bard_narate/gateway/pkg/audio"
+	"github.com/ziliscite/bard_narate/gateway/pkg/extractor"
 	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxChapterSize is the most characters of text converted by a single job, around an hour of speech.
+	// Longer documents are split into chapters converted in parallel.
+	maxChapterSize = 50_000
+	// maxChapters is the most chapters the job service accepts for a split job.
+	maxChapters = 32
 )
 
 type Converter interface {
-	// TextToAudio should take a multipart request of a text file and return a job id.
+	// TextToAudio should take a multipart request of a document and return a job id.
+	// Plain text, Markdown, HTML, EPUB, DOCX and PDF documents are accepted,
+	// their format being sniffed from their content rather than their declared content type.
 	//
 	// Pipeline as follows:
 	//
-	// create new job ->
-	// send file to S3 ->
-	// queue a conversion job ->
-	// update job to processing ->
+	// extract the text of the document ->
+	// send text to S3 ->
+	// create new job, recording its conversion request in the outbox ->
+	// nudge the relay to publish the request ->
 	// return job id to client
+	//
+	// Long documents are split at their chapters, each chapter's text being sent to S3 and converted by a job of its own.
+	// The job id returned is then that of the split job, which completes once every chapter has.
+	//
+	// An https callback_url form field is notified once the job completes or fails, instead of the user's webhook.
+	// The voice, speed and language form fields choose how the document is narrated, as validated against the voice
+	// catalog, the job keeping them for as long as it is converted.
+	// Clients retrying a submission send the same Idempotency-Key header, and get back the job the first attempt
+	// created instead of a duplicate, as with batches.
+	//
+	// The job service is given the hash of the normalised text along with the job, so that a text narrated with
+	// the same voice before, by any user, completes right away with the audio converted then.
+	//
+	// A previous_job_id form field names the job of the previous version of the document. Only the paragraphs edited
+	// since are converted again, the audio of the others being reused, even when they moved to another chapter.
+	// An unknown previous job is refused with 404.
 	TextToAudio(c *gin.Context)
+	// TextToAudioBatch takes a multipart request of many documents, as repeated file fields or as a zip archive of them,
+	// and converts each document by a job of its own, grouped under a batch whose id is returned along with theirs.
+	// Every document is checked before any is stored, so that a batch with a document that can't be converted
+	// is refused whole, listing what is wrong with each. The callback_url, voice, speed and language form fields
+	// apply to every job, and long documents are split at their chapters as they would be on their own.
+	TextToAudioBatch(c *gin.Context)
+	// BatchStatus returns the aggregate progress of a batch of the authenticated user, along with the status of each job.
+	BatchStatus(c *gin.Context)
+	// BatchArchive streams a zip archive of the audio of every completed job of a batch, named after its document.
+	// The completed chapters of split jobs are put in a folder of their document.
+	BatchArchive(c *gin.Context)
+	// ListJobs lists the jobs of the authenticated user, newest first, a page at a time.
+	// The status query parameter, repeated or comma separated, only lists the jobs in one of the statuses,
+	// and the created_after and created_before RFC 3339 timestamps those created in between.
+	// Chapters are left out, being listed by the status of their split job.
+	// The next_cursor of a page is given back as the cursor query parameter to get the next one,
+	// with the same filters, and is left out of the last page.
+	ListJobs(c *gin.Context)
+	// JobStatus returns the status of a job owned by the authenticated user.
+	// The status of a split job comes with those of its chapters, and the audio keys of the completed ones.
 	JobStatus(c *gin.Context)
+	// CancelJob cancels a job of the authenticated user that hasn't completed or failed yet, along with its chapters.
+	// The workers converting it are told to stop, and throw away what they converted so far.
+	// Cancelling a cancelled job does nothing, and finished jobs can't be cancelled.
+	//
+	// With the purge query parameter set to true, a finished job is deleted instead, along with its chapters
+	// and the audio no other job shares with it. Unfinished jobs have to be cancelled first.
+	CancelJob(c *gin.Context)
+	// RetryJob converts a failed job of the authenticated user again, from the file it was submitted with
+	// and with the same settings, so that it needn't be uploaded again. Only the failed chapters of a split job are retried.
+	// Jobs are converted at most three times, and the earlier attempts are listed along with their status.
+	RetryJob(c *gin.Context)
+	// DownloadAudio streams the converted audio of a completed job.
+	// Range and conditional requests are honoured so that players can seek and resume.
+	//
+	// The audio of a split job is streamed as a single WAV file, its chapters assembled in reading order
+	// with a pause between them, which neither seeks nor resumes. Chapters lists when each chapter plays in it.
+	DownloadAudio(c *gin.Context)
+	// Chapters lists the chapters of the audio of a completed split job, as downloaded by DownloadAudio,
+	// along with the seconds each starts and ends at.
+	Chapters(c *gin.Context)
+	// AudioURL returns a short-lived signed URL to the converted audio of a completed job,
+	// so that clients can fetch it directly from storage.
+	AudioURL(c *gin.Context)
+	// JobEvents streams the status changes of a job as server-sent events, or over a websocket when upgraded,
+	// until the job completes or fails. Each event is identified by the version of the job,
+	// and clients reconnecting with the Last-Event-ID header, or the last_event_id query parameter,
+	// get the events they missed, or the current status of the job when those are gone.
+	JobEvents(c *gin.Context)
+	// Voices lists the languages and voices of the catalog, only those of a language when the language query
+	// parameter is set, along with the speeds jobs may be narrated at.
+	Voices(c *gin.Context)
 }
 
 type converter struct {
 	ts  service.TextService
-	ps  service.Publisher
+	as  service.AudioService
+	rl  service.Relay
+	ev  service.JobEvents
+	vc  service.VoiceCatalog
 	jsc pb.JobServiceClient
 }
 
-func NewConverter(ts service.TextService, ps service.Publisher, jsc pb.JobServiceClient) Converter {
+func NewConverter(ts service.TextService, as service.AudioService, rl service.Relay, ev service.JobEvents, vc service.VoiceCatalog, jsc pb.JobServiceClient) Converter {
 	// r.MaxMultipartMemory = 1 << 30 // 1GB
 	return &converter{
 		ts:  ts,
-		ps:  ps,
+		as:  as,
+		rl:  rl,
+		ev:  ev,
+		vc:  vc,
 		jsc: jsc,
 	}
 }
 
 func (cv *converter) TextToAudio(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file"})
@@ -49,21 +151,39 @@ func (cv *converter) TextToAudio(c *gin.Context) {
 		return
 	}
 
-	// check the content type
-	mimeType := file.Header.Get("Content-Type")
-	if mimeType != "text/plain" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file type. must be text/plain"})
+	callbackURL := c.PostForm("callback_url")
+	if callbackURL != "" && !validCallbackURL(callbackURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "callback_url must be an absolute https url"})
+		return
+	}
+
+	voice, ok := cv.voiceSettings(c)
+	if !ok {
 		return
 	}
 
-	txt, err := file.Open()
+	previousID := c.PostForm("previous_job_id")
+
+	src, err := file.Open()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file"})
 		return
 	}
-	defer txt.Close()
+	defer src.Close()
 
-	key, err := cv.ts.Save(c.Request.Context(), file.Filename, txt)
+	doc, format, err := extractor.Extract(src, file.Size, file.Filename)
+	if err != nil {
+		code, msg := extractError(file.Filename, format, err)
+		c.JSON(code, gin.H{"error": msg})
+		return
+	}
+
+	if chapters := doc.Split(maxChapterSize, maxChapters); len(chapters) > 1 {
+		cv.splitToAudio(c, user, file.Filename, chapters, previousID, callbackURL, voice)
+		return
+	}
+
+	key, err := cv.saveText(c.Request.Context(), user, file.Filename, doc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save text to S3"})
 		return
@@ -71,53 +191,664 @@ func (cv *converter) TextToAudio(c *gin.Context) {
 
 	// create a new job, take from other grpc serv
 	resp, err := cv.jsc.New(c.Request.Context(), &pb.NewJobRequest{
-		FileKey: key,
+		FileKey:     key,
+		UserId:      user.ID,
+		CallbackUrl: callbackURL,
+		Voice:       voice,
+		TextHash:    doc.Hash(),
+		Paragraphs:  doc.ParagraphHashes(),
+		PreviousId:  previousID,
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create job"})
+		createJobError(c, err)
 		return
 	}
 
-	// publish to file exchange
-	// this should be consumed by tts service AND job update service
-	if err = cv.ps.PublishConversion(c.Request.Context(), resp.Job.Id, resp.Job.FileKey); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to publish job"})
+	// the job service recorded the conversion request in its outbox along with the job,
+	// the relay publishes it to the file exchange, retrying until the broker takes it
+	cv.rl.Nudge()
+
+	c.JSON(http.StatusOK, gin.H{"id": resp.Job.Id})
+}
+
+// splitToAudio saves the text of each chapter and creates a split job converting them.
+func (cv *converter) splitToAudio(c *gin.Context, user *domain.User, filename string, chapters []extractor.Chapter, previousID, callbackURL string, voice *pb.VoiceSettings) {
+	req := &pb.NewSplitJobRequest{
+		UserId:      user.ID,
+		Chapters:    make([]*pb.NewChapter, 0, len(chapters)),
+		CallbackUrl: callbackURL,
+		Voice:       voice,
+		PreviousId:  previousID,
+	}
+
+	for _, chapter := range chapters {
+		key, err := cv.saveText(c.Request.Context(), user, filename, &chapter.Document)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save text to S3"})
+			return
+		}
+
+		req.Chapters = append(req.Chapters, &pb.NewChapter{
+			Title:      chapter.Title,
+			FileKey:    key,
+			TextHash:   chapter.Hash(),
+			Paragraphs: chapter.ParagraphHashes(),
+		})
+	}
+
+	resp, err := cv.jsc.NewSplit(c.Request.Context(), req)
+	if err != nil {
+		createJobError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"id": resp.Job.Id})
+	// every chapter has its conversion request in the outbox
+	cv.rl.Nudge()
+
+	ids := make([]string, 0, len(resp.Chapters))
+	for _, chapter := range resp.Chapters {
+		ids = append(ids, chapter.Id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": resp.Job.Id, "chapters": ids})
+}
+
+// voiceSettings resolves the voice, speed and language form fields against the voice catalog.
+// On failure the error response is written and false is returned.
+func (cv *converter) voiceSettings(c *gin.Context) (*pb.VoiceSettings, bool) {
+	var speed float64
+	if s := c.PostForm("speed"); s != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(s, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "speed must be a number"})
+			return nil, false
+		}
+	}
+
+	voice, err := cv.vc.Resolve(c.PostForm("voice"), speed, c.PostForm("language"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return &pb.VoiceSettings{
+		Voice:    voice.Voice,
+		Speed:    voice.Speed,
+		Language: voice.Language,
+	}, true
+}
+
+func (cv *converter) Voices(c *gin.Context) {
+	language := c.Query("language")
+	voices := cv.vc.Voices(language)
+	if language != "" && voices == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown language"})
+		return
+	}
+
+	slowest, fastest := cv.vc.Speeds()
+	c.JSON(http.StatusOK, gin.H{
+		"languages": cv.vc.Languages(),
+		"voices":    voices,
+		"speed":     gin.H{"min": slowest, "max": fastest},
+	})
+}
+
+// extractError returns the status code and message of a document whose text couldn't be extracted.
+func extractError(filename string, format extractor.Format, err error) (int, string) {
+	switch {
+	case errors.Is(err, extractor.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType, "unsupported file type. must be plain text, markdown, html, epub, docx or pdf"
+	case errors.Is(err, extractor.ErrNoText):
+		return http.StatusUnprocessableEntity, "file has no readable text"
+	case errors.Is(err, extractor.ErrTooLarge):
+		return http.StatusUnprocessableEntity, "file too large"
+	default:
+		slog.Warn("failed to extract text", "filename", filename, "format", format, "error", err)
+		return http.StatusUnprocessableEntity, "failed to read " + string(format) + " file"
+	}
+}
+
+// createJobError writes the response of a job the job service failed to create.
+func createJobError(c *gin.Context, err error) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		c.JSON(http.StatusBadRequest, gin.H{"error": status.Convert(err).Message()})
+		return
+	case codes.NotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": status.Convert(err).Message()})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create job"})
+}
+
+// saveText sends the text of the document to S3, returning its key.
+func (cv *converter) saveText(ctx context.Context, user *domain.User, filename string, doc *extractor.Document) (string, error) {
+	var txt bytes.Buffer
+	if _, err := doc.WriteTo(&txt); err != nil {
+		return "", err
+	}
+
+	return cv.ts.Save(ctx, user.ID, filename, &txt)
+}
+
+func (cv *converter) ListJobs(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	req := &pb.ListJobsRequest{
+		UserId: user.ID,
+		Cursor: c.Query("cursor"),
+	}
+
+	for _, param := range c.QueryArray("status") {
+		for _, name := range strings.Split(param, ",") {
+			st, ok := pb.Status_value[name]
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status " + strconv.Quote(name)})
+				return
+			}
+			req.Statuses = append(req.Statuses, pb.Status(st))
+		}
+	}
+
+	for _, bound := range []struct {
+		param string
+		ms    *int64
+	}{{"created_after", &req.CreatedAfter}, {"created_before", &req.CreatedBefore}} {
+		if v := c.Query(bound.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": bound.param + " must be an RFC 3339 timestamp"})
+				return
+			}
+			*bound.ms = t.UnixMilli()
+		}
+	}
+
+	if l := c.Query("limit"); l != "" {
+		limit, err := strconv.ParseUint(l, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		req.Limit = uint32(limit)
+	}
+
+	resp, err := cv.jsc.ListJobs(c.Request.Context(), req)
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			c.JSON(http.StatusBadRequest, gin.H{"error": status.Convert(err).Message()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list jobs"})
+		return
+	}
+
+	jobs := make([]gin.H, 0, len(resp.Jobs))
+	for _, job := range resp.Jobs {
+		jobs = append(jobs, jobView(job))
+	}
+
+	page := gin.H{"jobs": jobs}
+	if resp.NextCursor != "" {
+		page["next_cursor"] = resp.NextCursor
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// jobView is how a job is listed to its user.
+func jobView(job *pb.Job) gin.H {
+	view := gin.H{
+		"id":         job.Id,
+		"kind":       job.Kind.String(),
+		"status":     job.Status.String(),
+		"created_at": time.UnixMilli(job.CreatedAt).UTC(),
+		"updated_at": time.UnixMilli(job.UpdatedAt).UTC(),
+	}
+	if job.Title != "" {
+		view["title"] = job.Title
+	}
+	if len(job.ChapterIds) > 0 {
+		view["chapters"] = job.ChapterIds
+	}
+	if job.PreviousId != "" {
+		view["previous_job_id"] = job.PreviousId
+	}
+	addAttempts(view, job)
+
+	return view
+}
+
+// addAttempts adds the attempt a job is at to its view, along with the history of the previous ones if it was retried.
+func addAttempts(view gin.H, job *pb.Job) {
+	view["attempt"] = max(job.Attempt, 1)
+	if len(job.Attempts) == 0 {
+		return
+	}
+
+	attempts := make([]gin.H, 0, len(job.Attempts))
+	for _, a := range job.Attempts {
+		attempts = append(attempts, gin.H{
+			"attempt":  a.Attempt,
+			"status":   a.Status.String(),
+			"ended_at": time.UnixMilli(a.EndedAt).UTC(),
+		})
+	}
+	view["attempts"] = attempts
 }
 
 func (cv *converter) JobStatus(c *gin.Context) {
-	id := c.Param("id")
-	resp, err := cv.jsc.Get(c.Request.Context(), &pb.GetJobRequest{
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	resp, ok := cv.ownedJob(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	job := resp.Job
+	if len(job.ChapterIds) > 0 {
+		cv.splitJobStatus(c, user, job, resp.Chapters)
+		return
+	}
+
+	if job.Status != pb.Status_Completed {
+		view := gin.H{"status": job.Status.String()}
+		addAttempts(view, job)
+		c.JSON(http.StatusAccepted, view)
+		return
+	}
+
+	key, err := cv.as.Key(user.ID, job.FileKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue audio key"})
+		return
+	}
+
+	view := gin.H{
+		"id":       job.Id,
+		"status":   job.Status.String(),
+		"file_key": key,
+	}
+	addAttempts(view, job)
+	c.JSON(http.StatusOK, view)
+}
+
+// splitJobStatus writes the status of a split job along with those of its chapters.
+func (cv *converter) splitJobStatus(c *gin.Context, user *domain.User, job *pb.Job, chapters []*pb.Job) {
+	statuses := make([]gin.H, 0, len(chapters))
+	for _, chapter := range chapters {
+		status := gin.H{
+			"id":     chapter.Id,
+			"index":  chapter.Index,
+			"title":  chapter.Title,
+			"status": chapter.Status.String(),
+		}
+
+		if chapter.Status == pb.Status_Completed {
+			key, err := cv.as.Key(user.ID, chapter.FileKey)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue audio key"})
+				return
+			}
+			status["file_key"] = key
+		}
+
+		addAttempts(status, chapter)
+		statuses = append(statuses, status)
+	}
+
+	code := http.StatusAccepted
+	if job.Status == pb.Status_Completed {
+		code = http.StatusOK
+	}
+
+	view := gin.H{
+		"id":       job.Id,
+		"status":   job.Status.String(),
+		"chapters": statuses,
+	}
+	addAttempts(view, job)
+	c.JSON(code, view)
+}
+
+func (cv *converter) CancelJob(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if _, ok = cv.ownedJob(c, user, c.Param("id")); !ok {
+		return
+	}
+
+	if purge, _ := strconv.ParseBool(c.Query("purge")); purge {
+		cv.deleteJob(c, c.Param("id"))
+		return
+	}
+
+	resp, err := cv.jsc.CancelJob(c.Request.Context(), &pb.CancelJobRequest{
+		Id: c.Param("id"),
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.FailedPrecondition:
+			c.JSON(http.StatusConflict, gin.H{"error": status.Convert(err).Message()})
+		case codes.NotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel job"})
+		}
+		return
+	}
+
+	// the cancellation is in the outbox along with the job
+	cv.rl.Nudge()
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":     resp.Job.Id,
+		"status": resp.Job.Status.String(),
+	})
+}
+
+// deleteJob deletes a finished job, then the audio no other job uses anymore.
+func (cv *converter) deleteJob(c *gin.Context, id string) {
+	resp, err := cv.jsc.DeleteJob(c.Request.Context(), &pb.DeleteJobRequest{
 		Id: id,
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get job status"})
+		switch status.Code(err) {
+		case codes.FailedPrecondition:
+			c.JSON(http.StatusConflict, gin.H{"error": status.Convert(err).Message()})
+		case codes.NotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete job"})
+		}
 		return
 	}
 
-	if resp.Job.Status != pb.Status_Completed {
-		c.JSON(http.StatusAccepted, gin.H{"status": resp.Job.Status.String()})
+	// the job is gone either way, and audio left behind is only wasted storage
+	ctx := context.WithoutCancel(c.Request.Context())
+	for _, key := range resp.UnusedFileKeys {
+		if err = cv.as.Delete(ctx, key); err != nil {
+			slog.Error("failed to delete audio", "job", id, "key", key, "error", err)
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (cv *converter) RetryJob(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if _, ok = cv.ownedJob(c, user, c.Param("id")); !ok {
+		return
+	}
+
+	resp, err := cv.jsc.RetryJob(c.Request.Context(), &pb.RetryJobRequest{
+		Id: c.Param("id"),
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.FailedPrecondition, codes.ResourceExhausted:
+			c.JSON(http.StatusConflict, gin.H{"error": status.Convert(err).Message()})
+		case codes.NotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retry job"})
+		}
+		return
+	}
+
+	// the conversion request is in the outbox along with the job
+	cv.rl.Nudge()
+
+	view := gin.H{
+		"id":     resp.Job.Id,
+		"status": resp.Job.Status.String(),
+	}
+	addAttempts(view, resp.Job)
+	c.JSON(http.StatusAccepted, view)
+}
+
+func (cv *converter) DownloadAudio(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	resp, ok := cv.ownedJob(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	job := resp.Job
+	if len(job.ChapterIds) > 0 {
+		cv.downloadBook(c, resp)
+		return
+	}
+
+	key, ok := cv.audioKey(c, user, job)
+	if !ok {
+		return
+	}
+
+	file, err := cv.as.Get(c.Request.Context(), user.ID, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "audio not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get audio"})
+		}
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", file.Type())
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": job.Id + file.Extension(),
+	}))
+	if etag := file.ETag(); etag != "" {
+		c.Header("ETag", etag)
+	}
+
+	// ServeContent takes care of Range, If-Range, If-None-Match and If-Modified-Since
+	if rs, ok := file.Body().(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", file.ModTime(), rs)
+		return
+	}
+
+	c.Header("Accept-Ranges", "none")
+	if size := file.Size(); size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	c.Status(http.StatusOK)
+	if _, err = io.Copy(c.Writer, file.Body()); err != nil {
+		slog.Error("failed to stream audio", "job", job.Id, "error", err)
+	}
+}
+
+// downloadBook streams the audio of the chapters of a split job as a single file.
+func (cv *converter) downloadBook(c *gin.Context, resp *pb.GetJobResponse) {
+	book, ok := cv.book(c, resp)
+	if !ok {
 		return
 	}
 
+	c.Header("Content-Type", "audio/wav")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": resp.Job.Id + ".wav",
+	}))
+	c.Header("Accept-Ranges", "none")
+	c.Header("Content-Length", strconv.FormatInt(book.Size(), 10))
+	c.Status(http.StatusOK)
+
+	// the chapters are only streamed from storage when the book is actually downloaded
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := book.WriteTo(c.Writer); err != nil {
+		slog.Error("failed to stream audio", "job", resp.Job.Id, "error", err)
+	}
+}
+
+func (cv *converter) Chapters(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	resp, ok := cv.ownedJob(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if len(resp.Job.ChapterIds) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "job isn't split into chapters"})
+		return
+	}
+
+	book, ok := cv.book(c, resp)
+	if !ok {
+		return
+	}
+
+	manifest := book.Manifest()
+	chapters := make([]gin.H, 0, len(manifest.Chapters))
+	for i, m := range manifest.Chapters {
+		chapters = append(chapters, gin.H{
+			"id":    resp.Chapters[i].Id,
+			"title": m.Title,
+			"start": m.Start.Seconds(),
+			"end":   m.End.Seconds(),
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"id":       resp.Job.Id,
-		"status":   resp.Job.Status.String(),
-		"file_key": resp.Job.FileKey,
+		"duration": manifest.Duration.Seconds(),
+		"chapters": chapters,
 	})
 }
 
-// DownloadAudio download audio file from s3 using the filekey
-func (cv *converter) DownloadAudio(c *gin.Context) {
-	id := c.Param("id")
-	_, err := cv.jsc.Get(c.Request.Context(), &pb.GetJobRequest{
+// book plans the audio of a completed split job from that of its chapters.
+// On failure the error response is written and false is returned.
+func (cv *converter) book(c *gin.Context, resp *pb.GetJobResponse) (*audio.Assembly, bool) {
+	if resp.Job.Status != pb.Status_Completed {
+		c.JSON(http.StatusConflict, gin.H{"error": "job is not completed", "status": resp.Job.Status.String()})
+		return nil, false
+	}
+
+	chapters := make([]service.BookChapter, 0, len(resp.Chapters))
+	for _, chapter := range resp.Chapters {
+		chapters = append(chapters, service.BookChapter{
+			Title:     chapter.Title,
+			ObjectKey: chapter.FileKey,
+		})
+	}
+
+	book, err := cv.as.Book(c.Request.Context(), chapters)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "audio not found"})
+		default:
+			slog.Error("failed to assemble audio", "job", resp.Job.Id, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to assemble audio"})
+		}
+		return nil, false
+	}
+
+	return book, true
+}
+
+func (cv *converter) AudioURL(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	resp, ok := cv.ownedJob(c, user, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	job := resp.Job
+	key, ok := cv.audioKey(c, user, job)
+	if !ok {
+		return
+	}
+
+	url, expiresAt, err := cv.as.URL(c.Request.Context(), user.ID, key)
+	if errors.Is(err, service.ErrURLsDisabled) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "signed audio urls are disabled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign audio url"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"expires_at": expiresAt,
+	})
+}
+
+// ownedJob fetches a job, along with its chapters, and makes sure it was created by the user.
+// The jobs of other users are not found, as missing ones, so that their ids can't be probed.
+// On failure the error response is written and false is returned.
+func (cv *converter) ownedJob(c *gin.Context, user *domain.User, id string) (*pb.GetJobResponse, bool) {
+	resp, err := cv.jsc.Get(c.Request.Context(), &pb.GetJobRequest{
 		Id: id,
 	})
-	if err != nil {
+	switch {
+	case status.Code(err) == codes.NotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return nil, false
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get job status"})
-		return
+		return nil, false
+	case resp.Job.UserId != user.ID:
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// audioKey checks that the job is completed and issues the key of its audio to the user.
+// On failure the error response is written and false is returned.
+func (cv *converter) audioKey(c *gin.Context, user *domain.User, job *pb.Job) (string, bool) {
+	if len(job.ChapterIds) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "job is split into chapters, whose audio is downloaded as a single file or fetched one by one", "chapters": job.ChapterIds})
+		return "", false
+	}
+
+	if job.Status != pb.Status_Completed {
+		c.JSON(http.StatusConflict, gin.H{"error": "job is not completed", "status": job.Status.String()})
+		return "", false
 	}
+
+	key, err := cv.as.Key(user.ID, job.FileKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue audio key"})
+		return "", false
+	}
+
+	return key, true
 }
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/internal/service"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// keepaliveInterval is how often an idle event stream is written to, so that proxies don't time it out.
+	keepaliveInterval = 15 * time.Second
+	// eventWriteTimeout bounds how long a single write to a websocket client may take.
+	eventWriteTimeout = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{bearerProtocol},
+	// clients authenticate with a bearer token rather than cookies, so cross-origin requests carry no ambient credentials
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// eventStream writes job events to a client.
+// Websocket clients get the id of an event as its version, and resume with the last_event_id query parameter.
+type eventStream interface {
+	send(id uint64, event gin.H) error
+	keepalive() error
+}
+
+type sseStream struct {
+	w gin.ResponseWriter
+}
+
+func (s sseStream) send(id uint64, event gin.H) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(s.w, "id: %d\nevent: status\ndata: %s\n\n", id, data); err != nil {
+		return err
+	}
+
+	s.w.Flush()
+	return nil
+}
+
+func (s sseStream) keepalive() error {
+	if _, err := fmt.Fprint(s.w, ": keepalive\n\n"); err != nil {
+		return err
+	}
+
+	s.w.Flush()
+	return nil
+}
+
+type wsStream struct {
+	conn *websocket.Conn
+}
+
+func (s wsStream) send(_ uint64, event gin.H) error {
+	s.conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+	return s.conn.WriteJSON(event)
+}
+
+func (s wsStream) keepalive() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout))
+}
+
+func (cv *converter) JobEvents(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	after, err := lastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last event id"})
+		return
+	}
+
+	// subscribing before reading the job means no event can slip in between the two
+	id := c.Param("id")
+	history, events, cancel := cv.ev.Subscribe(id, after)
+	defer cancel()
+
+	resp, ok := cv.ownedJob(c, user, id)
+	if !ok {
+		return
+	}
+
+	// the job itself stands for the events that are no longer, or not yet, recorded
+	job := resp.Job
+	current := service.JobEvent{
+		JobID:    job.Id,
+		UserID:   job.UserId,
+		ParentID: job.ParentId,
+		Status:   job.Status.String(),
+		FileKey:  job.FileKey,
+		Version:  job.Version,
+	}
+	if current.Version <= after && finished(current.Status) {
+		// tells event source clients not to reconnect
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	ctx, stop := context.WithCancel(c.Request.Context())
+	defer stop()
+
+	var stream eventStream
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// the upgrader has written the error response
+			return
+		}
+		defer conn.Close()
+
+		// reading is what processes pongs and close frames, the client isn't expected to send anything else
+		go func() {
+			defer stop()
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+		stream = wsStream{conn: conn}
+	} else {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		stream = sseStream{w: c.Writer}
+	}
+
+	last := after
+	send := func(e service.JobEvent) (bool, error) {
+		if e.Version <= last {
+			return false, nil
+		}
+
+		event, err := cv.eventView(user, e)
+		if err != nil {
+			return false, err
+		}
+
+		if err = stream.send(e.Version, event); err != nil {
+			return false, err
+		}
+		last = e.Version
+
+		return finished(e.Status), nil
+	}
+
+	for _, e := range append(history, current) {
+		if done, err := send(e); err != nil || done {
+			logStreamEnd(job.Id, err)
+			return
+		}
+	}
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				// fallen behind, the client resumes from its last event
+				return
+			}
+
+			if done, err := send(e); err != nil || done {
+				logStreamEnd(job.Id, err)
+				return
+			}
+		case <-ticker.C:
+			if err := stream.keepalive(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// eventView is what the client sees of a job event, with the file key of completed jobs issued to the user.
+func (cv *converter) eventView(user *domain.User, e service.JobEvent) (gin.H, error) {
+	event := gin.H{
+		"id":      e.JobID,
+		"status":  e.Status,
+		"version": e.Version,
+	}
+
+	if e.Status == pb.Status_Completed.String() && e.FileKey != "" {
+		key, err := cv.as.Key(user.ID, e.FileKey)
+		if err != nil {
+			return nil, err
+		}
+		event["file_key"] = key
+	}
+
+	return event, nil
+}
+
+func logStreamEnd(jobID string, err error) {
+	if err != nil {
+		slog.Warn("Job event stream failed", "job", jobID, "error", err)
+	}
+}
+
+// lastEventID returns the version of the last event the client got, from the Last-Event-ID header event sources
+// send when reconnecting, or the last_event_id query parameter of clients that can't set headers.
+// It returns 0 when the client got none.
+func lastEventID(c *gin.Context) (uint64, error) {
+	id := c.GetHeader("Last-Event-ID")
+	if id == "" {
+		id = c.Query("last_event_id")
+	}
+
+	if id == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(id, 10, 64)
+}
+
+// finished reports whether a job status is final, after which its event stream ends.
+func finished(status string) bool {
+	return status == pb.Status_Completed.String() || status == pb.Status_Failed.String() || status == pb.Status_Cancelled.String()
+}
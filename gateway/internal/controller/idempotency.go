@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/ziliscite/bard_narate/gateway/internal/service"
+	"hash"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"slices"
+)
+
+const (
+	// idempotencyHeader carries the key clients retry a request with, so that it is only handled once.
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader is set on responses replayed to the retries of a request.
+	replayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKey is the longest key accepted, long enough for any UUID or ULID.
+	maxIdempotencyKey = 255
+)
+
+type Idempotency interface {
+	// Idempotent is a gin middleware that handles a request made with an Idempotency-Key header only once,
+	// replaying its response to the retries made with the same key for 24 hours.
+	// Keys are scoped to the authenticated user, so it goes after Authenticate.
+	//
+	// A key reused for a different request is refused with 422, and a retry made while the original request
+	// is still being handled with 409. Requests that don't succeed free their key, so that they may be retried.
+	// Requests without the header are handled as usual.
+	Idempotent(c *gin.Context)
+}
+
+type idempotency struct {
+	is service.IdempotencyService
+}
+
+func NewIdempotency(is service.IdempotencyService) Idempotency {
+	return &idempotency{
+		is: is,
+	}
+}
+
+// responseRecorder keeps a copy of the body written to the client.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (i *idempotency) Idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+
+	if len(key) > maxIdempotencyKey {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", idempotencyHeader, maxIdempotencyKey)})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	fingerprint, err := requestFingerprint(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request"})
+		return
+	}
+
+	saved, err := i.is.Begin(c.Request.Context(), user.ID, key, fingerprint)
+	switch {
+	case errors.Is(err, service.ErrKeyReused):
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrRequestInProgress):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		slog.Error("Failed to reserve idempotency key", "error", err)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "failed to check idempotency key"})
+		return
+	case saved != nil:
+		c.Header(replayedHeader, "true")
+		c.Data(saved.StatusCode, "application/json; charset=utf-8", saved.Body)
+		c.Abort()
+		return
+	}
+
+	rec := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = rec
+	c.Next()
+
+	// the outcome is saved even if the client went away meanwhile, which is when it is most likely to retry
+	ctx := context.WithoutCancel(c.Request.Context())
+	if code := rec.Status(); code < 200 || code >= 300 {
+		if err = i.is.Abandon(ctx, user.ID, key); err != nil {
+			slog.Error("Failed to free idempotency key", "error", err)
+		}
+		return
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(rec.body.Bytes(), &resp)
+
+	if err = i.is.Complete(ctx, user.ID, key, fingerprint, rec.Status(), rec.body.Bytes(), resp.ID); err != nil {
+		slog.Error("Failed to save idempotent response", "job", resp.ID, "error", err)
+	}
+}
+
+// requestFingerprint hashes what a request asks for: its method, URL and the fields and files of its form.
+// Multipart requests are hashed by their parsed form rather than their bytes,
+// as clients pick a new boundary every time they encode the same form.
+func requestFingerprint(c *gin.Context) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %q\n", c.Request.Method, c.Request.URL.RequestURI())
+
+	if c.ContentType() != "multipart/form-data" {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		h.Write(body)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range sortedKeys(form.Value) {
+		for _, v := range form.Value[name] {
+			fmt.Fprintf(h, "%q=%q\n", name, v)
+		}
+	}
+
+	for _, name := range sortedKeys(form.File) {
+		for _, file := range form.File[name] {
+			fmt.Fprintf(h, "%q=%q:%d:", name, file.Filename, file.Size)
+			if err = hashFile(h, file); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h hash.Hash, file *multipart.FileHeader) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(h, src)
+	return err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Webhooks interface {
+	// SetWebhook registers the URL the user's jobs are notified to once they complete or fail,
+	// unless they were submitted with a callback_url of their own.
+	// The response carries the secret every delivery to the user is signed with,
+	// as the X-Bard-Signature header "t=<unix seconds>,v1=<hex HMAC-SHA256 of the timestamp, a dot and the body>".
+	SetWebhook(c *gin.Context)
+	// GetWebhook returns the webhook of the user, along with its secret.
+	GetWebhook(c *gin.Context)
+	// DeleteWebhook removes the webhook of the user, secret included.
+	DeleteWebhook(c *gin.Context)
+	// Deliveries lists the latest deliveries to the user, with the log of the attempts at each,
+	// only those of a job when the job_id query parameter is set.
+	Deliveries(c *gin.Context)
+	// ReplayDelivery sends a delivery again, whether it was received or given up on.
+	ReplayDelivery(c *gin.Context)
+}
+
+type webhooks struct {
+	jsc pb.JobServiceClient
+}
+
+func NewWebhooks(jsc pb.JobServiceClient) Webhooks {
+	return &webhooks{
+		jsc: jsc,
+	}
+}
+
+type setWebhookRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+func (wh *webhooks) SetWebhook(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req setWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !validCallbackURL(req.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an absolute https url"})
+		return
+	}
+
+	resp, err := wh.jsc.SetWebhook(c.Request.Context(), &pb.SetWebhookRequest{
+		UserId: user.ID,
+		Url:    req.URL,
+	})
+	if err != nil {
+		webhookError(c, err, "failed to set webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookView(resp.Webhook))
+}
+
+func (wh *webhooks) GetWebhook(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	resp, err := wh.jsc.GetWebhook(c.Request.Context(), &pb.GetWebhookRequest{
+		UserId: user.ID,
+	})
+	if err != nil {
+		webhookError(c, err, "failed to get webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookView(resp.Webhook))
+}
+
+func (wh *webhooks) DeleteWebhook(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if _, err := wh.jsc.DeleteWebhook(c.Request.Context(), &pb.DeleteWebhookRequest{
+		UserId: user.ID,
+	}); err != nil {
+		webhookError(c, err, "failed to delete webhook")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (wh *webhooks) Deliveries(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var limit uint64
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.ParseUint(l, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	resp, err := wh.jsc.ListDeliveries(c.Request.Context(), &pb.ListDeliveriesRequest{
+		UserId: user.ID,
+		JobId:  c.Query("job_id"),
+		Limit:  uint32(limit),
+	})
+	if err != nil {
+		webhookError(c, err, "failed to list deliveries")
+		return
+	}
+
+	deliveries := make([]gin.H, 0, len(resp.Deliveries))
+	for _, d := range resp.Deliveries {
+		deliveries = append(deliveries, deliveryView(d))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+func (wh *webhooks) ReplayDelivery(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	resp, err := wh.jsc.ReplayDelivery(c.Request.Context(), &pb.ReplayDeliveryRequest{
+		UserId: user.ID,
+		Id:     c.Param("id"),
+	})
+	if err != nil {
+		webhookError(c, err, "failed to replay delivery")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, deliveryView(resp.Delivery))
+}
+
+// webhookError writes the response of a failed webhook call to the job service.
+func webhookError(c *gin.Context, err error, msg string) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		c.JSON(http.StatusBadRequest, gin.H{"error": status.Convert(err).Message()})
+	case codes.NotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": status.Convert(err).Message()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+func webhookView(w *pb.Webhook) gin.H {
+	return gin.H{
+		"url":    w.Url,
+		"secret": w.Secret,
+	}
+}
+
+func deliveryView(d *pb.Delivery) gin.H {
+	attempts := make([]gin.H, 0, len(d.Attempts))
+	for _, a := range d.Attempts {
+		attempt := gin.H{
+			"at":          time.UnixMilli(a.At).UTC(),
+			"status_code": a.StatusCode,
+			"duration_ms": a.DurationMs,
+		}
+		if a.Error != "" {
+			attempt["error"] = a.Error
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	view := gin.H{
+		"id":         d.Id,
+		"job_id":     d.JobId,
+		"url":        d.Url,
+		"event":      d.Event,
+		"status":     strings.TrimPrefix(d.Status.String(), "Delivery"),
+		"attempts":   attempts,
+		"payload":    json.RawMessage(d.Payload),
+		"created_at": time.UnixMilli(d.CreatedAt).UTC(),
+	}
+	if d.Status == pb.DeliveryStatus_DeliveryPending {
+		view["next_attempt_at"] = time.UnixMilli(d.NextAttemptAt).UTC()
+	}
+
+	return view
+}
+
+// validCallbackURL reports whether a URL may be notified, being absolute and over https.
+// The job service checks it again, and refuses to deliver to addresses that aren't public.
+func validCallbackURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && u.Hostname() != "" && u.User == nil
+}
@@ -1,30 +1,80 @@
 package domain
 
-import "io"
+import (
+	"io"
+	"path"
+	"time"
+)
+
+// mimeTypes maps the MIME types the gateway deals with to their file extension.
+var mimeTypes = map[string]string{
+	"application/octet-stream": ".pth",
+	"audio/wav":                ".wav",
+	"audio/mpeg":               ".mp3",
+	"text/plain":               ".txt",
+}
 
 type File struct {
 	name  string
 	types string
 	body  io.Reader
+
+	size    int64
+	etag    string
+	modTime time.Time
+	meta    map[string]string
 }
 
+// NewFile creates a file of the given MIME type.
+// Unknown types fall back to the type implied by the name's extension, then to application/octet-stream.
 func NewFile(name, mimetype string, body io.Reader) *File {
-	var mimeTypes = map[string]string{
-		"application/octet-stream": ".pth",
-		"audio/wav":                ".wav",
-		"audio/mpeg":               ".mp3",
-		"text/plain":               ".txt",
-	}
-
 	if _, ok := mimeTypes[mimetype]; !ok {
-		mimetype = "application/octet-stream"
+		mimetype = TypeByExtension(path.Ext(name))
 	}
 
 	return &File{
 		name:  name,
 		types: mimetype,
 		body:  body,
+		size:  -1,
+	}
+}
+
+// TypeByExtension returns the MIME type associated with the extension ext, which should begin with a leading dot.
+// It returns application/octet-stream for unknown extensions.
+func TypeByExtension(ext string) string {
+	for mimetype, e := range mimeTypes {
+		if e == ext && mimetype != "application/octet-stream" {
+			return mimetype
+		}
+	}
+
+	return "application/octet-stream"
+}
+
+// ExtensionByType returns the extension, with a leading dot, of the MIME type, or an empty string for unknown types.
+func ExtensionByType(mimetype string) string {
+	return mimeTypes[mimetype]
+}
+
+// SetInfo records the stored object's size, entity tag and modification time.
+func (f *File) SetInfo(size int64, etag string, modTime time.Time) {
+	f.size = size
+	f.etag = etag
+	f.modTime = modTime
+}
+
+// SetMetadata attaches a metadata entry that is stored alongside the file.
+func (f *File) SetMetadata(key, value string) {
+	if f.meta == nil {
+		f.meta = make(map[string]string)
 	}
+	f.meta[key] = value
+}
+
+// Metadata returns the metadata stored alongside the file
+func (f *File) Metadata() map[string]string {
+	return f.meta
 }
 
 // Type returns the MIME type of the file
@@ -32,6 +82,11 @@ func (f *File) Type() string {
 	return f.types
 }
 
+// Extension returns the file extension of the MIME type, with a leading dot
+func (f *File) Extension() string {
+	return mimeTypes[f.types]
+}
+
 // Name returns the file name
 func (f *File) Name() string {
 	return f.name
@@ -41,3 +96,27 @@ func (f *File) Name() string {
 func (f *File) Body() io.Reader {
 	return f.body
 }
+
+// Size returns the size of the body in bytes, or -1 if it is unknown
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// ETag returns the entity tag of the stored object, if known
+func (f *File) ETag() string {
+	return f.etag
+}
+
+// ModTime returns the last modification time of the stored object, if known
+func (f *File) ModTime() time.Time {
+	return f.modTime
+}
+
+// Close closes the body if it is an io.Closer
+func (f *File) Close() error {
+	if c, ok := f.body.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
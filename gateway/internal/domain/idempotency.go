@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+// IdempotencyRecord is what a request made with an idempotency key did, replayed to the retries of the request.
+type IdempotencyRecord struct {
+	// Key is the idempotency key of the request, scoped to the user who made it.
+	Key string
+	// Fingerprint identifies the request, so that a key reused for another request is told from a retry.
+	Fingerprint string
+	// StatusCode and Body are the response of the request, and StatusCode is zero while it is in progress.
+	StatusCode int
+	Body       []byte
+	// JobID is the job the request created, if any.
+	JobID string
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// NewIdempotencyRecord reserves a key for a request in progress, until it completes or the reservation expires.
+func NewIdempotencyRecord(key, fingerprint string, reservation time.Duration) *IdempotencyRecord {
+	now := time.Now()
+	return &IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(reservation),
+	}
+}
+
+// Complete records the response of the request, which is kept until ttl from now.
+func (r *IdempotencyRecord) Complete(statusCode int, body []byte, jobID string, ttl time.Duration) {
+	r.StatusCode = statusCode
+	r.Body = body
+	r.JobID = jobID
+	r.ExpiresAt = time.Now().Add(ttl)
+}
+
+// Completed reports whether the request the key was used for has a response.
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
+
+// Expired reports whether the record is past its expiry as of now, the key being free to use again.
+func (r *IdempotencyRecord) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
@@ -0,0 +1,8 @@
+package domain
+
+// Segment is the audio of a paragraph, stored by the worker under its key.
+type Segment struct {
+	Key string `json:"key"`
+	// Reused segments were converted for a previous version of the document, and aren't converted again.
+	Reused bool `json:"reused,omitempty"`
+}
@@ -0,0 +1,10 @@
+package domain
+
+// User is the account behind a request, as resolved by the auth service.
+type User struct {
+	ID       uint64
+	Email    string
+	Name     string
+	Username *string
+	Picture  *string
+}
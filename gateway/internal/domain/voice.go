@@ -0,0 +1,34 @@
+package domain
+
+// Language is a language the worker reads text as.
+type Language struct {
+	// Code is the code the worker knows the language by, as in "a" for American English.
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Tags are the BCP 47 tags the language is also accepted as, as in "en-US".
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Voice is a voice of the worker.
+type Voice struct {
+	// ID is the name the worker knows the voice by, as in "af_bella".
+	ID string `json:"id"`
+	// Language is the code of the language the voice speaks.
+	Language string `json:"language"`
+	Gender   string `json:"gender,omitempty"`
+}
+
+// VoiceSettings choose how a job is narrated, as resolved against the voice catalog.
+type VoiceSettings struct {
+	Voice    string
+	Speed    float64
+	Language string
+}
+
+// VoiceModel is an RVC voice model the voice of audio is converted with.
+type VoiceModel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Key is the S3 key of the model in the voice bucket, which clients are not told.
+	Key string `json:"-"`
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+// sidecarExt is appended to an object's path to name the file holding its content type and metadata.
+const sidecarExt = ".meta.json"
+
+type sidecar struct {
+	ContentType string            `json:"content_type"`
+	ETag        string            `json:"etag"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+type diskStore struct {
+	root string
+}
+
+// NewDiskStore stores objects as files under root, one directory per bucket.
+// The content type and metadata of each object are kept in a JSON sidecar next to it.
+// Both are written to temporary files that are renamed into place, the sidecar first, so that readers never see
+// partial objects, nor new objects without their metadata. Objects whose sidecar is missing are read without metadata.
+//
+// Overwrites are not atomic: between the two renames, readers see the previous body with the new metadata.
+// The services store every upload under a fresh key, and never overwrite objects.
+func NewDiskStore(root string) (FileStore, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create file store root %s: %w", root, err)
+	}
+
+	return &diskStore{
+		root: root,
+	}, nil
+}
+
+func (d *diskStore) Save(ctx context.Context, bucket string, file *domain.File) error {
+	return d.SaveLarge(ctx, bucket, file)
+}
+
+// SaveLarge streams the file to disk without buffering it in memory.
+func (d *diskStore) SaveLarge(_ context.Context, bucket string, file *domain.File) error {
+	path, err := d.path(bucket, file.Name())
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create directory for file %s in bucket %s: %w", file.Name(), bucket, err)
+	}
+
+	hash := md5.New()
+	body, err := writeTemp(filepath.Dir(path), io.TeeReader(file.Body(), hash))
+	if err != nil {
+		return fmt.Errorf("failed to write file %s to bucket %s: %w", file.Name(), bucket, err)
+	}
+	defer os.Remove(body)
+
+	encoded, err := json.Marshal(sidecar{
+		ContentType: file.Type(),
+		ETag:        `"` + hex.EncodeToString(hash.Sum(nil)) + `"`,
+		Metadata:    file.Metadata(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of file %s: %w", file.Name(), err)
+	}
+
+	meta, err := writeTemp(filepath.Dir(path), bytes.NewReader(encoded))
+	if err != nil {
+		return fmt.Errorf("failed to write metadata of file %s to bucket %s: %w", file.Name(), bucket, err)
+	}
+	defer os.Remove(meta)
+
+	// the sidecar goes first, so that a crash between the renames leaves a sidecar without its object,
+	// which reads as missing, rather than an object without its metadata.
+	// An overwritten object is read with the new metadata until its body is renamed in as well.
+	if err = os.Rename(meta, path+sidecarExt); err != nil {
+		return fmt.Errorf("failed to write metadata of file %s to bucket %s: %w", file.Name(), bucket, err)
+	}
+
+	if err = os.Rename(body, path); err != nil {
+		return fmt.Errorf("failed to write file %s to bucket %s: %w", file.Name(), bucket, err)
+	}
+
+	return nil
+}
+
+func (d *diskStore) Read(ctx context.Context, bucket string, key string) (*domain.File, error) {
+	return d.ReadLarge(ctx, bucket, key)
+}
+
+// ReadLarge opens the object for streaming. The body is an *os.File, so callers must close the file.
+func (d *diskStore) ReadLarge(_ context.Context, bucket string, key string) (*domain.File, error) {
+	path, err := d.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to read object %s from bucket %s: %w", key, bucket, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat object %s in bucket %s: %w", key, bucket, err)
+	}
+
+	var meta sidecar
+	if b, err := os.ReadFile(path + sidecarExt); err == nil {
+		if err = json.Unmarshal(b, &meta); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to decode metadata of object %s in bucket %s: %w", key, bucket, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		f.Close()
+		return nil, fmt.Errorf("failed to read metadata of object %s in bucket %s: %w", key, bucket, err)
+	}
+
+	file := domain.NewFile(key, meta.ContentType, f)
+	file.SetInfo(info.Size(), meta.ETag, info.ModTime())
+	for k, v := range meta.Metadata {
+		file.SetMetadata(k, v)
+	}
+
+	return file, nil
+}
+
+func (d *diskStore) Delete(_ context.Context, bucket string, key string) error {
+	path, err := d.path(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotExist
+		}
+		return fmt.Errorf("failed to delete object %s from bucket %s: %w", key, bucket, err)
+	}
+
+	if err = os.Remove(path + sidecarExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata of object %s from bucket %s: %w", key, bucket, err)
+	}
+
+	return nil
+}
+
+// path maps an object to its file, refusing keys that would escape the bucket's directory.
+func (d *diskStore) path(bucket, key string) (string, error) {
+	if bucket == "" || !filepath.IsLocal(bucket) || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+
+	name := filepath.FromSlash(key)
+	if key == "" || !filepath.IsLocal(name) || strings.HasSuffix(key, sidecarExt) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+
+	return filepath.Join(d.root, bucket, name), nil
+}
+
+// writeTemp writes r to a synced temporary file in dir, to be renamed into place, and returns its path.
+func writeTemp(dir string, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = io.Copy(tmp, r); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+// DynamoIdempotencyStore is an IdempotencyStore shared by every gateway instance.
+type DynamoIdempotencyStore interface {
+	IdempotencyStore
+	// AutoMigrate creates the table if it doesn't exist, DynamoDB deleting the records some time after they expire.
+	AutoMigrate(ctx context.Context) error
+}
+
+type idempotencyDTO struct {
+	Key         string    `dynamodbav:"Key"`
+	Fingerprint string    `dynamodbav:"Fingerprint"`
+	StatusCode  int       `dynamodbav:"StatusCode,omitempty"`
+	Body        []byte    `dynamodbav:"Body,omitempty"`
+	JobID       string    `dynamodbav:"JobID,omitempty"`
+	CreatedAt   time.Time `dynamodbav:"CreatedAt"`
+	ExpiresAt   int64     `dynamodbav:"ExpiresAt"` // unix seconds, the time to live attribute of the table
+}
+
+func newIdempotencyDTO(r *domain.IdempotencyRecord) idempotencyDTO {
+	return idempotencyDTO{
+		Key:         r.Key,
+		Fingerprint: r.Fingerprint,
+		StatusCode:  r.StatusCode,
+		Body:        r.Body,
+		JobID:       r.JobID,
+		CreatedAt:   r.CreatedAt,
+		ExpiresAt:   r.ExpiresAt.Unix(),
+	}
+}
+
+func (d idempotencyDTO) toRecord() *domain.IdempotencyRecord {
+	return &domain.IdempotencyRecord{
+		Key:         d.Key,
+		Fingerprint: d.Fingerprint,
+		StatusCode:  d.StatusCode,
+		Body:        d.Body,
+		JobID:       d.JobID,
+		CreatedAt:   d.CreatedAt,
+		ExpiresAt:   time.Unix(d.ExpiresAt, 0),
+	}
+}
+
+type dynamoIdempotencyStore struct {
+	t  string
+	cl *dynamodb.Client
+}
+
+func NewDynamoIdempotencyStore(dynamodbClient *dynamodb.Client, tableName string) DynamoIdempotencyStore {
+	return &dynamoIdempotencyStore{
+		cl: dynamodbClient,
+		t:  tableName,
+	}
+}
+
+func (d *dynamoIdempotencyStore) AutoMigrate(ctx context.Context) error {
+	_, err := d.cl.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(d.t)})
+	var notFoundEx *types.ResourceNotFoundException
+	switch {
+	case err == nil:
+		return nil
+	case !errors.As(err, &notFoundEx):
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+
+	if _, err = d.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(d.t),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String("Key"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("Key"),
+			KeyType:       types.KeyTypeHash,
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	if err = dynamodb.NewTableExistsWaiter(d.cl).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(d.t),
+	}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	if _, err = d.cl.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(d.t),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("ExpiresAt"),
+			Enabled:       aws.Bool(true),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to enable time to live: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dynamoIdempotencyStore) Reserve(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error) {
+	item, err := attributevalue.MarshalMap(newIdempotencyDTO(record))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal idempotencyDTO: %w", err)
+	}
+
+	// expired records linger until DynamoDB gets to deleting them, and are taken over meanwhile
+	if _, err = d.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.t),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR #expiresAt <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":       "Key",
+			"#expiresAt": "ExpiresAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		if !errors.As(err, &condEx) {
+			return nil, fmt.Errorf("failed to put idempotency record: %w", err)
+		}
+
+		var dto idempotencyDTO
+		if err = attributevalue.UnmarshalMap(condEx.Item, &dto); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal idempotencyDTO: %w", err)
+		}
+		return dto.toRecord(), ErrDuplicate
+	}
+
+	return record, nil
+}
+
+func (d *dynamoIdempotencyStore) Save(ctx context.Context, record *domain.IdempotencyRecord) error {
+	item, err := attributevalue.MarshalMap(newIdempotencyDTO(record))
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotencyDTO: %w", err)
+	}
+
+	if _, err = d.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.t),
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("failed to put idempotency record: %w", err)
+	}
+
+	return nil
+}
+
+func (d *dynamoIdempotencyStore) Delete(ctx context.Context, key string) error {
+	if _, err := d.cl.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.t),
+		Key: map[string]types.AttributeValue{
+			"Key": &types.AttributeValueMemberS{Value: key},
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to delete idempotency record: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+)
+
+// envelopeKeyMeta is the metadata entry holding an object's wrapped data key.
+const envelopeKeyMeta = "envelope-key"
+
+type envelopeStore struct {
+	fs  FileStore
+	enc *encryptor.Encryptor
+}
+
+// NewEnvelopeStore wraps a FileStore with client-side envelope encryption.
+// Every object is encrypted as a chunked stream under its own random data key,
+// which is wrapped with the encryptor's active key and stored in the object's metadata.
+// Reads decrypt transparently, and objects stored without a wrapped key are returned as they are.
+func NewEnvelopeStore(fs FileStore, enc *encryptor.Encryptor) FileStore {
+	return &envelopeStore{
+		fs:  fs,
+		enc: enc,
+	}
+}
+
+// Save encrypts the file as it is uploaded.
+// The length of the encrypted body is not known upfront, so it is handed to the underlying store's SaveLarge,
+// which does not need it.
+func (e *envelopeStore) Save(ctx context.Context, bucket string, file *domain.File) error {
+	return e.SaveLarge(ctx, bucket, file)
+}
+
+func (e *envelopeStore) SaveLarge(ctx context.Context, bucket string, file *domain.File) error {
+	dataKey, err := encryptor.NewDataKey()
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := e.enc.EncryptFor(string(dataKey), dataKeyBinding(bucket, file.Name()))
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key of file %s: %w", file.Name(), err)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(encryptStream(pw, file.Body(), dataKey, objectAD(bucket, file.Name())))
+	}()
+
+	encrypted := domain.NewFile(file.Name(), file.Type(), pr)
+	for k, v := range file.Metadata() {
+		encrypted.SetMetadata(k, v)
+	}
+	encrypted.SetMetadata(envelopeKeyMeta, wrapped)
+
+	err = e.fs.SaveLarge(ctx, bucket, encrypted)
+
+	// unblocks the encryption if the upload stopped reading early
+	pr.Close()
+	<-done
+
+	return err
+}
+
+func (e *envelopeStore) Read(ctx context.Context, bucket string, key string) (*domain.File, error) {
+	file, err := e.fs.Read(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.decrypt(bucket, file)
+}
+
+// ReadLarge decrypts the object as it is streamed.
+// The body stays seekable when the underlying store's body is.
+func (e *envelopeStore) ReadLarge(ctx context.Context, bucket string, key string) (*domain.File, error) {
+	file, err := e.fs.ReadLarge(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.decrypt(bucket, file)
+}
+
+func (e *envelopeStore) Delete(ctx context.Context, bucket string, key string) error {
+	return e.fs.Delete(ctx, bucket, key)
+}
+
+func (e *envelopeStore) decrypt(bucket string, file *domain.File) (*domain.File, error) {
+	meta := maps.Clone(file.Metadata())
+	wrapped, ok := meta[envelopeKeyMeta]
+	if !ok {
+		return file, nil
+	}
+	delete(meta, envelopeKeyMeta)
+
+	dataKey, err := e.enc.DecryptFor(wrapped, dataKeyBinding(bucket, file.Name()))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to unwrap data key of object %s in bucket %s: %w", file.Name(), bucket, err)
+	}
+
+	sr, err := encryptor.NewStreamReader(file.Body(), dataKey, objectAD(bucket, file.Name()))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decrypt object %s in bucket %s: %w", file.Name(), bucket, err)
+	}
+
+	var body io.Reader = readCloser{sr, file}
+	if _, ok = file.Body().(io.Seeker); ok {
+		body = readSeekCloser{sr, file}
+	}
+
+	size := file.Size()
+	if size >= 0 {
+		if size, err = encryptor.PlaintextSize(size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to decrypt object %s in bucket %s: %w", file.Name(), bucket, err)
+		}
+	}
+
+	decrypted := domain.NewFile(file.Name(), file.Type(), body)
+	decrypted.SetInfo(size, file.ETag(), file.ModTime())
+	for k, v := range meta {
+		decrypted.SetMetadata(k, v)
+	}
+
+	return decrypted, nil
+}
+
+func encryptStream(dst io.Writer, src io.Reader, dataKey, ad []byte) error {
+	w, err := encryptor.NewStreamWriter(dst, dataKey, ad)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(w, src); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// dataKeyBinding ties a wrapped data key to the object it encrypts.
+func dataKeyBinding(bucket, key string) encryptor.Binding {
+	return encryptor.Binding{Purpose: "data-key:" + bucket + "/" + key}
+}
+
+// objectAD ties the encrypted stream to the object it is stored as.
+func objectAD(bucket, key string) []byte {
+	return []byte(bucket + "/" + key)
+}
+
+// readCloser closes the encrypted file once its decrypted body is closed.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type readSeekCloser struct {
+	io.ReadSeeker
+	io.Closer
+}
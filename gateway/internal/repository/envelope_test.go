@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+)
+
+// unseekableStore hides io.Seeker from Read bodies, like S3 GetObject bodies.
+type unseekableStore struct {
+	FileStore
+}
+
+func (u unseekableStore) Read(ctx context.Context, bucket string, key string) (*domain.File, error) {
+	file, err := u.FileStore.Read(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	unseekable := domain.NewFile(file.Name(), file.Type(), io.MultiReader(file.Body()))
+	unseekable.SetInfo(file.Size(), file.ETag(), file.ModTime())
+	for k, v := range file.Metadata() {
+		unseekable.SetMetadata(k, v)
+	}
+
+	return unseekable, nil
+}
+
+func TestEnvelopeStore(t *testing.T) {
+	ctx := context.Background()
+	enc, err := encryptor.NewEncryptor("0123456780123456789abcdef9abcdef0123456780123456789abcdef9abcdef")
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	plaintext := bytes.Repeat([]byte("It was a dark and stormy night. "), 10000)
+
+	setup := func(t *testing.T) (FileStore, FileStore) {
+		t.Helper()
+		inner := unseekableStore{NewMemoryStore()}
+		fs := NewEnvelopeStore(inner, enc)
+
+		file := domain.NewFile("1/manuscript.txt", "text/plain", bytes.NewReader(plaintext))
+		file.SetMetadata("filename", "manuscript.txt")
+		if err := fs.Save(ctx, "bucket", file); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		return inner, fs
+	}
+
+	t.Run("stored encrypted", func(t *testing.T) {
+		inner, _ := setup(t)
+
+		obj, err := inner.Read(ctx, "bucket", "1/manuscript.txt")
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+
+		data, _ := io.ReadAll(obj.Body())
+		if bytes.Contains(data, []byte("stormy night")) {
+			t.Error("plaintext found in the stored object")
+		}
+
+		if obj.Metadata()[envelopeKeyMeta] == "" {
+			t.Error("wrapped data key missing from metadata")
+		}
+
+		if obj.Type() != "text/plain" || obj.Metadata()["filename"] != "manuscript.txt" {
+			t.Errorf("type and metadata were not kept: %q %v", obj.Type(), obj.Metadata())
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		_, fs := setup(t)
+
+		file, err := fs.Read(ctx, "bucket", "1/manuscript.txt")
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		defer file.Close()
+
+		got, err := io.ReadAll(file.Body())
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+
+		if !bytes.Equal(got, plaintext) {
+			t.Error("decrypted body does not match")
+		}
+
+		if _, ok := file.Body().(io.Seeker); ok {
+			t.Error("body of an unseekable object should not be seekable")
+		}
+
+		if _, ok := file.Metadata()[envelopeKeyMeta]; ok {
+			t.Error("wrapped data key leaked into the decrypted file's metadata")
+		}
+	})
+
+	t.Run("read large", func(t *testing.T) {
+		_, fs := setup(t)
+
+		file, err := fs.ReadLarge(ctx, "bucket", "1/manuscript.txt")
+		if err != nil {
+			t.Fatalf("ReadLarge failed: %v", err)
+		}
+		defer file.Close()
+
+		if file.Size() != int64(len(plaintext)) {
+			t.Errorf("Expected size %d, got %d", len(plaintext), file.Size())
+		}
+
+		rs, ok := file.Body().(io.ReadSeeker)
+		if !ok {
+			t.Fatal("body of a seekable object should be seekable")
+		}
+
+		if _, err = rs.Seek(100000, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+
+		got := make([]byte, 64)
+		if _, err = io.ReadFull(rs, got); err != nil {
+			t.Fatalf("ReadFull failed: %v", err)
+		}
+
+		if !bytes.Equal(got, plaintext[100000:100064]) {
+			t.Error("decrypted range does not match")
+		}
+	})
+
+	t.Run("moved object", func(t *testing.T) {
+		inner, fs := setup(t)
+		obj, err := inner.Read(ctx, "bucket", "1/manuscript.txt")
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+
+		moved := domain.NewFile("2/manuscript.txt", obj.Type(), obj.Body())
+		for k, v := range obj.Metadata() {
+			moved.SetMetadata(k, v)
+		}
+		if err = inner.Save(ctx, "bucket", moved); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		if _, err := fs.Read(ctx, "bucket", "2/manuscript.txt"); err == nil {
+			t.Error("Expected an object moved to another key to fail decryption")
+		}
+	})
+
+	t.Run("unencrypted object", func(t *testing.T) {
+		inner, fs := setup(t)
+		legacy := domain.NewFile("legacy.txt", "text/plain", bytes.NewReader([]byte("legacy")))
+		if err := inner.Save(ctx, "bucket", legacy); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		file, err := fs.Read(ctx, "bucket", "legacy.txt")
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+
+		got, _ := io.ReadAll(file.Body())
+		if string(got) != "legacy" {
+			t.Errorf("Expected unencrypted object as is, got %q", got)
+		}
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		_, fs := setup(t)
+
+		if _, err := fs.ReadLarge(ctx, "bucket", "missing"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Expected ErrNotExist, got %v", err)
+		}
+	})
+}
@@ -1,10 +1,10 @@
 package repository
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"mime"
 	"time"
 
 	"github.com/ziliscite/bard_narate/gateway/internal/domain"
@@ -16,7 +16,10 @@ import (
 	"github.com/aws/smithy-go"
 )
 
-var partSize int64 = 10 << 20 // 10 MB
+var (
+	partSize  int64 = 10 << 20 // 10 MB
+	readAhead       = 2        // parts fetched ahead of the reader
+)
 
 type SmallFileWriter interface {
 	Save(ctx context.Context, bucket string, file *domain.File) error
@@ -78,6 +81,7 @@ func (s *store) Save(ctx context.Context, bucket string, file *domain.File) erro
 		Key:         aws.String(file.Name()),
 		Body:        file.Body(),
 		ContentType: aws.String(file.Type()),
+		Metadata:    encodeMetadata(file.Metadata()),
 	}); err != nil {
 		return fmt.Errorf("failed to upload file %s to bucket %s: %w", file.Name(), bucket, err)
 	}
@@ -105,6 +109,7 @@ func (s *store) SaveLarge(ctx context.Context, bucket string, file *domain.File)
 		Key:         aws.String(file.Name()),
 		Body:        file.Body(),
 		ContentType: aws.String(file.Type()),
+		Metadata:    encodeMetadata(file.Metadata()),
 	}); err != nil {
 		var apiErr smithy.APIError
 		errors.As(err, &apiErr)
@@ -160,26 +165,28 @@ func (s *store) Read(ctx context.Context, bucket string, fileKey string) (*domai
 		return nil, fmt.Errorf("failed to read object %s from bucket %s: %w", fileKey, bucket, err)
 	}
 
-	return domain.NewFile(fileKey, *result.ContentType, result.Body), nil
+	file := domain.NewFile(fileKey, *result.ContentType, result.Body)
+	file.SetInfo(*result.ContentLength, aws.ToString(result.ETag), aws.ToTime(result.LastModified))
+	decodeMetadata(file, result.Metadata)
+
+	return file, nil
 }
 
-// ReadLarge uses a download manager to download an object from a bucket.
-// The download manager gets the data in parts and writes them to a buffer until all of
-// the data has been downloaded.
+// ReadLarge streams an object from a bucket without buffering it whole.
+// The returned body is an io.ReadSeekCloser that downloads the object through sequential ranged GETs,
+// keeping at most readAhead+2 parts of partSize bytes in memory: those read ahead, the one being read
+// and the one being fetched. Reading only the start of the body, as probing its header does, fetches no more
+// than leadSize bytes. Callers must close the file, which may be done before the body is fully read.
 func (s *store) ReadLarge(ctx context.Context, bucket string, fileKey string) (*domain.File, error) {
-	downloader := manager.NewDownloader(s.s3c, func(d *manager.Downloader) {
-		d.PartSize = partSize
-	})
-
 	headObject, err := s.s3c.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(fileKey),
 	})
 	if err != nil {
 		var noKey *types.NoSuchKey
-		errors.As(err, &noKey)
+		var notFound *types.NotFound
 		switch {
-		case errors.As(err, &noKey):
+		case errors.As(err, &noKey), errors.As(err, &notFound):
 			return nil, ErrNotExist
 		default:
 			return nil, fmt.Errorf("failed to head object %s from bucket %s: %w", fileKey, bucket, err)
@@ -187,23 +194,23 @@ func (s *store) ReadLarge(ctx context.Context, bucket string, fileKey string) (*
 	}
 
 	if headObject.ContentType == nil {
-		return nil, fmt.Errorf("failed to download object %s from bucket %s: %w", fileKey, bucket, err)
+		return nil, fmt.Errorf("failed to download object %s from bucket %s: missing content type", fileKey, bucket)
 	}
 
 	if *headObject.ContentType == "" {
-		return nil, fmt.Errorf("failed to download object %s from bucket %s: %w", fileKey, bucket, err)
+		return nil, fmt.Errorf("failed to download object %s from bucket %s: empty content type", fileKey, bucket)
 	}
 
-	buffer := manager.NewWriteAtBuffer([]byte{})
+	size := aws.ToInt64(headObject.ContentLength)
+	etag := aws.ToString(headObject.ETag)
 
-	if _, err = downloader.Download(ctx, buffer, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(fileKey),
-	}); err != nil {
-		return nil, fmt.Errorf("failed to download object %s from bucket %s: %w", fileKey, bucket, err)
-	}
+	body := newObjectReader(ctx, s.s3c, bucket, fileKey, etag, size, partSize, readAhead)
+
+	file := domain.NewFile(fileKey, *headObject.ContentType, body)
+	file.SetInfo(size, etag, aws.ToTime(headObject.LastModified))
+	decodeMetadata(file, headObject.Metadata)
 
-	return domain.NewFile(fileKey, *headObject.ContentType, bytes.NewReader(buffer.Bytes())), nil
+	return file, nil
 }
 
 func (s *store) Delete(ctx context.Context, bucket string, key string) error {
@@ -221,7 +228,7 @@ func (s *store) Delete(ctx context.Context, bucket string, key string) error {
 		}
 	}
 
-	if err := s3.NewObjectExistsWaiter(s.s3c).Wait(ctx, &s3.HeadObjectInput{
+	if err := s3.NewObjectNotExistsWaiter(s.s3c).Wait(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	}, time.Minute); err != nil {
@@ -230,3 +237,29 @@ func (s *store) Delete(ctx context.Context, bucket string, key string) error {
 
 	return nil
 }
+
+// encodeMetadata makes metadata values safe to send as S3 user metadata headers,
+// which only carry US-ASCII, by RFC 2047 encoding the ones that are not.
+func encodeMetadata(meta map[string]string) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+
+	encoded := make(map[string]string, len(meta))
+	for k, v := range meta {
+		encoded[k] = mime.QEncoding.Encode("utf-8", v)
+	}
+
+	return encoded
+}
+
+// decodeMetadata attaches S3 user metadata to the file, undoing encodeMetadata.
+func decodeMetadata(file *domain.File, meta map[string]string) {
+	var dec mime.WordDecoder
+	for k, v := range meta {
+		if decoded, err := dec.DecodeHeader(v); err == nil {
+			v = decoded
+		}
+		file.SetMetadata(k, v)
+	}
+}
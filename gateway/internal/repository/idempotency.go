@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+// IdempotencyStore keeps the records of requests made with an idempotency key until they expire.
+type IdempotencyStore interface {
+	// Reserve saves a new record, unless its key has one that hasn't expired.
+	// It then returns that record along with ErrDuplicate.
+	Reserve(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error)
+	// Save overwrites the record of a key.
+	Save(ctx context.Context, record *domain.IdempotencyRecord) error
+	// Delete removes the record of a key, freeing it whether or not it expired.
+	Delete(ctx context.Context, key string) error
+}
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]domain.IdempotencyRecord
+	// sweptAt is when expired records were last removed.
+	sweptAt time.Time
+}
+
+// idempotencySweep is how often the memory store removes expired records.
+const idempotencySweep = time.Minute
+
+// NewMemoryIdempotencyStore keeps records in memory, which suits a single gateway instance.
+// Everything is lost when the process exits.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{
+		records: make(map[string]domain.IdempotencyRecord),
+	}
+}
+
+// cloneRecord copies the record along with its body, so that callers can't change what is saved.
+func cloneRecord(r domain.IdempotencyRecord) *domain.IdempotencyRecord {
+	r.Body = slices.Clone(r.Body)
+	return &r
+}
+
+func (m *memoryIdempotencyStore) Reserve(_ context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	if saved, ok := m.records[record.Key]; ok && !saved.Expired(now) {
+		return cloneRecord(saved), ErrDuplicate
+	}
+
+	m.records[record.Key] = *cloneRecord(*record)
+	return record, nil
+}
+
+func (m *memoryIdempotencyStore) Save(_ context.Context, record *domain.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[record.Key] = *cloneRecord(*record)
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+// sweep removes the expired records, at most once every idempotencySweep.
+func (m *memoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(m.sweptAt) < idempotencySweep {
+		return
+	}
+	m.sweptAt = now
+
+	for key, r := range m.records {
+		if r.Expired(now) {
+			delete(m.records, key)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+	meta        map[string]string
+}
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]map[string]*memoryObject // bucket -> key -> object
+}
+
+// NewMemoryStore keeps objects in memory. Everything is lost when the process exits,
+// which makes it suited to development and tests.
+func NewMemoryStore() FileStore {
+	return &memoryStore{
+		objects: make(map[string]map[string]*memoryObject),
+	}
+}
+
+func (m *memoryStore) Save(ctx context.Context, bucket string, file *domain.File) error {
+	return m.SaveLarge(ctx, bucket, file)
+}
+
+func (m *memoryStore) SaveLarge(_ context.Context, bucket string, file *domain.File) error {
+	data, err := io.ReadAll(file.Body())
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", file.Name(), err)
+	}
+
+	sum := md5.Sum(data)
+	obj := &memoryObject{
+		data:        data,
+		contentType: file.Type(),
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		modTime:     time.Now(),
+		meta:        maps.Clone(file.Metadata()),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.objects[bucket] == nil {
+		m.objects[bucket] = make(map[string]*memoryObject)
+	}
+	m.objects[bucket][file.Name()] = obj
+
+	return nil
+}
+
+func (m *memoryStore) Read(ctx context.Context, bucket string, key string) (*domain.File, error) {
+	return m.ReadLarge(ctx, bucket, key)
+}
+
+// ReadLarge returns a seekable body over the stored bytes, which are never modified in place.
+func (m *memoryStore) ReadLarge(_ context.Context, bucket string, key string) (*domain.File, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[bucket][key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	file := domain.NewFile(key, obj.contentType, bytes.NewReader(obj.data))
+	file.SetInfo(int64(len(obj.data)), obj.etag, obj.modTime)
+	for k, v := range obj.meta {
+		file.SetMetadata(k, v)
+	}
+
+	return file, nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, bucket string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.objects[bucket][key]; !ok {
+		return ErrNotExist
+	}
+	delete(m.objects[bucket], key)
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errClosed = errors.New("read on closed object reader")
+
+// leadSize is how much of an object the first read after opening or seeking fetches by itself.
+const leadSize = 64 << 10
+
+// objectGetter is the part of the S3 client used by objectReader.
+type objectGetter interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+type part struct {
+	data []byte
+	err  error
+}
+
+// objectReader streams an S3 object through sequential ranged GETs.
+//
+// The first read after opening or seeking only fetches the leadSize bytes it starts at, so that reading the header
+// of an object costs a single small GET. Reads past them fetch the rest in parts,
+// by a background goroutine that queues at most readAhead parts ahead of the reader.
+// Along with the part being read and the one being fetched, which waits for room in the queue,
+// a download never holds more than (readAhead+2)*partSize bytes in memory.
+// Seeking drops the read-ahead and restarts fetching from the new offset on the next Read.
+type objectReader struct {
+	ctx       context.Context
+	s3c       objectGetter
+	bucket    string
+	key       string
+	etag      string
+	size      int64
+	partSize  int64
+	readAhead int
+	leadSize  int64
+
+	offset int64
+	buf    []byte
+	err    error
+	closed bool
+	// led tells whether the lead was fetched since the reader was opened or last sought.
+	led bool
+
+	parts  chan part
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newObjectReader(ctx context.Context, s3c objectGetter, bucket, key, etag string, size, partSize int64, readAhead int) *objectReader {
+	return &objectReader{
+		ctx:       ctx,
+		s3c:       s3c,
+		bucket:    bucket,
+		key:       key,
+		etag:      etag,
+		size:      size,
+		partSize:  partSize,
+		readAhead: readAhead,
+		leadSize:  min(leadSize, partSize),
+	}
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, errClosed
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if len(r.buf) == 0 && !r.led {
+		if err := r.lead(); err != nil {
+			r.err = err
+			return 0, r.err
+		}
+	}
+
+	if len(r.buf) == 0 {
+		if r.parts == nil {
+			r.start()
+		}
+
+		pt, ok := <-r.parts
+		switch {
+		case !ok && r.ctx.Err() != nil:
+			r.err = r.ctx.Err()
+			return 0, r.err
+		case !ok:
+			r.err = io.ErrUnexpectedEOF
+			return 0, r.err
+		case pt.err != nil:
+			r.err = pt.err
+			return 0, r.err
+		}
+
+		r.buf = pt.data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, errClosed
+	}
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if abs != r.offset {
+		r.stop()
+		r.offset = abs
+		r.err = nil
+		r.led = false
+	}
+
+	return abs, nil
+}
+
+// Close stops the read-ahead and releases the buffered parts.
+func (r *objectReader) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.stop()
+	r.closed = true
+
+	return nil
+}
+
+// lead fetches the leadSize bytes from the current offset, without starting the read-ahead.
+func (r *objectReader) lead() error {
+	data, err := r.fetch(r.ctx, r.offset, min(r.offset+r.leadSize, r.size)-1)
+	if err != nil {
+		return err
+	}
+
+	r.buf, r.led = data, true
+	return nil
+}
+
+// start launches the goroutine fetching parts from the current offset.
+func (r *objectReader) start() {
+	ctx, cancel := context.WithCancel(r.ctx)
+	parts := make(chan part, r.readAhead)
+	done := make(chan struct{})
+
+	r.parts, r.cancel, r.done = parts, cancel, done
+
+	go func(offset int64) {
+		defer close(done)
+		defer close(parts)
+
+		for offset < r.size {
+			end := min(offset+r.partSize, r.size) - 1
+			data, err := r.fetch(ctx, offset, end)
+
+			select {
+			case parts <- part{data: data, err: err}:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+
+			offset = end + 1
+		}
+	}(r.offset)
+}
+
+// stop cancels the running fetcher, if any, and waits for it to exit.
+func (r *objectReader) stop() {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+	}
+
+	r.parts, r.cancel, r.done = nil, nil, nil
+	r.buf = nil
+}
+
+// fetch downloads the inclusive byte range [start, end] of the object.
+// The request is conditional on the entity tag seen when the reader was opened,
+// so an object replaced mid-download fails instead of mixing two versions.
+func (r *objectReader) fetch(ctx context.Context, start, end int64) ([]byte, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	}
+	if r.etag != "" {
+		input.IfMatch = aws.String(r.etag)
+	}
+
+	result, err := r.s3c.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read range %d-%d of object %s from bucket %s: %w", start, end, r.key, r.bucket, err)
+	}
+	defer result.Body.Close()
+
+	data := make([]byte, end-start+1)
+	if _, err = io.ReadFull(result.Body, data); err != nil {
+		return nil, fmt.Errorf("failed to read range %d-%d of object %s from bucket %s: %w", start, end, r.key, r.bucket, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type fakeGetter struct {
+	data  []byte
+	calls atomic.Int32
+}
+
+func (f *fakeGetter) GetObject(_ context.Context, params *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.calls.Add(1)
+
+	var start, end int64
+	if _, err := fmt.Sscanf(aws.ToString(params.Range), "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewReader(f.data[start : end+1])),
+	}, nil
+}
+
+func TestObjectReader(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	open := func(g *fakeGetter) *objectReader {
+		return newObjectReader(context.Background(), g, "bucket", "key", "", int64(len(data)), 64, 2)
+	}
+
+	t.Run("sequential read", func(t *testing.T) {
+		g := &fakeGetter{data: data}
+		r := open(g)
+		defer r.Close()
+
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Error("streamed body does not match the object")
+		}
+
+		if calls := g.calls.Load(); calls != 16 {
+			t.Errorf("Expected 16 ranged GETs, got %d", calls)
+		}
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		r := open(&fakeGetter{data: data})
+		defer r.Close()
+
+		size, err := r.Seek(0, io.SeekEnd)
+		if err != nil || size != int64(len(data)) {
+			t.Fatalf("Expected size %d, got %d (%v)", len(data), size, err)
+		}
+
+		if _, err = r.Seek(10, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+
+		buf := make([]byte, 100)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			t.Fatalf("ReadFull failed: %v", err)
+		}
+
+		if !bytes.Equal(buf, data[10:110]) {
+			t.Error("read after seek does not match the object")
+		}
+
+		// seek backwards into an already consumed part
+		if _, err = r.Seek(-50, io.SeekCurrent); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+
+		if !bytes.Equal(rest, data[60:]) {
+			t.Error("read after backwards seek does not match the object")
+		}
+	})
+
+	t.Run("reading the start doesn't read ahead", func(t *testing.T) {
+		g := &fakeGetter{data: data}
+		r := open(g)
+		r.leadSize = 16
+
+		buf := make([]byte, 10)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatalf("ReadFull failed: %v", err)
+		}
+		r.Close()
+
+		if !bytes.Equal(buf, data[:10]) {
+			t.Error("read does not match the object")
+		}
+
+		if calls := g.calls.Load(); calls != 1 {
+			t.Errorf("Expected a single ranged GET, got %d", calls)
+		}
+	})
+
+	t.Run("streams on past the lead", func(t *testing.T) {
+		g := &fakeGetter{data: data}
+		r := open(g)
+		r.leadSize = 16
+		defer r.Close()
+
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Error("streamed body does not match the object")
+		}
+
+		// the lead, then the parts of the rest
+		if calls := g.calls.Load(); calls != 17 {
+			t.Errorf("Expected 17 ranged GETs, got %d", calls)
+		}
+	})
+
+	t.Run("early close", func(t *testing.T) {
+		g := &fakeGetter{data: data}
+		r := open(g)
+
+		if _, err := io.ReadFull(r, make([]byte, 100)); err != nil {
+			t.Fatalf("ReadFull failed: %v", err)
+		}
+
+		if err := r.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		// past the lead, the part being read and at most readAhead+1 more were fetched
+		if calls := g.calls.Load(); calls > 5 {
+			t.Errorf("Expected read-ahead to be bounded, got %d GETs", calls)
+		}
+
+		if _, err := r.Read(make([]byte, 1)); err == nil {
+			t.Error("Expected error reading a closed reader")
+		}
+	})
+}
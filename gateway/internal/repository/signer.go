@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type URLSigner interface {
+	// SignURL returns a URL that grants read access to the object for the duration of ttl.
+	SignURL(ctx context.Context, bucket string, key string, ttl time.Duration) (string, error)
+}
+
+type s3Signer struct {
+	pc *s3.PresignClient
+}
+
+// NewS3Signer creates a signer that issues S3 presigned GET URLs.
+func NewS3Signer(s3c *s3.Client) URLSigner {
+	return &s3Signer{
+		pc: s3.NewPresignClient(s3c),
+	}
+}
+
+func (s *s3Signer) SignURL(ctx context.Context, bucket string, key string, ttl time.Duration) (string, error) {
+	req, err := s.pc.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object %s in bucket %s: %w", key, bucket, err)
+	}
+
+	return req.URL, nil
+}
+
+type cloudFrontSigner struct {
+	domain string
+	us     *sign.URLSigner
+}
+
+// NewCloudFrontSigner creates a signer that issues CloudFront signed URLs with a canned policy.
+// The distribution domain is expected to front the bucket the objects are read from,
+// so the bucket passed to SignURL is ignored.
+func NewCloudFrontSigner(distributionDomain, keyPairID string, privKey crypto.Signer) URLSigner {
+	return &cloudFrontSigner{
+		domain: strings.TrimSuffix(strings.TrimPrefix(distributionDomain, "https://"), "/"),
+		us:     sign.NewURLSigner(keyPairID, privKey),
+	}
+}
+
+func (s *cloudFrontSigner) SignURL(_ context.Context, _ string, key string, ttl time.Duration) (string, error) {
+	u := url.URL{
+		Scheme: "https",
+		Host:   s.domain,
+		Path:   "/" + key,
+	}
+
+	signed, err := s.us.Sign(u.String(), time.Now().Add(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign cloudfront url for object %s: %w", key, err)
+	}
+
+	return signed, nil
+}
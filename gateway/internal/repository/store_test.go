@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testFileStore(t, func(t *testing.T) FileStore {
+		return NewMemoryStore()
+	})
+}
+
+func TestDiskStore(t *testing.T) {
+	testFileStore(t, func(t *testing.T) FileStore {
+		fs, err := NewDiskStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create disk store: %v", err)
+		}
+		return fs
+	})
+
+	t.Run("keys escaping the root", func(t *testing.T) {
+		fs, _ := NewDiskStore(t.TempDir())
+		for _, key := range []string{"../escape.txt", "/etc/passwd", "a/../../escape.txt", "", "object" + sidecarExt} {
+			err := fs.Save(context.Background(), "bucket", domain.NewFile(key, "text/plain", bytes.NewReader([]byte("x"))))
+			if err == nil {
+				t.Errorf("Expected key %q to be refused", key)
+			}
+		}
+
+		err := fs.Save(context.Background(), "../bucket", domain.NewFile("key.txt", "text/plain", bytes.NewReader([]byte("x"))))
+		if err == nil {
+			t.Error("Expected bucket escaping the root to be refused")
+		}
+	})
+
+	t.Run("interrupted saves", func(t *testing.T) {
+		root := t.TempDir()
+		fs, _ := NewDiskStore(root)
+		if err := fs.Save(context.Background(), "bucket", domain.NewFile("key.txt", "text/plain", bytes.NewReader([]byte("x")))); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		// as if the save crashed between renaming the sidecar and the object
+		if err := os.Remove(filepath.Join(root, "bucket", "key.txt")); err != nil {
+			t.Fatalf("Failed to remove object: %v", err)
+		}
+		if _, err := fs.Read(context.Background(), "bucket", "key.txt"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Expected a sidecar without its object to read as missing, got %v", err)
+		}
+
+		// objects whose sidecar went missing are read without metadata
+		if err := os.WriteFile(filepath.Join(root, "bucket", "bare.txt"), []byte("x"), 0o640); err != nil {
+			t.Fatalf("Failed to write object: %v", err)
+		}
+		file, err := fs.Read(context.Background(), "bucket", "bare.txt")
+		if err != nil {
+			t.Fatalf("Expected an object without sidecar to be read, got %v", err)
+		}
+		file.Close()
+
+		if entries, _ := os.ReadDir(filepath.Join(root, "bucket")); len(entries) != 2 {
+			t.Errorf("Expected no temporary file left behind, got %d entries", len(entries))
+		}
+	})
+}
+
+// testFileStore is the conformance suite every FileStore implementation must pass.
+func testFileStore(t *testing.T, newStore func(t *testing.T) FileStore) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("Once upon a time. "), 4096)
+
+	save := func(t *testing.T, fs FileStore, large bool, key, mimetype string, body []byte) {
+		t.Helper()
+		file := domain.NewFile(key, mimetype, bytes.NewReader(body))
+		file.SetMetadata("filename", "Chapter één.txt")
+
+		var err error
+		if large {
+			err = fs.SaveLarge(ctx, "bucket", file)
+		} else {
+			err = fs.Save(ctx, "bucket", file)
+		}
+		if err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	read := func(t *testing.T, fs FileStore, large bool, key string) *domain.File {
+		t.Helper()
+		var file *domain.File
+		var err error
+		if large {
+			file, err = fs.ReadLarge(ctx, "bucket", key)
+		} else {
+			file, err = fs.Read(ctx, "bucket", key)
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		t.Cleanup(func() { file.Close() })
+		return file
+	}
+
+	for _, large := range []bool{false, true} {
+		name := "small"
+		if large {
+			name = "large"
+		}
+
+		t.Run(name+" round trip", func(t *testing.T) {
+			fs := newStore(t)
+			save(t, fs, large, "1/story.txt", "text/plain", content)
+
+			file := read(t, fs, large, "1/story.txt")
+			got, err := io.ReadAll(file.Body())
+			if err != nil {
+				t.Fatalf("ReadAll failed: %v", err)
+			}
+
+			if !bytes.Equal(got, content) {
+				t.Error("read body does not match the saved one")
+			}
+
+			if file.Name() != "1/story.txt" {
+				t.Errorf("Expected name %q, got %q", "1/story.txt", file.Name())
+			}
+
+			if file.Type() != "text/plain" {
+				t.Errorf("Expected type text/plain, got %q", file.Type())
+			}
+
+			if file.Size() != int64(len(content)) {
+				t.Errorf("Expected size %d, got %d", len(content), file.Size())
+			}
+
+			if file.ETag() == "" {
+				t.Error("Expected an entity tag")
+			}
+
+			if file.Metadata()["filename"] != "Chapter één.txt" {
+				t.Errorf("Expected metadata to be kept, got %v", file.Metadata())
+			}
+		})
+
+		t.Run(name+" missing object", func(t *testing.T) {
+			fs := newStore(t)
+
+			var err error
+			if large {
+				_, err = fs.ReadLarge(ctx, "bucket", "missing.txt")
+			} else {
+				_, err = fs.Read(ctx, "bucket", "missing.txt")
+			}
+			if !errors.Is(err, ErrNotExist) {
+				t.Errorf("Expected ErrNotExist, got %v", err)
+			}
+		})
+	}
+
+	t.Run("overwrite", func(t *testing.T) {
+		fs := newStore(t)
+		save(t, fs, false, "story.txt", "text/plain", []byte("first draft"))
+		first := read(t, fs, false, "story.txt").ETag()
+
+		save(t, fs, true, "story.txt", "text/plain", []byte("second draft"))
+		file := read(t, fs, false, "story.txt")
+
+		got, _ := io.ReadAll(file.Body())
+		if string(got) != "second draft" {
+			t.Errorf("Expected the second draft, got %q", got)
+		}
+
+		if file.ETag() == first {
+			t.Error("Expected the entity tag to change with the content")
+		}
+	})
+
+	t.Run("read large is seekable", func(t *testing.T) {
+		fs := newStore(t)
+		save(t, fs, true, "audio.wav", "audio/wav", content)
+
+		rs, ok := read(t, fs, true, "audio.wav").Body().(io.ReadSeeker)
+		if !ok {
+			t.Fatal("Expected ReadLarge to return a seekable body")
+		}
+
+		if _, err := rs.Seek(100, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+
+		got := make([]byte, 10)
+		if _, err := io.ReadFull(rs, got); err != nil {
+			t.Fatalf("ReadFull failed: %v", err)
+		}
+
+		if !bytes.Equal(got, content[100:110]) {
+			t.Error("read range does not match")
+		}
+	})
+
+	t.Run("buckets are separate", func(t *testing.T) {
+		fs := newStore(t)
+		save(t, fs, false, "story.txt", "text/plain", content)
+
+		if _, err := fs.Read(ctx, "other", "story.txt"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Expected ErrNotExist from another bucket, got %v", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		fs := newStore(t)
+		save(t, fs, false, "story.txt", "text/plain", content)
+
+		if err := fs.Delete(ctx, "bucket", "story.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if _, err := fs.Read(ctx, "bucket", "story.txt"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Expected ErrNotExist after delete, got %v", err)
+		}
+
+		if err := fs.Delete(ctx, "bucket", "story.txt"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Expected ErrNotExist deleting a missing object, got %v", err)
+		}
+	})
+}
@@ -3,37 +3,169 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/ziliscite/bard_narate/gateway/internal/domain"
 	"github.com/ziliscite/bard_narate/gateway/internal/repository"
+	"github.com/ziliscite/bard_narate/gateway/pkg/audio"
 	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+	"io"
+	"time"
 )
 
+// audioPurpose binds audio keys so that they cannot be used as keys of another kind.
+const audioPurpose = "audio"
+
 type AudioService interface {
-	Get(ctx context.Context, key string) (*domain.File, error)
+	// Key returns the key handed out to the user for the converted audio stored under the S3 key.
+	// The key is the S3 key encrypted for the user, and only resolves for that user.
+	Key(userID uint64, objectKey string) (string, error)
+
+	// Get opens the converted audio behind a key issued to the user by Key.
+	// The caller is responsible for closing the returned file.
+	Get(ctx context.Context, userID uint64, key string) (*domain.File, error)
+
+	// URL returns a short-lived signed URL to the converted audio behind a key issued to the user by Key,
+	// along with the time it expires. It returns ErrURLsDisabled without a URL signer.
+	URL(ctx context.Context, userID uint64, key string) (string, time.Time, error)
+
+	// Delete deletes the converted audio stored under the S3 key, once no job uses it anymore.
+	// Audio that is already gone is not an error.
+	Delete(ctx context.Context, objectKey string) error
+
+	// Book plans a single WAV file of the converted audio of the chapters of a split job, in reading order,
+	// with a pause between chapters. Only the headers of the chapters are read until the book is written,
+	// each chapter then being streamed from storage in turn.
+	// It returns ErrFileNotFound if the audio of a chapter is missing.
+	Book(ctx context.Context, chapters []BookChapter) (*audio.Assembly, error)
+}
+
+// BookChapter is a chapter of a book, whose converted audio is stored under the S3 key.
+type BookChapter struct {
+	Title     string
+	ObjectKey string
 }
 
 type audioService struct {
 	bucket string
+	ttl    time.Duration
+	pause  time.Duration
 	enc    *encryptor.Encryptor
-	fs     repository.FileReader
+	fs     repository.FileStore
+	us     repository.URLSigner
 }
 
-func NewAudioService(fs repository.FileReader, audioBucket string) AudioService {
+// NewAudioService pauses for chapterPause between the chapters of the books it assembles.
+// A nil us disables signed URLs.
+func NewAudioService(fs repository.FileStore, us repository.URLSigner, enc *encryptor.Encryptor, audioBucket string, urlTTL, chapterPause time.Duration) AudioService {
 	return &audioService{
 		bucket: audioBucket,
+		ttl:    urlTTL,
+		pause:  chapterPause,
+		enc:    enc,
 		fs:     fs,
+		us:     us,
 	}
 }
 
-func (a *audioService) Get(ctx context.Context, key string) (*domain.File, error) {
-	file, err := a.fs.ReadLarge(ctx, a.bucket, key)
+func (a *audioService) Key(userID uint64, objectKey string) (string, error) {
+	return a.enc.EncryptFor(objectKey, encryptor.Binding{UserID: userID, Purpose: audioPurpose})
+}
+
+func (a *audioService) Get(ctx context.Context, userID uint64, key string) (*domain.File, error) {
+	objectKey, err := a.objectKey(userID, key)
 	if err != nil {
 		return nil, err
 	}
 
+	file, err := a.fs.ReadLarge(ctx, a.bucket, objectKey)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotExist) {
+			return nil, ErrFileNotFound
+		}
+		return nil, err
+	}
+
 	if file == nil {
-		return nil, errors.New("file not found")
+		return nil, ErrFileNotFound
 	}
 
 	return file, nil
 }
+
+func (a *audioService) URL(ctx context.Context, userID uint64, key string) (string, time.Time, error) {
+	if a.us == nil {
+		return "", time.Time{}, ErrURLsDisabled
+	}
+
+	objectKey, err := a.objectKey(userID, key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(a.ttl)
+
+	url, err := a.us.SignURL(ctx, a.bucket, objectKey, a.ttl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return url, expiresAt, nil
+}
+
+func (a *audioService) Delete(ctx context.Context, objectKey string) error {
+	if err := a.fs.Delete(ctx, a.bucket, objectKey); err != nil && !errors.Is(err, repository.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (a *audioService) Book(ctx context.Context, chapters []BookChapter) (*audio.Assembly, error) {
+	parts := make([]audio.Chapter, 0, len(chapters))
+	for _, chapter := range chapters {
+		parts = append(parts, audio.Chapter{
+			Title: chapter.Title,
+			Audio: audio.WAV(a.opener(ctx, chapter.ObjectKey)),
+		})
+	}
+
+	return audio.Plan(parts, audio.Options{ChapterPause: a.pause})
+}
+
+// opener returns a function opening the converted audio stored under the S3 key.
+func (a *audioService) opener(ctx context.Context, objectKey string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		file, err := a.fs.ReadLarge(ctx, a.bucket, objectKey)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotExist) {
+				return nil, ErrFileNotFound
+			}
+			return nil, err
+		}
+
+		if file == nil {
+			return nil, ErrFileNotFound
+		}
+
+		return fileReader{file}, nil
+	}
+}
+
+// fileReader reads the body of a file, closing it along with the file.
+type fileReader struct {
+	*domain.File
+}
+
+func (f fileReader) Read(p []byte) (int, error) {
+	return f.Body().Read(p)
+}
+
+// objectKey decrypts a key issued by Key back to the S3 key.
+func (a *audioService) objectKey(userID uint64, key string) (string, error) {
+	objectKey, err := a.enc.DecryptFor(key, encryptor.Binding{UserID: userID, Purpose: audioPurpose})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+
+	return string(objectKey), nil
+}
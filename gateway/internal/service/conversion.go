@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/internal/repository"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+	"io"
+	"path"
+)
+
+// conversionPurpose binds the keys of voice conversion uploads and models so that they cannot be used as keys
+// of another kind.
+const conversionPurpose = "voice-conversion"
+
+// modelExtension is the extension of the RVC voice models the worker loads.
+const modelExtension = ".pth"
+
+type ConversionService interface {
+	// SaveSource saves the audio whose voice is converted and returns the key.
+	// WAV and MP3 audio is accepted, its type being the declared one, or the one implied by the filename's extension,
+	// and anything else fails with ErrUnsupportedAudio.
+	// The returned key is the S3 key encrypted for the user, and only resolves for that user.
+	SaveSource(ctx context.Context, userID uint64, filename, mimetype string, file io.Reader) (string, error)
+
+	// ModelKey returns the key of the catalog model with the given id, which the audio is converted with.
+	// Models out of the catalog fail with ErrUnknownModel, and an empty id returns an empty key,
+	// for the model bundled with the worker.
+	// The returned key is the S3 key encrypted for the user, as those of uploads are, and only resolves for that user.
+	ModelKey(userID uint64, id string) (string, error)
+}
+
+type conversionService struct {
+	bucket string
+	enc    *encryptor.Encryptor
+	fs     repository.LargeFileWriter
+	mc     ModelCatalog
+}
+
+// NewConversionService stores the uploads of voice conversions through fs,
+// as multipart uploads since audio runs into the hundreds of megabytes.
+// Audio is converted with the models of mc, stored in the voice bucket ahead of time.
+func NewConversionService(fs repository.LargeFileWriter, enc *encryptor.Encryptor, mc ModelCatalog, voiceBucket string) ConversionService {
+	return &conversionService{
+		bucket: voiceBucket,
+		enc:    enc,
+		fs:     fs,
+		mc:     mc,
+	}
+}
+
+func (cs *conversionService) SaveSource(ctx context.Context, userID uint64, filename, mimetype string, file io.Reader) (string, error) {
+	if !isAudio(mimetype) {
+		mimetype = domain.TypeByExtension(path.Ext(filename))
+		if !isAudio(mimetype) {
+			return "", ErrUnsupportedAudio
+		}
+	}
+
+	return cs.save(ctx, userID, filename, domain.NewFile(conversionKey(userID, "audio", domain.ExtensionByType(mimetype)), mimetype, file))
+}
+
+func (cs *conversionService) ModelKey(userID uint64, id string) (string, error) {
+	if id == "" {
+		return "", nil
+	}
+
+	model, err := cs.mc.Resolve(id)
+	if err != nil {
+		return "", err
+	}
+
+	return cs.enc.EncryptFor(model.Key, encryptor.Binding{UserID: userID, Purpose: conversionPurpose})
+}
+
+// save uploads the file and returns its key, encrypted for the user.
+func (cs *conversionService) save(ctx context.Context, userID uint64, filename string, file *domain.File) (string, error) {
+	key, err := cs.enc.EncryptFor(file.Name(), encryptor.Binding{UserID: userID, Purpose: conversionPurpose})
+	if err != nil {
+		return "", err
+	}
+
+	file.SetMetadata(filenameMeta, filename)
+	if err = cs.fs.SaveLarge(ctx, cs.bucket, file); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", filename, err)
+	}
+
+	return key, nil
+}
+
+// isAudio reports whether the MIME type is of audio the worker converts.
+func isAudio(mimetype string) bool {
+	return mimetype == "audio/wav" || mimetype == "audio/mpeg"
+}
+
+// conversionKey returns a fresh S3 key for a voice conversion upload of the given kind.
+// Keys never derive from client input, so uploads cannot overwrite each other.
+func conversionKey(userID uint64, kind, ext string) string {
+	if userID == 0 {
+		return fmt.Sprintf("%s/%s%s", kind, rand.Text(), ext)
+	}
+
+	return fmt.Sprintf("%d/%s/%s%s", userID, kind, rand.Text(), ext)
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/internal/repository"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+)
+
+func TestConversionService(t *testing.T) {
+	enc, err := encryptor.NewEncryptor("0123456780123456789abcdef9abcdef0123456780123456789abcdef9abcdef")
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	mc, err := NewModelCatalog([]domain.VoiceModel{{ID: "singer", Name: "Singer", Key: "models/singer.pth"}})
+	if err != nil {
+		t.Fatalf("Failed to create model catalog: %v", err)
+	}
+
+	fs := repository.NewMemoryStore()
+	if err = fs.SaveLarge(context.Background(), "voice", domain.NewFile("models/singer.pth", "application/octet-stream", strings.NewReader("weights"))); err != nil {
+		t.Fatalf("Failed to store model: %v", err)
+	}
+	cs := NewConversionService(fs, enc, mc, "voice")
+
+	// read decrypts a key issued to the user and reads back what it was saved as
+	read := func(t *testing.T, key string) (string, string, string) {
+		objectKey, err := enc.DecryptFor(key, encryptor.Binding{UserID: 7, Purpose: conversionPurpose})
+		if err != nil {
+			t.Fatalf("Failed to decrypt key: %v", err)
+		}
+
+		file, err := fs.ReadLarge(context.Background(), "voice", string(objectKey))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", objectKey, err)
+		}
+		defer file.Close()
+
+		body, err := io.ReadAll(file.Body())
+		if err != nil {
+			t.Fatalf("Failed to read body: %v", err)
+		}
+
+		return string(objectKey), file.Type(), string(body)
+	}
+
+	t.Run("saves audio of its declared type", func(t *testing.T) {
+		key, err := cs.SaveSource(context.Background(), 7, "song.bin", "audio/mpeg", strings.NewReader("mp3"))
+		if err != nil {
+			t.Fatalf("Failed to save source: %v", err)
+		}
+
+		objectKey, mimetype, body := read(t, key)
+		if !strings.HasPrefix(objectKey, "7/audio/") || !strings.HasSuffix(objectKey, ".mp3") {
+			t.Errorf("Expected an mp3 under the user's audio, got %q", objectKey)
+		}
+
+		if mimetype != "audio/mpeg" || body != "mp3" {
+			t.Errorf("Expected %q of type audio/mpeg, got %q of type %q", "mp3", body, mimetype)
+		}
+	})
+
+	t.Run("falls back on the extension", func(t *testing.T) {
+		key, err := cs.SaveSource(context.Background(), 7, "take.wav", "application/octet-stream", strings.NewReader("wav"))
+		if err != nil {
+			t.Fatalf("Failed to save source: %v", err)
+		}
+
+		if _, mimetype, _ := read(t, key); mimetype != "audio/wav" {
+			t.Errorf("Expected %q, got %q", "audio/wav", mimetype)
+		}
+	})
+
+	t.Run("refuses anything but audio", func(t *testing.T) {
+		if _, err := cs.SaveSource(context.Background(), 7, "notes.txt", "text/plain", strings.NewReader("text")); !errors.Is(err, ErrUnsupportedAudio) {
+			t.Errorf("Expected %v, got %v", ErrUnsupportedAudio, err)
+		}
+	})
+
+	t.Run("resolves catalog models", func(t *testing.T) {
+		key, err := cs.ModelKey(7, "singer")
+		if err != nil {
+			t.Fatalf("Failed to resolve model: %v", err)
+		}
+
+		if objectKey, _, body := read(t, key); objectKey != "models/singer.pth" || body != "weights" {
+			t.Errorf("Expected the weights of the catalog model, got %q at %q", body, objectKey)
+		}
+	})
+
+	t.Run("refuses models out of the catalog", func(t *testing.T) {
+		if _, err := cs.ModelKey(7, "7/models/upload.pth"); !errors.Is(err, ErrUnknownModel) {
+			t.Errorf("Expected %v, got %v", ErrUnknownModel, err)
+		}
+	})
+
+	t.Run("leaves the bundled model to the worker", func(t *testing.T) {
+		if key, err := cs.ModelKey(7, ""); err != nil || key != "" {
+			t.Errorf("Expected an empty key, got %q, %v", key, err)
+		}
+	})
+
+	t.Run("keys only resolve for their user", func(t *testing.T) {
+		key, err := cs.ModelKey(7, "singer")
+		if err != nil {
+			t.Fatalf("Failed to resolve model: %v", err)
+		}
+
+		if _, err = enc.DecryptFor(key, encryptor.Binding{UserID: 8, Purpose: conversionPurpose}); err == nil {
+			t.Error("Expected the key of another user to be refused")
+		}
+	})
+
+	t.Run("publishes the S3 keys of its uploads and models", func(t *testing.T) {
+		fileKey, err := cs.SaveSource(context.Background(), 7, "take.wav", "audio/wav", strings.NewReader("wav"))
+		if err != nil {
+			t.Fatalf("Failed to save source: %v", err)
+		}
+
+		modelKey, err := cs.ModelKey(7, "singer")
+		if err != nil {
+			t.Fatalf("Failed to resolve model: %v", err)
+		}
+
+		ob := &fakeOutbox{pending: []*pb.OutboxEntry{{
+			Id:  "entry",
+			Job: &pb.Job{Id: "job", UserId: 7, Kind: pb.Kind_VoiceConversion, FileKey: fileKey, ModelKey: modelKey},
+		}}}
+		ps := &flakyPublisher{}
+		NewRelay(ob, ps, enc, 0).(*relay).drain(context.Background())
+
+		if len(ps.converted) != 1 {
+			t.Fatalf("Expected the voice conversion to be published, got %v", ps.converted)
+		}
+
+		// the worker downloads what it is sent straight from the bucket
+		for _, key := range ps.converted[0][1:] {
+			file, err := fs.ReadLarge(context.Background(), "voice", key)
+			if err != nil {
+				t.Fatalf("Expected %q to be stored in the bucket, got %v", key, err)
+			}
+			file.Close()
+		}
+
+		if path.Ext(ps.converted[0][1]) != ".wav" || ps.converted[0][2] != "models/singer.pth" {
+			t.Errorf("Expected the key of the upload and of the catalog model, got %v", ps.converted[0][1:])
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package service
+
+import "errors"
+
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrInvalidKey   = errors.New("invalid file key")
+	ErrURLsDisabled = errors.New("signed urls are disabled")
+
+	ErrUnknownVoice    = errors.New("unknown voice")
+	ErrUnknownLanguage = errors.New("unknown language")
+	ErrVoiceLanguage   = errors.New("voice doesn't speak the language")
+	ErrInvalidSpeed    = errors.New("invalid speed")
+
+	ErrUnsupportedAudio = errors.New("unsupported audio type")
+	ErrUnknownModel     = errors.New("unknown voice model")
+
+	ErrKeyReused         = errors.New("idempotency key was used for a different request")
+	ErrRequestInProgress = errors.New("request with the idempotency key is in progress")
+)
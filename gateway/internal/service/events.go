@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// eventHistory is how many of the latest events of a job are kept for subscribers catching up.
+	eventHistory = 16
+	// eventRetention is how long the events of a job are kept after its latest one, when no one is subscribed.
+	eventRetention = time.Hour
+	// subscriberBuffer is how many events a subscriber may fall behind before it is dropped.
+	subscriberBuffer = 16
+
+	// eventRoute binds the events of every job status, published as "job.<status>".
+	eventRoute = "job.#"
+	// maxConsumeBackoff caps the wait between attempts to reconnect to the event exchange.
+	maxConsumeBackoff = 30 * time.Second
+)
+
+// JobEvent is the state of a job as of a change of its status, as published by the job service.
+// The latest event of a job supersedes all before it.
+type JobEvent struct {
+	JobID    string    `json:"job_id"`
+	UserID   uint64    `json:"user_id"`
+	ParentID string    `json:"parent_id,omitempty"`
+	Status   string    `json:"job_status"`
+	FileKey  string    `json:"file_key,omitempty"`
+	Version  uint64    `json:"version"`
+	At       time.Time `json:"at"`
+}
+
+// JobEvents hands the status events of jobs out to their subscribers.
+type JobEvents interface {
+	// Run consumes the events published by the job service until the context is done, reconnecting as needed.
+	Run(ctx context.Context)
+	// Publish records the event and hands it to the subscribers of its job.
+	// Events no newer than the latest recorded for the job are ignored.
+	Publish(e JobEvent)
+	// Subscribe returns the recorded events of a job whose version is after the given one,
+	// along with a channel of the events that follow them.
+	// The channel is closed once cancel is called, or when the subscriber falls too far behind,
+	// in which case it is expected to subscribe again from the last event it got.
+	Subscribe(jobID string, after uint64) (history []JobEvent, events <-chan JobEvent, cancel func())
+}
+
+type subscriber struct {
+	events chan JobEvent
+	closed bool
+}
+
+type jobHistory struct {
+	events []JobEvent
+	subs   map[*subscriber]struct{}
+	last   time.Time
+}
+
+type jobEvents struct {
+	dial     func() (*amqp.Connection, error)
+	exchange string
+
+	mu     sync.Mutex
+	jobs   map[string]*jobHistory
+	pruned time.Time
+}
+
+// NewJobEvents consumes job events from the given exchange, over connections made by dial.
+func NewJobEvents(dial func() (*amqp.Connection, error), exchange string) JobEvents {
+	return &jobEvents{
+		dial:     dial,
+		exchange: exchange,
+		jobs:     make(map[string]*jobHistory),
+		pruned:   time.Now(),
+	}
+}
+
+func (je *jobEvents) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		consumed, err := je.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if consumed {
+			backoff = time.Second
+		}
+		slog.Error("Job event consumer stopped, reconnecting", "error", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConsumeBackoff)
+	}
+}
+
+// consume binds a queue of its own to the event exchange, so that every gateway gets every event,
+// and publishes what it receives until the connection or the context is done.
+// It reports whether any event was consumed.
+func (je *jobEvents) consume(ctx context.Context) (bool, error) {
+	con, err := je.dial()
+	if err != nil {
+		return false, err
+	}
+	defer con.Close()
+	closed := con.NotifyClose(make(chan *amqp.Error, 1))
+
+	ch, err := con.Channel()
+	if err != nil {
+		return false, err
+	}
+	defer ch.Close()
+
+	if err = ch.ExchangeDeclare(je.exchange, "topic", true, false, false, false, nil); err != nil {
+		return false, err
+	}
+
+	// the queue lives as long as the connection, events missed in between are made up for by reading the job
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err = ch.QueueBind(q.Name, eventRoute, je.exchange, false, nil); err != nil {
+		return false, err
+	}
+
+	deliveries, err := ch.ConsumeWithContext(ctx, q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return false, err
+	}
+
+	consumed := false
+	for d := range deliveries {
+		var e JobEvent
+		if err = json.Unmarshal(d.Body, &e); err != nil || e.JobID == "" {
+			slog.Warn("Dropping malformed job event", "message", d.MessageId, "error", err)
+			continue
+		}
+
+		je.Publish(e)
+		consumed = true
+	}
+
+	// deliveries stop when the connection closes, or the context is done
+	select {
+	case reason, ok := <-closed:
+		if ok {
+			return consumed, reason
+		}
+	default:
+	}
+
+	return consumed, amqp.ErrClosed
+}
+
+func (je *jobEvents) Publish(e JobEvent) {
+	je.mu.Lock()
+	defer je.mu.Unlock()
+
+	now := time.Now()
+	je.prune(now)
+
+	h, ok := je.jobs[e.JobID]
+	if !ok {
+		h = &jobHistory{subs: make(map[*subscriber]struct{})}
+		je.jobs[e.JobID] = h
+	}
+
+	if n := len(h.events); n > 0 && h.events[n-1].Version >= e.Version {
+		return
+	}
+
+	h.last = now
+	h.events = append(h.events, e)
+	if len(h.events) > eventHistory {
+		h.events = h.events[len(h.events)-eventHistory:]
+	}
+
+	for sub := range h.subs {
+		select {
+		case sub.events <- e:
+		default:
+			// the subscriber catches up from its last event when it subscribes again
+			sub.closed = true
+			close(sub.events)
+			delete(h.subs, sub)
+		}
+	}
+}
+
+func (je *jobEvents) Subscribe(jobID string, after uint64) ([]JobEvent, <-chan JobEvent, func()) {
+	je.mu.Lock()
+	defer je.mu.Unlock()
+
+	h, ok := je.jobs[jobID]
+	if !ok {
+		h = &jobHistory{subs: make(map[*subscriber]struct{}), last: time.Now()}
+		je.jobs[jobID] = h
+	}
+
+	var history []JobEvent
+	for _, e := range h.events {
+		if e.Version > after {
+			history = append(history, e)
+		}
+	}
+
+	sub := &subscriber{events: make(chan JobEvent, subscriberBuffer)}
+	h.subs[sub] = struct{}{}
+
+	cancel := func() {
+		je.mu.Lock()
+		defer je.mu.Unlock()
+
+		if !sub.closed {
+			sub.closed = true
+			close(sub.events)
+			delete(h.subs, sub)
+			h.last = time.Now()
+		}
+	}
+
+	return history, sub.events, cancel
+}
+
+// prune forgets the events of jobs no one has subscribed to for eventRetention.
+// It runs at most once every tenth of eventRetention.
+func (je *jobEvents) prune(now time.Time) {
+	if now.Sub(je.pruned) < eventRetention/10 {
+		return
+	}
+	je.pruned = now
+
+	for id, h := range je.jobs {
+		if len(h.subs) == 0 && now.Sub(h.last) > eventRetention {
+			delete(je.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func versions(events []JobEvent) []uint64 {
+	var vs []uint64
+	for _, e := range events {
+		vs = append(vs, e.Version)
+	}
+
+	return vs
+}
+
+// received drains the events already sent on the channel.
+func received(events <-chan JobEvent) []JobEvent {
+	var got []JobEvent
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return got
+			}
+			got = append(got, e)
+		default:
+			return got
+		}
+	}
+}
+
+func TestJobEvents(t *testing.T) {
+	t.Run("history after the last event", func(t *testing.T) {
+		je := NewJobEvents(nil, "")
+		for v := uint64(1); v <= 3; v++ {
+			je.Publish(JobEvent{JobID: "job", Version: v})
+		}
+		je.Publish(JobEvent{JobID: "other", Version: 1})
+
+		history, _, cancel := je.Subscribe("job", 1)
+		defer cancel()
+
+		if want := []uint64{2, 3}; !slices.Equal(versions(history), want) {
+			t.Errorf("Expected history %v, got %v", want, versions(history))
+		}
+	})
+
+	t.Run("subscribers get new events of their job", func(t *testing.T) {
+		je := NewJobEvents(nil, "")
+		_, events, cancel := je.Subscribe("job", 0)
+		defer cancel()
+
+		je.Publish(JobEvent{JobID: "job", Version: 1})
+		je.Publish(JobEvent{JobID: "other", Version: 1})
+		je.Publish(JobEvent{JobID: "job", Version: 2})
+
+		if got := versions(received(events)); !slices.Equal(got, []uint64{1, 2}) {
+			t.Errorf("Expected events [1 2], got %v", got)
+		}
+	})
+
+	t.Run("stale and duplicate events are ignored", func(t *testing.T) {
+		je := NewJobEvents(nil, "")
+		_, events, cancel := je.Subscribe("job", 0)
+		defer cancel()
+
+		for _, v := range []uint64{1, 3, 3, 2, 4} {
+			je.Publish(JobEvent{JobID: "job", Version: v})
+		}
+
+		if got := versions(received(events)); !slices.Equal(got, []uint64{1, 3, 4}) {
+			t.Errorf("Expected events [1 3 4], got %v", got)
+		}
+	})
+
+	t.Run("history is bounded", func(t *testing.T) {
+		je := NewJobEvents(nil, "")
+		for v := uint64(1); v <= eventHistory+5; v++ {
+			je.Publish(JobEvent{JobID: "job", Version: v})
+		}
+
+		history, _, cancel := je.Subscribe("job", 0)
+		defer cancel()
+
+		if len(history) != eventHistory || history[0].Version != 6 {
+			t.Errorf("Expected the latest %d events, got %v", eventHistory, versions(history))
+		}
+	})
+
+	t.Run("slow subscribers are dropped", func(t *testing.T) {
+		je := NewJobEvents(nil, "")
+		_, events, cancel := je.Subscribe("job", 0)
+		defer cancel()
+
+		for v := uint64(1); v <= subscriberBuffer+1; v++ {
+			je.Publish(JobEvent{JobID: "job", Version: v})
+		}
+
+		got := received(events)
+		if len(got) != subscriberBuffer {
+			t.Errorf("Expected %d buffered events, got %d", subscriberBuffer, len(got))
+		}
+
+		if _, ok := <-events; ok {
+			t.Error("Expected the channel of a slow subscriber to be closed")
+		}
+	})
+
+	t.Run("cancel closes the channel once", func(t *testing.T) {
+		je := NewJobEvents(nil, "")
+		_, events, cancel := je.Subscribe("job", 0)
+		cancel()
+		cancel()
+
+		if _, ok := <-events; ok {
+			t.Error("Expected the channel to be closed")
+		}
+
+		// publishing to a job without subscribers must not block or panic
+		je.Publish(JobEvent{JobID: "job", Version: 1})
+	})
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/internal/repository"
+)
+
+const (
+	// IdempotencyTTL is how long the response of a request is replayed to its retries.
+	IdempotencyTTL = 24 * time.Hour
+	// idempotencyReservation is how long a key is held for a request in progress,
+	// after which a gateway that crashed midway no longer holds up its retries.
+	idempotencyReservation = 10 * time.Minute
+)
+
+type IdempotencyService interface {
+	// Begin reserves the idempotency key of the user for the request with the given fingerprint.
+	// It returns nil when the request is to be handled, and then completed or abandoned.
+	// When the key was used before for the same request, the record of that request is returned,
+	// whose response is to be replayed once it is completed, or ErrRequestInProgress until then.
+	// It returns ErrKeyReused when the key was used for a different request.
+	Begin(ctx context.Context, userID uint64, key, fingerprint string) (*domain.IdempotencyRecord, error)
+	// Complete saves the response of the request the key was reserved for, replayed for IdempotencyTTL.
+	Complete(ctx context.Context, userID uint64, key, fingerprint string, statusCode int, body []byte, jobID string) error
+	// Abandon frees the key of a request that didn't go through, so that it may be retried.
+	Abandon(ctx context.Context, userID uint64, key string) error
+}
+
+type idempotencyService struct {
+	is repository.IdempotencyStore
+}
+
+func NewIdempotencyService(is repository.IdempotencyStore) IdempotencyService {
+	return &idempotencyService{
+		is: is,
+	}
+}
+
+// scopedKey keeps the keys of users apart, so that no user is replayed the response of another.
+func scopedKey(userID uint64, key string) string {
+	return strconv.FormatUint(userID, 10) + "/" + key
+}
+
+func (s *idempotencyService) Begin(ctx context.Context, userID uint64, key, fingerprint string) (*domain.IdempotencyRecord, error) {
+	saved, err := s.is.Reserve(ctx, domain.NewIdempotencyRecord(scopedKey(userID, key), fingerprint, idempotencyReservation))
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, repository.ErrDuplicate):
+		return nil, err
+	case saved.Fingerprint != fingerprint:
+		return nil, ErrKeyReused
+	case !saved.Completed():
+		return nil, ErrRequestInProgress
+	}
+
+	return saved, nil
+}
+
+func (s *idempotencyService) Complete(ctx context.Context, userID uint64, key, fingerprint string, statusCode int, body []byte, jobID string) error {
+	record := domain.NewIdempotencyRecord(scopedKey(userID, key), fingerprint, 0)
+	record.Complete(statusCode, body, jobID, IdempotencyTTL)
+	return s.is.Save(ctx, record)
+}
+
+func (s *idempotencyService) Abandon(ctx context.Context, userID uint64, key string) error {
+	return s.is.Delete(ctx, scopedKey(userID, key))
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/repository"
+)
+
+func TestIdempotencyService(t *testing.T) {
+	ctx := context.Background()
+	is := NewIdempotencyService(repository.NewMemoryIdempotencyStore())
+
+	t.Run("reserves a new key", func(t *testing.T) {
+		saved, err := is.Begin(ctx, 7, "new", "a")
+		if err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		if saved != nil {
+			t.Errorf("Expected no record to replay, got %+v", saved)
+		}
+	})
+
+	t.Run("refuses retries while in progress", func(t *testing.T) {
+		if _, err := is.Begin(ctx, 7, "pending", "a"); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		if _, err := is.Begin(ctx, 7, "pending", "a"); !errors.Is(err, ErrRequestInProgress) {
+			t.Errorf("Expected %v, got %v", ErrRequestInProgress, err)
+		}
+	})
+
+	t.Run("replays completed requests", func(t *testing.T) {
+		if _, err := is.Begin(ctx, 7, "done", "a"); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		if err := is.Complete(ctx, 7, "done", "a", 202, []byte(`{"id":"job"}`), "job"); err != nil {
+			t.Fatalf("Failed to complete: %v", err)
+		}
+
+		saved, err := is.Begin(ctx, 7, "done", "a")
+		if err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		if saved == nil || saved.StatusCode != 202 || string(saved.Body) != `{"id":"job"}` || saved.JobID != "job" {
+			t.Errorf("Expected the completed response to be replayed, got %+v", saved)
+		}
+	})
+
+	t.Run("refuses keys reused for another request", func(t *testing.T) {
+		if _, err := is.Begin(ctx, 7, "reused", "a"); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		if _, err := is.Begin(ctx, 7, "reused", "b"); !errors.Is(err, ErrKeyReused) {
+			t.Errorf("Expected %v, got %v", ErrKeyReused, err)
+		}
+	})
+
+	t.Run("frees abandoned keys", func(t *testing.T) {
+		if _, err := is.Begin(ctx, 7, "abandoned", "a"); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		if err := is.Abandon(ctx, 7, "abandoned"); err != nil {
+			t.Fatalf("Failed to abandon: %v", err)
+		}
+
+		if saved, err := is.Begin(ctx, 7, "abandoned", "b"); err != nil || saved != nil {
+			t.Errorf("Expected the key to be free, got %+v, %v", saved, err)
+		}
+	})
+
+	t.Run("keeps the keys of users apart", func(t *testing.T) {
+		if _, err := is.Begin(ctx, 7, "shared", "a"); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		if saved, err := is.Begin(ctx, 8, "shared", "b"); err != nil || saved != nil {
+			t.Errorf("Expected the key to be free for another user, got %+v, %v", saved, err)
+		}
+	})
+}
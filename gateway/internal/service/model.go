@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+// ModelCatalog is what the voice of audio may be converted with.
+// Loading an RVC voice model runs the code pickled in it on the worker, so only the models the operators vetted
+// are listed, which they store in the voice bucket beforehand, rather than models uploaded along with the audio.
+type ModelCatalog interface {
+	// Models returns the models of the catalog.
+	Models() []domain.VoiceModel
+	// Resolve returns the model with the given id, or fails with ErrUnknownModel.
+	Resolve(id string) (domain.VoiceModel, error)
+}
+
+// modelCatalogFile is the JSON layout of a model catalog.
+type modelCatalogFile struct {
+	Models []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		// Key is the S3 key of the model in the voice bucket.
+		Key string `json:"key"`
+	} `json:"models"`
+}
+
+type modelCatalog struct {
+	models []domain.VoiceModel
+	byID   map[string]domain.VoiceModel
+}
+
+// LoadModelCatalog reads a model catalog from a JSON file, or returns an empty catalog if path is empty,
+// audio then being converted with the model bundled with the worker only.
+func LoadModelCatalog(path string) (ModelCatalog, error) {
+	if path == "" {
+		return NewModelCatalog(nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model catalog: %w", err)
+	}
+
+	var mf modelCatalogFile
+	if err = json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("failed to parse model catalog: %w", err)
+	}
+
+	models := make([]domain.VoiceModel, 0, len(mf.Models))
+	for _, m := range mf.Models {
+		models = append(models, domain.VoiceModel{ID: m.ID, Name: m.Name, Key: m.Key})
+	}
+
+	return NewModelCatalog(models)
+}
+
+// NewModelCatalog checks that every model has an id of its own and the key of a .pth file.
+func NewModelCatalog(models []domain.VoiceModel) (ModelCatalog, error) {
+	mc := &modelCatalog{
+		models: append([]domain.VoiceModel{}, models...),
+		byID:   make(map[string]domain.VoiceModel, len(models)),
+	}
+
+	for _, m := range models {
+		switch {
+		case m.ID == "":
+			return nil, fmt.Errorf("model %q has no id", m.Name)
+		case path.Ext(m.Key) != modelExtension:
+			return nil, fmt.Errorf("model %q must be the key of a %s file, got %q", m.ID, modelExtension, m.Key)
+		}
+
+		if _, ok := mc.byID[m.ID]; ok {
+			return nil, fmt.Errorf("model %q is listed twice", m.ID)
+		}
+		mc.byID[m.ID] = m
+	}
+
+	return mc, nil
+}
+
+func (mc *modelCatalog) Models() []domain.VoiceModel {
+	return mc.models
+}
+
+func (mc *modelCatalog) Resolve(id string) (domain.VoiceModel, error) {
+	m, ok := mc.byID[id]
+	if !ok {
+		return domain.VoiceModel{}, fmt.Errorf("%w: %q", ErrUnknownModel, id)
+	}
+
+	return m, nil
+}
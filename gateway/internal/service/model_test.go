@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+func TestLoadModelCatalog(t *testing.T) {
+	mc, err := LoadModelCatalog("")
+	if err != nil {
+		t.Fatalf("Failed to load the default catalog: %v", err)
+	}
+
+	if models := mc.Models(); models == nil || len(models) != 0 {
+		t.Errorf("Expected an empty list of models, got %v", models)
+	}
+
+	path := filepath.Join(t.TempDir(), "models.json")
+	if err = os.WriteFile(path, []byte(`{"models": [{"id": "singer", "name": "Singer", "key": "models/singer.pth"}]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write catalog: %v", err)
+	}
+
+	if mc, err = LoadModelCatalog(path); err != nil {
+		t.Fatalf("Failed to load catalog: %v", err)
+	}
+
+	model, err := mc.Resolve("singer")
+	if err != nil {
+		t.Fatalf("Failed to resolve model: %v", err)
+	}
+
+	if want := (domain.VoiceModel{ID: "singer", Name: "Singer", Key: "models/singer.pth"}); model != want {
+		t.Errorf("Expected %+v, got %+v", want, model)
+	}
+
+	if _, err = mc.Resolve("models/singer.pth"); !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("Expected %v resolving a key, got %v", ErrUnknownModel, err)
+	}
+}
+
+func TestNewModelCatalog(t *testing.T) {
+	singer := domain.VoiceModel{ID: "singer", Key: "models/singer.pth"}
+
+	if _, err := NewModelCatalog([]domain.VoiceModel{singer}); err != nil {
+		t.Errorf("Expected a valid catalog, got %v", err)
+	}
+
+	if _, err := NewModelCatalog([]domain.VoiceModel{singer, singer}); err == nil {
+		t.Error("Expected a model listed twice to be refused")
+	}
+
+	if _, err := NewModelCatalog([]domain.VoiceModel{{Key: "models/singer.pth"}}); err == nil {
+		t.Error("Expected a model without an id to be refused")
+	}
+
+	if _, err := NewModelCatalog([]domain.VoiceModel{{ID: "singer", Key: "models/singer.index"}}); err == nil {
+		t.Error("Expected a model that isn't a .pth file to be refused")
+	}
+}
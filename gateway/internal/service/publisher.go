@@ -2,25 +2,114 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+var (
+	// ErrUnroutable is matched by the ReturnedError of a message no queue is bound to receive.
+	ErrUnroutable = errors.New("message unroutable")
+	// ErrNacked is returned when the broker refuses responsibility for a message.
+	ErrNacked = errors.New("message nacked by broker")
+	// ErrPublisherClosed is returned by publishes after Close.
+	ErrPublisherClosed = errors.New("publisher closed")
 )
 
+// ReturnedError reports a mandatory message the broker returned instead of routing.
+// It matches ErrUnroutable.
+type ReturnedError struct {
+	Exchange   string
+	RoutingKey string
+	Code       uint16
+	Reason     string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("message to exchange %q with routing key %q returned: %d %s", e.Exchange, e.RoutingKey, e.Code, e.Reason)
+}
+
+func (e *ReturnedError) Is(target error) bool {
+	return target == ErrUnroutable
+}
+
 type Publisher interface {
-	PublishConversion(ctx context.Context, jobId, fileKey string) error
+	// PublishConversion publishes a conversion request and waits for the broker to confirm it.
+	// The request carries the voice settings of the job, the worker choosing for itself what they leave out,
+	// and the segments its audio is assembled from, one per paragraph of the text, if any.
+	// The worker only converts the paragraphs whose segments aren't reused, and pauses between them
+	// for the paragraph pause of the publisher.
+	// A request that no queue is bound to receive fails with an error matching ErrUnroutable.
+	PublishConversion(ctx context.Context, jobId, fileKey string, voice domain.VoiceSettings, segments []domain.Segment) error
+	// PublishVoiceConversion publishes the request of a voice conversion, on a route key of its own,
+	// and waits for the broker to confirm it. The file and model keys are the S3 keys the worker downloads,
+	// an empty model key leaving the worker with the model bundled with it.
+	// A request that no queue is bound to receive fails with an error matching ErrUnroutable.
+	PublishVoiceConversion(ctx context.Context, jobId, fileKey, modelKey string) error
+	// PublishCancellation tells the workers to stop converting a job, and waits for the broker to confirm it.
+	// Workers check for it before and while converting, so that cancelled jobs don't keep them busy.
+	// A cancellation that no queue is bound to receive fails with an error matching ErrUnroutable.
+	PublishCancellation(ctx context.Context, jobId string) error
+	// Close closes the pooled channels and the connection.
+	Close() error
 }
 
 type routeKey struct {
-	text string
+	text   string
+	voice  string
+	cancel string
+}
+
+// confirmChannel is a channel in confirm mode, along with the messages the broker returned on it.
+type confirmChannel struct {
+	ch      *amqp.Channel
+	returns chan amqp.Return
 }
 
 type publisher struct {
 	exchange string
-	con      *amqp.Connection
 	rk       routeKey
+	// pause is the silence the worker puts between the paragraphs of the audio it assembles.
+	pause time.Duration
+
+	dial   func() (*amqp.Connection, error)
+	mu     sync.Mutex
+	con    *amqp.Connection
+	closed bool
+
+	// pool holds idle channels. Each channel carries one publish at a time,
+	// so that returns and confirms unambiguously belong to it.
+	pool chan *confirmChannel
 }
 
-func NewPublisher(con *amqp.Connection, exchangeName, textRouteKey string) (Publisher, error) {
+// NewPublisher connects with dial and declares the exchange.
+// Up to poolSize idle channels are kept open for reuse. When the connection drops,
+// the next publish dials a new one. Conversions are published with paragraphPause as the silence between paragraphs.
+func NewPublisher(dial func() (*amqp.Connection, error), exchangeName, textRouteKey, voiceRouteKey, cancelRouteKey string, paragraphPause time.Duration, poolSize int) (Publisher, error) {
+	p := &publisher{
+		exchange: exchangeName,
+		rk: routeKey{
+			text:   textRouteKey,   // "file.text"
+			voice:  voiceRouteKey,  // "file.audio"
+			cancel: cancelRouteKey, // "job.cancel"
+		},
+		pause: paragraphPause,
+		dial:  dial,
+		pool:  make(chan *confirmChannel, poolSize),
+	}
+
+	con, err := p.connection()
+	if err != nil {
+		return nil, err
+	}
+
 	ch, err := con.Channel()
 	if err != nil {
 		return nil, err
@@ -31,24 +120,54 @@ func NewPublisher(con *amqp.Connection, exchangeName, textRouteKey string) (Publ
 		return nil, err
 	}
 
-	return &publisher{
-		exchange: exchangeName,
-		con:      con,
-		rk: routeKey{
-			text: textRouteKey, // "file.text"
-		},
-	}, nil
+	return p, nil
 }
 
-func (p *publisher) PublishConversion(ctx context.Context, jobId, fileKey string) error {
+func (p *publisher) PublishConversion(ctx context.Context, jobId, fileKey string, voice domain.VoiceSettings, segments []domain.Segment) error {
+	req := struct {
+		JobId     string           `json:"job_id"`
+		JobStatus string           `json:"job_status"`
+		FileKey   string           `json:"file_key"`
+		Voice     string           `json:"voice,omitempty"`
+		Speed     float64          `json:"speed,omitempty"`
+		LangCode  string           `json:"lang_code,omitempty"`
+		Segments  []domain.Segment `json:"segments,omitempty"`
+		// PauseMs is the silence between paragraphs in milliseconds, left out for none.
+		PauseMs int64 `json:"paragraph_pause_ms,omitempty"`
+	}{
+		JobId:     jobId,
+		JobStatus: "Processing",
+		FileKey:   fileKey,
+		Voice:     voice.Voice,
+		Speed:     voice.Speed,
+		LangCode:  voice.Language,
+		Segments:  segments,
+		PauseMs:   p.pause.Milliseconds(),
+	}
+
+	msg, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return p.publish(ctx, p.rk.text, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         msg,
+	})
+}
+
+func (p *publisher) PublishVoiceConversion(ctx context.Context, jobId, fileKey, modelKey string) error {
 	req := struct {
 		JobId     string `json:"job_id"`
 		JobStatus string `json:"job_status"`
 		FileKey   string `json:"file_key"`
+		ModelKey  string `json:"model_key,omitempty"`
 	}{
 		JobId:     jobId,
 		JobStatus: "Processing",
 		FileKey:   fileKey,
+		ModelKey:  modelKey,
 	}
 
 	msg, err := json.Marshal(req)
@@ -56,22 +175,175 @@ func (p *publisher) PublishConversion(ctx context.Context, jobId, fileKey string
 		return err
 	}
 
-	ch, err := p.con.Channel()
+	return p.publish(ctx, p.rk.voice, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         msg,
+	})
+}
+
+func (p *publisher) PublishCancellation(ctx context.Context, jobId string) error {
+	req := struct {
+		JobId     string `json:"job_id"`
+		JobStatus string `json:"job_status"`
+	}{
+		JobId:     jobId,
+		JobStatus: "Cancelled",
+	}
+
+	msg, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
-	defer ch.Close()
 
-	return ch.PublishWithContext(ctx,
-		p.exchange,
-		p.rk.text,
-		true,
-		false,
-		amqp.Publishing{
-			// UserId:
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         msg,
-		},
-	)
+	return p.publish(ctx, p.rk.cancel, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         msg,
+	})
+}
+
+// publish publishes a mandatory message and waits for its confirmation.
+func (p *publisher) publish(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	cc, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	// the message id ties a return to this publish
+	msg.MessageId = rand.Text()
+
+	dc, err := cc.ch.PublishWithDeferredConfirmWithContext(ctx, p.exchange, routingKey, true, false, msg)
+	if err != nil {
+		cc.ch.Close()
+		return fmt.Errorf("failed to publish to exchange %s: %w", p.exchange, err)
+	}
+
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		// the confirmation may still arrive, so the channel can't carry another publish
+		cc.ch.Close()
+		return fmt.Errorf("failed waiting for publish confirmation: %w", err)
+	}
+
+	if !acked {
+		if cc.ch.IsClosed() {
+			return fmt.Errorf("channel closed before publish was confirmed: %w", ErrNacked)
+		}
+		p.release(cc)
+		return ErrNacked
+	}
+
+	// the broker sends a return before the confirmation of the same message,
+	// and the client delivers it before completing the confirmation
+	for {
+		select {
+		case ret, ok := <-cc.returns:
+			if !ok {
+				return nil
+			}
+			if ret.MessageId != msg.MessageId {
+				continue
+			}
+			p.release(cc)
+			return &ReturnedError{
+				Exchange:   ret.Exchange,
+				RoutingKey: ret.RoutingKey,
+				Code:       ret.ReplyCode,
+				Reason:     ret.ReplyText,
+			}
+		default:
+			p.release(cc)
+			return nil
+		}
+	}
+}
+
+// channel takes an idle channel from the pool, or opens a new one.
+func (p *publisher) channel() (*confirmChannel, error) {
+	for {
+		select {
+		case cc := <-p.pool:
+			if !cc.ch.IsClosed() {
+				return cc, nil
+			}
+		default:
+			return p.open()
+		}
+	}
+}
+
+func (p *publisher) open() (*confirmChannel, error) {
+	con, err := p.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := con.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	if err = ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to put channel in confirm mode: %w", err)
+	}
+
+	return &confirmChannel{
+		ch:      ch,
+		returns: ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// release puts a channel back in the pool, closing it if the pool is full.
+func (p *publisher) release(cc *confirmChannel) {
+	select {
+	case p.pool <- cc:
+	default:
+		cc.ch.Close()
+	}
+}
+
+// connection returns the open connection, dialing a new one if it dropped.
+func (p *publisher) connection() (*amqp.Connection, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPublisherClosed
+	}
+
+	if p.con != nil && !p.con.IsClosed() {
+		return p.con, nil
+	}
+
+	if p.con != nil {
+		slog.Warn("RabbitMQ connection lost, reconnecting")
+	}
+
+	con, err := p.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rabbitmq: %w", err)
+	}
+	p.con = con
+
+	return con, nil
+}
+
+func (p *publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for {
+		select {
+		case cc := <-p.pool:
+			cc.ch.Close()
+		default:
+			if p.con == nil {
+				return nil
+			}
+			return p.con.Close()
+		}
+	}
 }
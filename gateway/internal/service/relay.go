@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+)
+
+// relayBatch is how many outbox entries the relay claims at a time.
+const relayBatch = 10
+
+// Relay publishes the conversion requests recorded in the job service's outbox.
+type Relay interface {
+	// Run publishes due entries every interval, and whenever nudged, until the context is done.
+	Run(ctx context.Context)
+	// Nudge asks the relay to publish due entries now, without waiting for the next interval.
+	// It never blocks.
+	Nudge()
+}
+
+type relay struct {
+	jsc      pb.JobServiceClient
+	ps       Publisher
+	enc      *encryptor.Encryptor
+	interval time.Duration
+	nudge    chan struct{}
+}
+
+// NewRelay decrypts the keys jobs were submitted with using enc, so that workers are sent the S3 keys of their files.
+func NewRelay(jsc pb.JobServiceClient, ps Publisher, enc *encryptor.Encryptor, interval time.Duration) Relay {
+	return &relay{
+		jsc:      jsc,
+		ps:       ps,
+		enc:      enc,
+		interval: interval,
+		nudge:    make(chan struct{}, 1),
+	}
+}
+
+func (r *relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.nudge:
+		}
+
+		r.drain(ctx)
+	}
+}
+
+func (r *relay) Nudge() {
+	select {
+	case r.nudge <- struct{}{}:
+	default: // a drain is already pending
+	}
+}
+
+// drain publishes due entries until a claim comes back short.
+// Entries that fail to publish are left unacknowledged, and the job service hands them out again once their lease,
+// which grows with every attempt, runs out.
+func (r *relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		resp, err := r.jsc.ClaimOutbox(ctx, &pb.ClaimOutboxRequest{Limit: relayBatch})
+		if err != nil {
+			slog.Error("Failed to claim outbox entries", "error", err)
+			return
+		}
+
+		sent := make([]string, 0, len(resp.Entries))
+		for _, entry := range resp.Entries {
+			if err = r.publish(ctx, entry); err != nil {
+				slog.Error("Failed to publish conversion", "job", entry.Job.Id, "attempts", entry.Attempts, "error", err)
+				continue
+			}
+			sent = append(sent, entry.Id)
+		}
+
+		if len(sent) > 0 {
+			if _, err = r.jsc.AckOutbox(ctx, &pb.AckOutboxRequest{Ids: sent}); err != nil {
+				// the entries are published again once their lease runs out
+				slog.Error("Failed to acknowledge outbox entries", "error", err)
+				return
+			}
+		}
+
+		if len(resp.Entries) < relayBatch {
+			return
+		}
+	}
+}
+
+// publish publishes the conversion request of a job to the worker of its kind, or its cancellation to every worker.
+func (r *relay) publish(ctx context.Context, entry *pb.OutboxEntry) error {
+	job := entry.Job
+	if entry.Kind == pb.OutboxKind_OutboxCancellation {
+		// without any worker listening, none is converting the job either
+		if err := r.ps.PublishCancellation(ctx, job.Id); err != nil && !errors.Is(err, ErrUnroutable) {
+			return err
+		}
+		return nil
+	}
+
+	if job.Kind == pb.Kind_VoiceConversion {
+		fileKey, err := r.objectKey(job, job.FileKey, conversionPurpose)
+		if err != nil {
+			return err
+		}
+
+		// jobs without a model are converted with the one bundled with the worker
+		var modelKey string
+		if job.ModelKey != "" {
+			if modelKey, err = r.objectKey(job, job.ModelKey, conversionPurpose); err != nil {
+				return err
+			}
+		}
+
+		return r.ps.PublishVoiceConversion(ctx, job.Id, fileKey, modelKey)
+	}
+
+	// jobs keep the key handed out to their user, workers download the S3 key behind it
+	fileKey, err := r.objectKey(job, job.FileKey, textPurpose)
+	if err != nil {
+		return err
+	}
+
+	segments := make([]domain.Segment, 0, len(entry.GetSegments()))
+	for _, s := range entry.GetSegments() {
+		segments = append(segments, domain.Segment{Key: s.GetKey(), Reused: s.GetReused()})
+	}
+
+	return r.ps.PublishConversion(ctx, job.Id, fileKey, domain.VoiceSettings{
+		Voice:    job.GetVoice().GetVoice(),
+		Speed:    job.GetVoice().GetSpeed(),
+		Language: job.GetVoice().GetLanguage(),
+	}, segments)
+}
+
+// objectKey decrypts a key issued to the user of a job for the given purpose back to the S3 key.
+func (r *relay) objectKey(job *pb.Job, key, purpose string) (string, error) {
+	objectKey, err := r.enc.DecryptFor(key, encryptor.Binding{UserID: job.GetUserId(), Purpose: purpose})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+
+	return string(objectKey), nil
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
+	pb "github.com/ziliscite/bard_narate/gateway/pkg/protobuf"
+	"google.golang.org/grpc"
+)
+
+// fakeOutbox hands out every unacknowledged entry on each claim, as if leases ran out immediately.
+type fakeOutbox struct {
+	pb.JobServiceClient
+
+	mu      sync.Mutex
+	pending []*pb.OutboxEntry
+	acked   []string
+}
+
+func (f *fakeOutbox) ClaimOutbox(_ context.Context, req *pb.ClaimOutboxRequest, _ ...grpc.CallOption) (*pb.ClaimOutboxResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := min(int(req.Limit), len(f.pending))
+	return &pb.ClaimOutboxResponse{Entries: slices.Clone(f.pending[:n])}, nil
+}
+
+func (f *fakeOutbox) AckOutbox(_ context.Context, req *pb.AckOutboxRequest, _ ...grpc.CallOption) (*pb.AckOutboxResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.acked = append(f.acked, req.Ids...)
+	f.pending = slices.DeleteFunc(f.pending, func(e *pb.OutboxEntry) bool {
+		return slices.Contains(req.Ids, e.Id)
+	})
+	return &pb.AckOutboxResponse{}, nil
+}
+
+type flakyPublisher struct {
+	down      bool
+	published []string
+	// fileKeys holds the file keys the conversions were published with.
+	fileKeys []string
+	// converted holds the voice conversions published, as job id, file key and model key triples.
+	converted [][3]string
+	cancelled []string
+	// unroutable fails cancellations as if no worker was listening.
+	unroutable bool
+}
+
+func (f *flakyPublisher) PublishConversion(_ context.Context, jobId, fileKey string, _ domain.VoiceSettings, _ []domain.Segment) error {
+	if f.down {
+		return errors.New("broker unavailable")
+	}
+	f.published = append(f.published, jobId)
+	f.fileKeys = append(f.fileKeys, fileKey)
+	return nil
+}
+
+func (f *flakyPublisher) PublishVoiceConversion(_ context.Context, jobId, fileKey, modelKey string) error {
+	if f.down {
+		return errors.New("broker unavailable")
+	}
+	f.converted = append(f.converted, [3]string{jobId, fileKey, modelKey})
+	return nil
+}
+
+func (f *flakyPublisher) PublishCancellation(_ context.Context, jobId string) error {
+	switch {
+	case f.down:
+		return errors.New("broker unavailable")
+	case f.unroutable:
+		return &ReturnedError{Code: 312, Reason: "NO_ROUTE"}
+	}
+	f.cancelled = append(f.cancelled, jobId)
+	return nil
+}
+
+func (f *flakyPublisher) Close() error {
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	enc, err := encryptor.NewEncryptor("0123456780123456789abcdef9abcdef0123456780123456789abcdef9abcdef")
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	// issue returns the key handed out to user 7 for the S3 key
+	issue := func(objectKey, purpose string) string {
+		key, err := enc.EncryptFor(objectKey, encryptor.Binding{UserID: 7, Purpose: purpose})
+		if err != nil {
+			t.Fatalf("Failed to encrypt key: %v", err)
+		}
+		return key
+	}
+
+	// entries are of text jobs of user 7
+	entries := func(n int) []*pb.OutboxEntry {
+		var es []*pb.OutboxEntry
+		for i := range n {
+			id := string(rune('a' + i))
+			es = append(es, &pb.OutboxEntry{Id: "entry-" + id, Job: &pb.Job{Id: "job-" + id, UserId: 7, FileKey: issue("key-"+id, textPurpose)}})
+		}
+		return es
+	}
+
+	t.Run("publishes and acknowledges", func(t *testing.T) {
+		ob := &fakeOutbox{pending: entries(3)}
+		ps := &flakyPublisher{}
+		r := NewRelay(ob, ps, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if !slices.Equal(ps.published, []string{"job-a", "job-b", "job-c"}) {
+			t.Errorf("Expected every job to be published once, got %v", ps.published)
+		}
+
+		if len(ob.pending) != 0 {
+			t.Errorf("Expected no pending entries, got %d", len(ob.pending))
+		}
+	})
+
+	t.Run("publishes the S3 keys of texts", func(t *testing.T) {
+		ob := &fakeOutbox{pending: entries(2)}
+		ps := &flakyPublisher{}
+		r := NewRelay(ob, ps, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if !slices.Equal(ps.fileKeys, []string{"key-a", "key-b"}) {
+			t.Errorf("Expected the S3 keys of the texts to be published, got %v", ps.fileKeys)
+		}
+	})
+
+	t.Run("keeps entries whose key isn't the user's", func(t *testing.T) {
+		es := entries(1)
+		es[0].Job.UserId = 8
+		ob := &fakeOutbox{pending: es}
+		ps := &flakyPublisher{}
+		r := NewRelay(ob, ps, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if len(ps.published) != 0 || len(ob.pending) != 1 {
+			t.Errorf("Expected the entry to stay pending unpublished, got %v", ps.published)
+		}
+	})
+
+	t.Run("keeps entries while the broker is down", func(t *testing.T) {
+		ob := &fakeOutbox{pending: entries(2)}
+		ps := &flakyPublisher{down: true}
+		r := NewRelay(ob, ps, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if len(ob.acked) != 0 || len(ob.pending) != 2 {
+			t.Fatalf("Expected entries to stay pending, acked %v", ob.acked)
+		}
+
+		ps.down = false
+		r.drain(context.Background())
+
+		if !slices.Equal(ps.published, []string{"job-a", "job-b"}) || len(ob.pending) != 0 {
+			t.Errorf("Expected entries to be published once the broker is back, got %v", ps.published)
+		}
+	})
+
+	t.Run("drains more than a batch", func(t *testing.T) {
+		ob := &fakeOutbox{pending: entries(relayBatch + 5)}
+		ps := &flakyPublisher{}
+		r := NewRelay(ob, ps, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if len(ps.published) != relayBatch+5 {
+			t.Errorf("Expected %d publishes, got %d", relayBatch+5, len(ps.published))
+		}
+	})
+
+	t.Run("routes voice conversions to their worker", func(t *testing.T) {
+		es := entries(2)
+		es[1].Job.Kind = pb.Kind_VoiceConversion
+		es[1].Job.FileKey = issue("audio-b", conversionPurpose)
+		es[1].Job.ModelKey = issue("model-b", conversionPurpose)
+		ob := &fakeOutbox{pending: es}
+		ps := &flakyPublisher{}
+		r := NewRelay(ob, ps, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if !slices.Equal(ps.published, []string{"job-a"}) {
+			t.Errorf("Expected only the text job to be narrated, got %v", ps.published)
+		}
+
+		if !slices.Equal(ps.converted, [][3]string{{"job-b", "audio-b", "model-b"}}) {
+			t.Errorf("Expected the voice conversion to be published with the S3 keys of its audio and model, got %v", ps.converted)
+		}
+
+		if len(ob.pending) != 0 {
+			t.Errorf("Expected no pending entries, got %d", len(ob.pending))
+		}
+	})
+
+	t.Run("leaves voice conversions without a model to the bundled one", func(t *testing.T) {
+		es := entries(1)
+		es[0].Job.Kind = pb.Kind_VoiceConversion
+		es[0].Job.FileKey = issue("audio-a", conversionPurpose)
+		ps := &flakyPublisher{}
+		NewRelay(&fakeOutbox{pending: es}, ps, enc, 0).(*relay).drain(context.Background())
+
+		if !slices.Equal(ps.converted, [][3]string{{"job-a", "audio-a", ""}}) {
+			t.Errorf("Expected the voice conversion to be published without a model key, got %v", ps.converted)
+		}
+	})
+
+	t.Run("publishes cancellations", func(t *testing.T) {
+		es := entries(2)
+		es[1].Kind = pb.OutboxKind_OutboxCancellation
+		ob := &fakeOutbox{pending: es}
+		ps := &flakyPublisher{}
+		r := NewRelay(ob, ps, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if !slices.Equal(ps.published, []string{"job-a"}) || !slices.Equal(ps.cancelled, []string{"job-b"}) {
+			t.Errorf("Expected job-a converted and job-b cancelled, got %v and %v", ps.published, ps.cancelled)
+		}
+	})
+
+	t.Run("cancellations no worker listens to are done with", func(t *testing.T) {
+		es := entries(1)
+		es[0].Kind = pb.OutboxKind_OutboxCancellation
+		ob := &fakeOutbox{pending: es}
+		r := NewRelay(ob, &flakyPublisher{unroutable: true}, enc, 0).(*relay)
+
+		r.drain(context.Background())
+
+		if len(ob.pending) != 0 {
+			t.Errorf("Expected the cancellation to be acknowledged, got %d pending", len(ob.pending))
+		}
+	})
+
+	t.Run("nudge never blocks", func(t *testing.T) {
+		r := NewRelay(&fakeOutbox{}, &flakyPublisher{}, enc, 0)
+		r.Nudge()
+		r.Nudge()
+	})
+}
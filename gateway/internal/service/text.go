@@ -2,22 +2,31 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
 	"github.com/ziliscite/bard_narate/gateway/internal/domain"
 	"github.com/ziliscite/bard_narate/gateway/internal/repository"
 	"github.com/ziliscite/bard_narate/gateway/pkg/encryptor"
 	"io"
 )
 
+// filenameMeta is the metadata entry holding the filename the client uploaded.
+const filenameMeta = "filename"
+
+// textPurpose binds text keys so that they cannot be used as keys of another kind.
+const textPurpose = "text"
+
 type TextService interface {
 	// Save saves the file to the bucket and returns the key.
-	// The S3 key that is used to store the file is an unencrypted filename.
-	// The returned key is the encrypted filename.
-	Save(ctx context.Context, filename string, file io.Reader) (string, error)
+	// The S3 key that is used to store the file is built from a random per-upload ID,
+	// prefixed by the user ID when one is given. The original filename is kept in the object metadata.
+	// The returned key is the S3 key encrypted for the user, and only resolves for that user.
+	Save(ctx context.Context, userID uint64, filename string, file io.Reader) (string, error)
 
 	// Get retrieves the file from the bucket using the key.
-	// The key is the encrypted filename.
-	// Decrypt the key to get the original filename.
-	Get(ctx context.Context, key string) (*domain.File, error)
+	// The key is the S3 key encrypted for the user.
+	// Decrypt the key to get the S3 key.
+	Get(ctx context.Context, userID uint64, key string) (*domain.File, error)
 }
 
 type textService struct {
@@ -26,22 +35,25 @@ type textService struct {
 	fs     repository.SmallFileStore
 }
 
-func NewTextService(fs repository.SmallFileStore, textBucket string) TextService {
+func NewTextService(fs repository.SmallFileStore, enc *encryptor.Encryptor, textBucket string) TextService {
 	return &textService{
 		bucket: textBucket,
+		enc:    enc,
 		fs:     fs,
 	}
 }
 
-func (t *textService) Save(ctx context.Context, filename string, file io.Reader) (string, error) {
-	// encrypt the filename to get the key
-	key, err := t.enc.Encrypt(filename)
+func (t *textService) Save(ctx context.Context, userID uint64, filename string, file io.Reader) (string, error) {
+	objectKey := textKey(userID)
+
+	// encrypt the S3 key to get the key handed out to clients
+	key, err := t.enc.EncryptFor(objectKey, encryptor.Binding{UserID: userID, Purpose: textPurpose})
 	if err != nil {
 		return "", err
 	}
 
-	// create a new file with the filename
-	txt := domain.NewFile(filename, "text/plain", file)
+	txt := domain.NewFile(objectKey, "text/plain", file)
+	txt.SetMetadata(filenameMeta, filename)
 	if err = t.fs.Save(ctx, t.bucket, txt); err != nil {
 		return "", err
 	}
@@ -49,13 +61,23 @@ func (t *textService) Save(ctx context.Context, filename string, file io.Reader)
 	return key, nil
 }
 
-func (t *textService) Get(ctx context.Context, key string) (*domain.File, error) {
-	// decrypt the key to get the original filename
-	filename, err := t.enc.Decrypt(key)
+func (t *textService) Get(ctx context.Context, userID uint64, key string) (*domain.File, error) {
+	// decrypt the key to get the S3 key
+	objectKey, err := t.enc.DecryptFor(key, encryptor.Binding{UserID: userID, Purpose: textPurpose})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
 	}
 
 	// read the file from the bucket
-	return t.fs.Read(ctx, t.bucket, string(filename))
+	return t.fs.Read(ctx, t.bucket, string(objectKey))
+}
+
+// textKey returns a fresh S3 key for an uploaded text.
+// Keys never derive from client input, so uploads cannot overwrite each other.
+func textKey(userID uint64) string {
+	if userID == 0 {
+		return rand.Text() + ".txt"
+	}
+
+	return fmt.Sprintf("%d/%s.txt", userID, rand.Text())
 }
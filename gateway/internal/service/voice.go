@@ -0,0 +1,186 @@
+package service
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+// defaultCatalog lists the voices Kokoro ships with.
+//
+//go:embed voices.json
+var defaultCatalog []byte
+
+// VoiceCatalog is what jobs may be narrated with.
+type VoiceCatalog interface {
+	// Languages returns the languages of the catalog.
+	Languages() []domain.Language
+	// Voices returns the voices of the catalog, only those speaking the given language if any.
+	Voices(language string) []domain.Voice
+	// Speeds returns the slowest and fastest speeds jobs may be narrated at.
+	Speeds() (min, max float64)
+	// Resolve validates the voice settings of a submission, filling in what it left out.
+	// A missing voice is the default voice, or the first voice speaking the language if the default doesn't,
+	// a missing language the one of the voice, and a zero speed the natural pace of the voice.
+	// Languages may be given by their code or any of their tags.
+	// It only fails with ErrUnknownVoice, ErrUnknownLanguage, ErrVoiceLanguage or ErrInvalidSpeed.
+	Resolve(voice string, speed float64, language string) (domain.VoiceSettings, error)
+}
+
+// catalogFile is the JSON layout of a voice catalog.
+type catalogFile struct {
+	DefaultVoice string            `json:"default_voice"`
+	MinSpeed     float64           `json:"min_speed"`
+	MaxSpeed     float64           `json:"max_speed"`
+	Languages    []domain.Language `json:"languages"`
+	Voices       []domain.Voice    `json:"voices"`
+}
+
+type voiceCatalog struct {
+	catalogFile
+	voices    map[string]domain.Voice
+	languages map[string]string // code or lowercase tag to code
+}
+
+// LoadVoiceCatalog reads a voice catalog from a JSON file, or returns the catalog of Kokoro's voices if path is empty.
+func LoadVoiceCatalog(path string) (VoiceCatalog, error) {
+	data := defaultCatalog
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read voice catalog: %w", err)
+		}
+	}
+
+	var cf catalogFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("failed to parse voice catalog: %w", err)
+	}
+
+	return NewVoiceCatalog(cf.Languages, cf.Voices, cf.DefaultVoice, cf.MinSpeed, cf.MaxSpeed)
+}
+
+// NewVoiceCatalog checks that every voice speaks a language of the catalog, and that the default voice is one of them.
+func NewVoiceCatalog(languages []domain.Language, voices []domain.Voice, defaultVoice string, minSpeed, maxSpeed float64) (VoiceCatalog, error) {
+	vc := &voiceCatalog{
+		catalogFile: catalogFile{
+			DefaultVoice: defaultVoice,
+			MinSpeed:     minSpeed,
+			MaxSpeed:     maxSpeed,
+			Languages:    languages,
+			Voices:       voices,
+		},
+		voices:    make(map[string]domain.Voice, len(voices)),
+		languages: make(map[string]string, len(languages)),
+	}
+
+	if minSpeed <= 0 || maxSpeed < minSpeed || minSpeed > 1 || maxSpeed < 1 {
+		return nil, fmt.Errorf("invalid voice catalog speed range [%g, %g]", minSpeed, maxSpeed)
+	}
+
+	for _, l := range languages {
+		vc.languages[l.Code] = l.Code
+		for _, tag := range l.Tags {
+			vc.languages[strings.ToLower(tag)] = l.Code
+		}
+	}
+
+	for _, v := range voices {
+		if _, ok := vc.languages[v.Language]; !ok {
+			return nil, fmt.Errorf("voice %q speaks unknown language %q", v.ID, v.Language)
+		}
+		vc.voices[v.ID] = v
+	}
+
+	if _, ok := vc.voices[defaultVoice]; !ok {
+		return nil, fmt.Errorf("default voice %q is not in the catalog", defaultVoice)
+	}
+
+	return vc, nil
+}
+
+func (vc *voiceCatalog) Languages() []domain.Language {
+	return vc.catalogFile.Languages
+}
+
+func (vc *voiceCatalog) Voices(language string) []domain.Voice {
+	if language == "" {
+		return vc.catalogFile.Voices
+	}
+
+	code, ok := vc.language(language)
+	if !ok {
+		return nil
+	}
+
+	var voices []domain.Voice
+	for _, v := range vc.catalogFile.Voices {
+		if v.Language == code {
+			voices = append(voices, v)
+		}
+	}
+
+	return voices
+}
+
+func (vc *voiceCatalog) Speeds() (float64, float64) {
+	return vc.MinSpeed, vc.MaxSpeed
+}
+
+func (vc *voiceCatalog) Resolve(voice string, speed float64, language string) (domain.VoiceSettings, error) {
+	settings := domain.VoiceSettings{Voice: voice, Speed: speed}
+
+	if language != "" {
+		code, ok := vc.language(language)
+		if !ok {
+			return domain.VoiceSettings{}, fmt.Errorf("%w: %q", ErrUnknownLanguage, language)
+		}
+		settings.Language = code
+	}
+
+	switch {
+	case voice != "":
+		v, ok := vc.voices[voice]
+		if !ok {
+			return domain.VoiceSettings{}, fmt.Errorf("%w: %q", ErrUnknownVoice, voice)
+		}
+
+		if settings.Language == "" {
+			settings.Language = v.Language
+		} else if settings.Language != v.Language {
+			return domain.VoiceSettings{}, fmt.Errorf("%w: %q doesn't speak %q", ErrVoiceLanguage, voice, language)
+		}
+	case settings.Language == "" || vc.voices[vc.DefaultVoice].Language == settings.Language:
+		settings.Voice = vc.DefaultVoice
+		settings.Language = vc.voices[vc.DefaultVoice].Language
+	default:
+		voices := vc.Voices(settings.Language)
+		if len(voices) == 0 {
+			return domain.VoiceSettings{}, fmt.Errorf("%w: no voice speaks %q", ErrVoiceLanguage, language)
+		}
+		settings.Voice = voices[0].ID
+	}
+
+	switch {
+	case speed == 0:
+		settings.Speed = 1
+	case !(speed >= vc.MinSpeed && speed <= vc.MaxSpeed): // NaN included
+		return domain.VoiceSettings{}, fmt.Errorf("%w: must be between %g and %g", ErrInvalidSpeed, vc.MinSpeed, vc.MaxSpeed)
+	}
+
+	return settings, nil
+}
+
+// language returns the code of a language given by its code or one of its tags.
+func (vc *voiceCatalog) language(language string) (string, bool) {
+	if code, ok := vc.languages[language]; ok {
+		return code, true
+	}
+
+	code, ok := vc.languages[strings.ToLower(language)]
+	return code, ok
+}
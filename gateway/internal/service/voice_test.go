@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/gateway/internal/domain"
+)
+
+func TestVoiceCatalog(t *testing.T) {
+	vc, err := LoadVoiceCatalog("")
+	if err != nil {
+		t.Fatalf("Failed to load the default catalog: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		voice    string
+		speed    float64
+		language string
+		want     domain.VoiceSettings
+		err      error
+	}{
+		{name: "defaults", want: domain.VoiceSettings{Voice: "af_bella", Speed: 1, Language: "a"}},
+		{name: "language of the voice", voice: "bm_george", speed: 1.5, want: domain.VoiceSettings{Voice: "bm_george", Speed: 1.5, Language: "b"}},
+		{name: "voice of the language", language: "ja", want: domain.VoiceSettings{Voice: "jf_alpha", Speed: 1, Language: "j"}},
+		{name: "language tag", voice: "ff_siwis", language: "FR", want: domain.VoiceSettings{Voice: "ff_siwis", Speed: 1, Language: "f"}},
+		{name: "default voice speaks the language", language: "en-US", want: domain.VoiceSettings{Voice: "af_bella", Speed: 1, Language: "a"}},
+		{name: "unknown voice", voice: "af_nobody", err: ErrUnknownVoice},
+		{name: "unknown language", language: "xx", err: ErrUnknownLanguage},
+		{name: "voice doesn't speak the language", voice: "af_bella", language: "b", err: ErrVoiceLanguage},
+		{name: "too slow", speed: 0.1, err: ErrInvalidSpeed},
+		{name: "too fast", speed: 3, err: ErrInvalidSpeed},
+		{name: "not a number", speed: math.NaN(), err: ErrInvalidSpeed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := vc.Resolve(tt.voice, tt.speed, tt.language)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewVoiceCatalog(t *testing.T) {
+	languages := []domain.Language{{Code: "a", Name: "American English"}}
+	voices := []domain.Voice{{ID: "af_bella", Language: "a"}}
+
+	if _, err := NewVoiceCatalog(languages, voices, "af_bella", 0.5, 2); err != nil {
+		t.Errorf("Expected a valid catalog, got %v", err)
+	}
+
+	if _, err := NewVoiceCatalog(languages, voices, "af_sky", 0.5, 2); err == nil {
+		t.Error("Expected a missing default voice to be refused")
+	}
+
+	if _, err := NewVoiceCatalog(languages, append(voices, domain.Voice{ID: "bf_emma", Language: "b"}), "af_bella", 0.5, 2); err == nil {
+		t.Error("Expected a voice of an unknown language to be refused")
+	}
+
+	if _, err := NewVoiceCatalog(languages, voices, "af_bella", 1.5, 2); err == nil {
+		t.Error("Expected a speed range without the natural pace to be refused")
+	}
+}
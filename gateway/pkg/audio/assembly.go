@@ -0,0 +1,258 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Segment is audio an assembly is made of. It is opened once when the assembly is planned,
+// to read its header, and once more when the assembly is written.
+type Segment struct {
+	open func() (io.ReadCloser, error)
+	// raw segments are size bytes of PCM samples in the format of the assembly, rather than WAV files.
+	raw  bool
+	size int64
+}
+
+// WAV returns a segment of the WAV file opened by open.
+func WAV(open func() (io.ReadCloser, error)) Segment {
+	return Segment{open: open}
+}
+
+// PCM returns a segment of size bytes of raw samples in the format of the assembly, opened by open.
+func PCM(open func() (io.ReadCloser, error), size int64) Segment {
+	return Segment{open: open, raw: true, size: size}
+}
+
+// Chapter is a titled segment. The paragraphs of a chapter are already assembled into its audio,
+// with the pauses between them, by the worker that narrated it.
+type Chapter struct {
+	Title string
+	Audio Segment
+}
+
+// Options set the format of an assembly and the pauses between its segments.
+type Options struct {
+	// Format is the format of the assembly, which every segment has to share.
+	// When it is left out, that of the first WAV segment is taken.
+	Format Format
+	// ChapterPause is the silence between a chapter and the next one.
+	ChapterPause time.Duration
+}
+
+// Marker tells when a chapter plays in an assembly, from its first sample up to the pause after it.
+type Marker struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Manifest lists the chapters of an assembly along with when they play.
+type Manifest struct {
+	Duration time.Duration
+	Chapters []Marker
+}
+
+// part is a segment or a pause of an assembly, of size bytes of samples.
+type part struct {
+	seg     Segment
+	size    int64
+	silence bool
+	// chapter locates the segment in errors
+	chapter int
+}
+
+// Assembly is a WAV file assembled from segments, with silences between them. Segments are streamed one at a time
+// as it is written, so that books of any length are assembled without holding more than a buffer of their audio.
+type Assembly struct {
+	format   Format
+	parts    []part
+	size     int64
+	manifest Manifest
+}
+
+// Plan reads the header of every WAV segment of the chapters for its format and length, and lays out an assembly
+// of the chapters in order, with the chapter pause of the options between them.
+// It returns ErrFormatMismatch if the segments differ in format, as no resampling is done.
+func Plan(chapters []Chapter, opts Options) (*Assembly, error) {
+	parts := make([]part, 0, len(chapters))
+	for c, chapter := range chapters {
+		seg := chapter.Audio
+		pt := part{seg: seg, size: seg.size, chapter: c}
+		if !seg.raw {
+			f, size, err := probe(seg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to probe chapter %d: %w", c, err)
+			}
+
+			if opts.Format == (Format{}) {
+				opts.Format = f
+			}
+			if f != opts.Format {
+				return nil, fmt.Errorf("%w: chapter %d is %+v rather than %+v", ErrFormatMismatch, c, f, opts.Format)
+			}
+			pt.size = size
+		}
+
+		parts = append(parts, pt)
+	}
+
+	if !opts.Format.valid() {
+		return nil, ErrUnknownFormat
+	}
+
+	a := &Assembly{format: opts.Format}
+	for c, chapter := range chapters {
+		pt := parts[c]
+		if pt.seg.raw && (pt.size < 0 || pt.size%a.format.frameSize() != 0) {
+			return nil, fmt.Errorf("%w: chapter %d isn't made of whole frames", ErrUnsupportedFormat, c)
+		}
+
+		if c > 0 {
+			a.pause(opts.ChapterPause)
+		}
+
+		marker := Marker{Title: chapter.Title, Start: a.format.Duration(a.size)}
+		a.parts = append(a.parts, pt)
+		a.size += pt.size
+		marker.End = a.format.Duration(a.size)
+
+		a.manifest.Chapters = append(a.manifest.Chapters, marker)
+	}
+
+	a.manifest.Duration = a.format.Duration(a.size)
+	if a.size+a.size%2 > maxDataSize {
+		return nil, ErrTooLong
+	}
+
+	return a, nil
+}
+
+// pause appends the silence playing for d.
+func (a *Assembly) pause(d time.Duration) {
+	if size := a.format.Size(d); size > 0 {
+		a.parts = append(a.parts, part{size: size, silence: true})
+		a.size += size
+	}
+}
+
+// probe reads the header of a WAV segment.
+func probe(seg Segment) (Format, int64, error) {
+	r, err := seg.open()
+	if err != nil {
+		return Format{}, 0, err
+	}
+	defer r.Close()
+
+	return ReadHeader(r)
+}
+
+// Format returns the format of the samples of the assembly.
+func (a *Assembly) Format() Format {
+	return a.format
+}
+
+// Manifest returns when the chapters of the assembly play.
+func (a *Assembly) Manifest() Manifest {
+	return a.manifest
+}
+
+// Size returns the size in bytes of the WAV file the assembly is written as.
+func (a *Assembly) Size() int64 {
+	return headerSize + a.size + a.size%2
+}
+
+// WriteTo writes the assembly as a WAV file, opening its segments one after the other.
+// It returns ErrSegmentChanged if a segment no longer has the format or length it had when the assembly was planned.
+func (a *Assembly) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	if err := WriteHeader(cw, a.format, a.size); err != nil {
+		return cw.n, err
+	}
+
+	for _, pt := range a.parts {
+		if pt.silence {
+			if err := writeSilence(cw, a.format, pt.size); err != nil {
+				return cw.n, err
+			}
+			continue
+		}
+
+		if err := a.copySegment(cw, pt); err != nil {
+			return cw.n, fmt.Errorf("failed to write chapter %d: %w", pt.chapter, err)
+		}
+	}
+
+	if a.size%2 == 1 {
+		if _, err := cw.Write([]byte{0}); err != nil {
+			return cw.n, err
+		}
+	}
+
+	return cw.n, nil
+}
+
+// copySegment copies the samples of a segment.
+func (a *Assembly) copySegment(w io.Writer, pt part) error {
+	r, err := pt.seg.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if !pt.seg.raw {
+		f, size, err := ReadHeader(r)
+		if err != nil {
+			return err
+		}
+
+		if f != a.format || size != pt.size {
+			return ErrSegmentChanged
+		}
+	}
+
+	if _, err = io.CopyN(w, r, pt.size); err != nil {
+		if errors.Is(err, io.EOF) {
+			return ErrSegmentChanged
+		}
+		return err
+	}
+
+	return nil
+}
+
+// silenceBuffer is the size of the buffer silences are written from.
+const silenceBuffer = 32 << 10
+
+// writeSilence writes size bytes of silent samples.
+func writeSilence(w io.Writer, f Format, size int64) error {
+	buf := make([]byte, min(size, silenceBuffer))
+	if s := f.silence(); s != 0 {
+		for i := range buf {
+			buf[i] = s
+		}
+	}
+
+	for size > 0 {
+		n, err := w.Write(buf[:min(size, int64(len(buf)))])
+		if err != nil {
+			return err
+		}
+		size -= int64(n)
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,172 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// opener counts the segments open at once.
+type opener struct {
+	open, most int
+}
+
+// segment returns a segment reading the file, tracked by the opener.
+func (o *opener) segment(file []byte) Segment {
+	return WAV(o.reader(file))
+}
+
+func (o *opener) reader(file []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		o.open++
+		o.most = max(o.most, o.open)
+		return &trackedReader{Reader: bytes.NewReader(file), o: o}, nil
+	}
+}
+
+type trackedReader struct {
+	io.Reader
+	o *opener
+}
+
+func (r *trackedReader) Close() error {
+	r.o.open--
+	return nil
+}
+
+func TestAssembly(t *testing.T) {
+	a1 := sine(mono16, 440, time.Second)
+	a2 := sine(mono16, 660, 500*time.Millisecond)
+	b1 := sine(mono16, 880, 250*time.Millisecond)
+
+	o := &opener{}
+	chapters := []Chapter{
+		{Title: "One", Audio: o.segment(wav(t, mono16, a1))},
+		{Title: "Two", Audio: o.segment(wav(t, mono16, a2))},
+		{Title: "Three", Audio: o.segment(wav(t, mono16, b1))},
+	}
+
+	asm, err := Plan(chapters, Options{ChapterPause: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+
+	t.Run("manifest", func(t *testing.T) {
+		expected := Manifest{
+			Duration: 1950 * time.Millisecond,
+			Chapters: []Marker{
+				{Title: "One", Start: 0, End: time.Second},
+				{Title: "Two", Start: 1100 * time.Millisecond, End: 1600 * time.Millisecond},
+				{Title: "Three", Start: 1700 * time.Millisecond, End: 1950 * time.Millisecond},
+			},
+		}
+
+		manifest := asm.Manifest()
+		if manifest.Duration != expected.Duration || !slices.Equal(manifest.Chapters, expected.Chapters) {
+			t.Errorf("Expected %+v, got %+v", expected, manifest)
+		}
+	})
+
+	var out bytes.Buffer
+	n, err := asm.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	t.Run("size", func(t *testing.T) {
+		if n != asm.Size() || int64(out.Len()) != asm.Size() {
+			t.Errorf("Expected %d bytes, wrote %d of which %d reported", asm.Size(), out.Len(), n)
+		}
+	})
+
+	t.Run("samples", func(t *testing.T) {
+		f, size, err := ReadHeader(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to read header: %v", err)
+		}
+
+		if f != mono16 || size != int64(out.Len()-headerSize) {
+			t.Errorf("Expected %+v with %d bytes of samples, got %+v with %d", mono16, out.Len()-headerSize, f, size)
+		}
+
+		var expected []byte
+		expected = append(expected, a1...)
+		expected = append(expected, make([]byte, mono16.Size(100*time.Millisecond))...)
+		expected = append(expected, a2...)
+		expected = append(expected, make([]byte, mono16.Size(100*time.Millisecond))...)
+		expected = append(expected, b1...)
+
+		if !bytes.Equal(out.Bytes()[headerSize:], expected) {
+			t.Errorf("Expected the segments in order with silences between them")
+		}
+	})
+
+	t.Run("streams one segment at a time", func(t *testing.T) {
+		if o.open != 0 || o.most != 1 {
+			t.Errorf("Expected every segment to be closed and at most one open at once, got %d open and %d at most", o.open, o.most)
+		}
+	})
+}
+
+func TestPlan(t *testing.T) {
+	t.Run("raw samples", func(t *testing.T) {
+		o := &opener{}
+		samples := sine(mono16, 440, 200*time.Millisecond)
+
+		asm, err := Plan([]Chapter{
+			{Audio: PCM(o.reader(samples), int64(len(samples)))},
+			{Audio: o.segment(wav(t, mono16, samples))},
+		}, Options{Format: mono16, ChapterPause: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+
+		if d := asm.Manifest().Duration; d != 450*time.Millisecond {
+			t.Errorf("Expected 450ms, got %v", d)
+		}
+	})
+
+	t.Run("raw samples without a format", func(t *testing.T) {
+		o := &opener{}
+		if _, err := Plan([]Chapter{{Audio: PCM(o.reader(nil), 0)}}, Options{}); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("Expected %v, got %v", ErrUnknownFormat, err)
+		}
+	})
+
+	t.Run("formats differ", func(t *testing.T) {
+		o := &opener{}
+		stereo := Format{SampleRate: 8000, Channels: 2, BitsPerSample: 16}
+
+		_, err := Plan([]Chapter{
+			{Audio: o.segment(wav(t, mono16, sine(mono16, 440, 100*time.Millisecond)))},
+			{Audio: o.segment(wav(t, stereo, sine(stereo, 440, 100*time.Millisecond)))},
+		}, Options{})
+		if !errors.Is(err, ErrFormatMismatch) {
+			t.Errorf("Expected %v, got %v", ErrFormatMismatch, err)
+		}
+	})
+
+	t.Run("segment changed before it was written", func(t *testing.T) {
+		file := wav(t, mono16, sine(mono16, 440, 100*time.Millisecond))
+		opened := 0
+		seg := WAV(func() (io.ReadCloser, error) {
+			opened++
+			if opened > 1 {
+				return io.NopCloser(bytes.NewReader(file[:len(file)/2])), nil
+			}
+			return io.NopCloser(bytes.NewReader(file)), nil
+		})
+
+		asm, err := Plan([]Chapter{{Audio: seg}}, Options{})
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+
+		if _, err = asm.WriteTo(io.Discard); !errors.Is(err, ErrSegmentChanged) {
+			t.Errorf("Expected %v, got %v", ErrSegmentChanged, err)
+		}
+	})
+}
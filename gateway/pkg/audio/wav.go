@@ -0,0 +1,189 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// headerSize is the size of the header WriteHeader writes, up to the first sample.
+	headerSize = 44
+	// maxDataSize is the most bytes of samples a WAV file can hold.
+	maxDataSize = math.MaxUint32 - (headerSize - 8)
+)
+
+const (
+	formatPCM        = 1
+	formatExtensible = 0xFFFE
+)
+
+var (
+	ErrNotWAV            = errors.New("not a WAV file")
+	ErrUnsupportedFormat = errors.New("unsupported WAV format")
+	ErrFormatMismatch    = errors.New("segments differ in format")
+	ErrUnknownFormat     = errors.New("format of raw PCM segments is unknown")
+	ErrTooLong           = errors.New("audio is too long for a WAV file")
+	ErrSegmentChanged    = errors.New("segment changed since it was probed")
+)
+
+// Format describes linear PCM samples, as stored in WAV files.
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+// frameSize returns the size in bytes of a sample of every channel.
+func (f Format) frameSize() int64 {
+	return int64(f.Channels * f.BitsPerSample / 8)
+}
+
+// Duration returns how long n bytes of samples play for.
+func (f Format) Duration(n int64) time.Duration {
+	frames := n / f.frameSize()
+	return time.Duration(frames * int64(time.Second) / int64(f.SampleRate))
+}
+
+// Size returns the size in bytes of the samples playing for d, rounded to the nearest whole frame.
+func (f Format) Size(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+
+	frames := (int64(d)*int64(f.SampleRate) + int64(time.Second)/2) / int64(time.Second)
+	return frames * f.frameSize()
+}
+
+// silence returns the value of the bytes of a silent sample, unsigned 8 bit samples centering on 128.
+func (f Format) silence() byte {
+	if f.BitsPerSample == 8 {
+		return 0x80
+	}
+	return 0
+}
+
+func (f Format) valid() bool {
+	switch f.BitsPerSample {
+	case 8, 16, 24, 32:
+	default:
+		return false
+	}
+
+	return f.SampleRate > 0 && f.Channels > 0 && f.frameSize() <= math.MaxUint16
+}
+
+// ReadHeader reads the header of a WAV file up to its first sample, returning the format of the samples
+// and their size in bytes, rounded down to whole frames. Chunks other than the format and the samples are skipped.
+// Only integer PCM samples are supported.
+func ReadHeader(r io.Reader) (Format, int64, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return Format{}, 0, fmt.Errorf("%w: %w", ErrNotWAV, err)
+	}
+
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return Format{}, 0, ErrNotWAV
+	}
+
+	var format Format
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return Format{}, 0, fmt.Errorf("%w: no samples: %w", ErrNotWAV, err)
+		}
+
+		id, size := string(chunk[0:4]), int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch id {
+		case "fmt ":
+			f, err := readFormat(r, size)
+			if err != nil {
+				return Format{}, 0, err
+			}
+			format = f
+		case "data":
+			if !format.valid() {
+				return Format{}, 0, fmt.Errorf("%w: samples before their format", ErrNotWAV)
+			}
+
+			// files streamed before their length was known leave it at its maximum
+			if size == math.MaxUint32 {
+				return Format{}, 0, fmt.Errorf("%w: unknown length", ErrUnsupportedFormat)
+			}
+
+			return format, size - size%format.frameSize(), nil
+		default:
+			// chunks are padded to an even size
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return Format{}, 0, fmt.Errorf("%w: %w", ErrNotWAV, err)
+			}
+		}
+	}
+}
+
+// readFormat reads a format chunk of the given size.
+func readFormat(r io.Reader, size int64) (Format, error) {
+	// the longest format chunk is that of WAVE_FORMAT_EXTENSIBLE
+	if size < 16 || size > 40 {
+		return Format{}, fmt.Errorf("%w: format chunk of %d bytes", ErrNotWAV, size)
+	}
+
+	buf := make([]byte, size+size%2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Format{}, fmt.Errorf("%w: %w", ErrNotWAV, err)
+	}
+
+	tag := binary.LittleEndian.Uint16(buf[0:2])
+	if tag == formatExtensible && size >= 26 {
+		// the sub format GUID starts with the format tag it extends
+		tag = binary.LittleEndian.Uint16(buf[24:26])
+	}
+
+	f := Format{
+		Channels:      int(binary.LittleEndian.Uint16(buf[2:4])),
+		SampleRate:    int(binary.LittleEndian.Uint32(buf[4:8])),
+		BitsPerSample: int(binary.LittleEndian.Uint16(buf[14:16])),
+	}
+
+	blockAlign := int64(binary.LittleEndian.Uint16(buf[12:14]))
+	if tag != formatPCM || !f.valid() || blockAlign != f.frameSize() {
+		return Format{}, fmt.Errorf("%w: tag %#x, %d channels of %d bits at %d Hz", ErrUnsupportedFormat, tag, f.Channels, f.BitsPerSample, f.SampleRate)
+	}
+
+	return f, nil
+}
+
+// WriteHeader writes the header of a WAV file holding size bytes of samples in the format,
+// the samples being written after it. An odd size is to be followed by a padding byte.
+func WriteHeader(w io.Writer, f Format, size int64) error {
+	if !f.valid() {
+		return fmt.Errorf("%w: %d channels of %d bits at %d Hz", ErrUnsupportedFormat, f.Channels, f.BitsPerSample, f.SampleRate)
+	}
+
+	if size < 0 || size+size%2 > maxDataSize {
+		return ErrTooLong
+	}
+
+	var h [headerSize]byte
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], uint32(headerSize-8+size+size%2))
+	copy(h[8:12], "WAVE")
+
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], formatPCM)
+	binary.LittleEndian.PutUint16(h[22:24], uint16(f.Channels))
+	binary.LittleEndian.PutUint32(h[24:28], uint32(f.SampleRate))
+	binary.LittleEndian.PutUint32(h[28:32], uint32(int64(f.SampleRate)*f.frameSize()))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(f.frameSize()))
+	binary.LittleEndian.PutUint16(h[34:36], uint16(f.BitsPerSample))
+
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], uint32(size))
+
+	_, err := w.Write(h[:])
+	return err
+}
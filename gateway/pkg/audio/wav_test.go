@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var mono16 = Format{SampleRate: 8000, Channels: 1, BitsPerSample: 16}
+
+// sine returns d of a sine wave at freq Hz as 16 bit samples, on every channel of the format.
+func sine(f Format, freq float64, d time.Duration) []byte {
+	frames := int(f.Size(d) / f.frameSize())
+
+	var buf bytes.Buffer
+	for i := range frames {
+		v := int16(math.Sin(2*math.Pi*freq*float64(i)/float64(f.SampleRate)) * math.MaxInt16 / 2)
+		for range f.Channels {
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// wav returns a WAV file of the samples.
+func wav(t *testing.T, f Format, samples []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, f, int64(len(samples))); err != nil {
+		t.Fatalf("Failed to write header: %v", err)
+	}
+	buf.Write(samples)
+
+	return buf.Bytes()
+}
+
+func TestReadHeader(t *testing.T) {
+	samples := sine(mono16, 440, 250*time.Millisecond)
+
+	t.Run("written header", func(t *testing.T) {
+		f, size, err := ReadHeader(bytes.NewReader(wav(t, mono16, samples)))
+		if err != nil {
+			t.Fatalf("Failed to read header: %v", err)
+		}
+
+		if f != mono16 || size != int64(len(samples)) {
+			t.Errorf("Expected %+v with %d bytes of samples, got %+v with %d", mono16, len(samples), f, size)
+		}
+	})
+
+	t.Run("skips other chunks", func(t *testing.T) {
+		file := wav(t, mono16, samples)
+
+		// a LIST chunk of odd size, padded, between the format and the samples
+		var list bytes.Buffer
+		list.WriteString("LIST")
+		binary.Write(&list, binary.LittleEndian, uint32(5))
+		list.WriteString("INFO\x00\x00")
+
+		withList := append(append(append([]byte{}, file[:36]...), list.Bytes()...), file[36:]...)
+		f, size, err := ReadHeader(bytes.NewReader(withList))
+		if err != nil {
+			t.Fatalf("Failed to read header: %v", err)
+		}
+
+		if f != mono16 || size != int64(len(samples)) {
+			t.Errorf("Expected %+v with %d bytes of samples, got %+v with %d", mono16, len(samples), f, size)
+		}
+	})
+
+	t.Run("rejects other files", func(t *testing.T) {
+		if _, _, err := ReadHeader(bytes.NewReader([]byte("ID3\x04 not a wav file at all"))); !errors.Is(err, ErrNotWAV) {
+			t.Errorf("Expected %v, got %v", ErrNotWAV, err)
+		}
+	})
+
+	t.Run("rejects float samples", func(t *testing.T) {
+		file := wav(t, Format{SampleRate: 8000, Channels: 1, BitsPerSample: 32}, samples)
+		binary.LittleEndian.PutUint16(file[20:22], 3)
+
+		if _, _, err := ReadHeader(bytes.NewReader(file)); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Expected %v, got %v", ErrUnsupportedFormat, err)
+		}
+	})
+}
+
+func TestFormat(t *testing.T) {
+	stereo := Format{SampleRate: 24000, Channels: 2, BitsPerSample: 16}
+
+	if size := stereo.Size(time.Second); size != 96000 {
+		t.Errorf("Expected a second to take 96000 bytes, got %d", size)
+	}
+
+	if d := stereo.Duration(48000); d != 500*time.Millisecond {
+		t.Errorf("Expected 48000 bytes to play for 500ms, got %v", d)
+	}
+
+	// a third of a frame is rounded to none
+	if size := stereo.Size(time.Second / 72000); size != 0 {
+		t.Errorf("Expected less than half a frame to round to nothing, got %d bytes", size)
+	}
+}
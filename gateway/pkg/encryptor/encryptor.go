@@ -3,57 +3,177 @@ package encryptor
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 )
 
 var (
 	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	ErrUnknownKeyVersion = errors.New("unknown key version")
 )
 
-type Encryptor struct {
+// minKeyLength is the minimum length of a master key secret, in bytes.
+const minKeyLength = 32
+
+// HKDF info strings used to derive the subkeys of a master key.
+const (
+	encKeyInfo  = "bard_narate encryptor aes-256-gcm key"
+	hmacKeyInfo = "bard_narate encryptor nonce hmac key"
+)
+
+// Key is a versioned master key.
+// The version is written in front of every ciphertext so that Decrypt knows which key to use.
+type Key struct {
+	Version uint8
+	Secret  string
+}
+
+// Binding is the context a ciphertext is issued for, such as the user it belongs to
+// and what it is used for. It is authenticated as AEAD associated data, so a ciphertext
+// minted under one binding fails to decrypt under any other.
+type Binding struct {
+	UserID  uint64
+	Purpose string
+}
+
+// additionalData encodes the binding unambiguously as GCM additional data.
+func (b Binding) additionalData() []byte {
+	ad := make([]byte, 0, 4+len(b.Purpose)+8)
+	ad = binary.BigEndian.AppendUint32(ad, uint32(len(b.Purpose)))
+	ad = append(ad, b.Purpose...)
+	ad = binary.BigEndian.AppendUint64(ad, b.UserID)
+	return ad
+}
+
+type subkeys struct {
 	encKey  []byte
 	hmacKey []byte
 }
 
+// Encryptor encrypts with the active key of its key ring and decrypts with whichever key
+// the ciphertext names, so ciphertexts issued before a rotation keep working as long as
+// their key stays in the ring.
+type Encryptor struct {
+	active uint8
+	keys   map[uint8]subkeys
+}
+
+// NewEncryptor creates an encryptor with a single key of version 1.
 func NewEncryptor(key string) (*Encryptor, error) {
-	masterKey := []byte(key)
-	if len(masterKey) != 64 {
-		return nil, errors.New("invalid key length")
+	return NewKeyRing(Key{Version: 1, Secret: key})
+}
+
+// NewKeyRing creates an encryptor that encrypts with the active key
+// and can still decrypt ciphertexts issued with any of the previous keys.
+func NewKeyRing(active Key, previous ...Key) (*Encryptor, error) {
+	en := &Encryptor{
+		active: active.Version,
+		keys:   make(map[uint8]subkeys, len(previous)+1),
 	}
 
-	encKey := make([]byte, 32)
-	hmacKey := make([]byte, 32)
+	for _, key := range append([]Key{active}, previous...) {
+		if _, ok := en.keys[key.Version]; ok {
+			return nil, fmt.Errorf("duplicate key version %d", key.Version)
+		}
+
+		sk, err := deriveSubkeys(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", key.Version, err)
+		}
 
-	copy(encKey, masterKey[:32])
-	copy(hmacKey, masterKey[32:64])
+		en.keys[key.Version] = sk
+	}
 
-	return &Encryptor{encKey: encKey, hmacKey: hmacKey}, nil
+	return en, nil
 }
 
-func (en Encryptor) Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(en.encKey)
+// deriveSubkeys derives independent encryption and nonce keys from a master key secret with HKDF-SHA256.
+func deriveSubkeys(secret string) (subkeys, error) {
+	masterKey := []byte(secret)
+	if len(masterKey) < minKeyLength {
+		return subkeys{}, errors.New("invalid key length")
+	}
+
+	encKey, err := hkdf.Key(sha256.New, masterKey, nil, encKeyInfo, 32)
 	if err != nil {
-		return "", fmt.Errorf("cipher creation failed: %w", err)
+		return subkeys{}, fmt.Errorf("key derivation failed: %w", err)
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	hmacKey, err := hkdf.Key(sha256.New, masterKey, nil, hmacKeyInfo, 32)
 	if err != nil {
-		return "", fmt.Errorf("GCM creation failed: %w", err)
+		return subkeys{}, fmt.Errorf("key derivation failed: %w", err)
 	}
 
-	mac := hmac.New(sha256.New, en.hmacKey)
+	return subkeys{encKey: encKey, hmacKey: hmacKey}, nil
+}
+
+// Version returns the version of the key that encrypted the ciphertext.
+func Version(encrypted string) (uint8, error) {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return 0, fmt.Errorf("%w: base64 decode failed: %w", ErrInvalidCiphertext, err)
+	}
+
+	if len(ciphertext) == 0 {
+		return 0, fmt.Errorf("%w: cannot decrypt empty string", ErrInvalidCiphertext)
+	}
+
+	return ciphertext[0], nil
+}
+
+// Encrypt encrypts the plaintext with the active key.
+// The output is the URL-safe base64 encoding of the key version, the nonce and the sealed plaintext.
+func (en Encryptor) Encrypt(plaintext string) (string, error) {
+	return en.seal(plaintext, nil)
+}
+
+// EncryptFor encrypts the plaintext like Encrypt, binding the ciphertext to b.
+// It only decrypts through DecryptFor with the same binding.
+func (en Encryptor) EncryptFor(plaintext string, b Binding) (string, error) {
+	return en.seal(plaintext, b.additionalData())
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt with the key named by its version prefix.
+func (en Encryptor) Decrypt(encrypted string) ([]byte, error) {
+	return en.open(encrypted, nil)
+}
+
+// DecryptFor decrypts a ciphertext produced by EncryptFor.
+// It fails with ErrInvalidCiphertext when the ciphertext was issued under a different binding.
+func (en Encryptor) DecryptFor(encrypted string, b Binding) ([]byte, error) {
+	return en.open(encrypted, b.additionalData())
+}
+
+func (en Encryptor) seal(plaintext string, additionalData []byte) (string, error) {
+	sk := en.keys[en.active]
+
+	gcm, err := newGCM(sk.encKey)
+	if err != nil {
+		return "", err
+	}
+
+	// The nonce is derived from the additional data as well as the plaintext,
+	// so the same plaintext under two bindings never reuses a nonce.
+	mac := hmac.New(sha256.New, sk.hmacKey)
+	mac.Write(binary.BigEndian.AppendUint32(nil, uint32(len(additionalData))))
+	mac.Write(additionalData)
 	mac.Write([]byte(plaintext))
 	nonce := mac.Sum(nil)[:gcm.NonceSize()]
 
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	out := make([]byte, 0, 1+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, en.active)
+	out = append(out, nonce...)
+
+	ciphertext := gcm.Seal(out, nonce, []byte(plaintext), additionalData)
 	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
 }
 
-func (en Encryptor) Decrypt(encrypted string) ([]byte, error) {
+func (en Encryptor) open(encrypted string, additionalData []byte) ([]byte, error) {
 	if encrypted == "" {
 		return nil, fmt.Errorf("%w: cannot decrypt empty string", ErrInvalidCiphertext)
 	}
@@ -63,14 +183,19 @@ func (en Encryptor) Decrypt(encrypted string) ([]byte, error) {
 		return nil, fmt.Errorf("%w: base64 decode failed: %w", ErrInvalidCiphertext, err)
 	}
 
-	block, err := aes.NewCipher(en.encKey)
-	if err != nil {
-		return nil, fmt.Errorf("cipher creation failed: %w", err)
+	if len(ciphertext) == 0 {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrInvalidCiphertext)
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	version, ciphertext := ciphertext[0], ciphertext[1:]
+	sk, ok := en.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %w %d", ErrInvalidCiphertext, ErrUnknownKeyVersion, version)
+	}
+
+	gcm, err := newGCM(sk.encKey)
 	if err != nil {
-		return nil, fmt.Errorf("GCM creation failed: %w", err)
+		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
@@ -78,7 +203,7 @@ func (en Encryptor) Decrypt(encrypted string) ([]byte, error) {
 		return nil, fmt.Errorf("%w: ciphertext too short", ErrInvalidCiphertext)
 	}
 
-	plaintext, err := gcm.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+	plaintext, err := gcm.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], additionalData)
 	if err != nil {
 		switch {
 		case err.Error() == "cipher: message authentication failed":
@@ -90,3 +215,17 @@ func (en Encryptor) Decrypt(encrypted string) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher creation failed: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("GCM creation failed: %w", err)
+	}
+
+	return gcm, nil
+}
@@ -3,13 +3,14 @@ package encryptor
 import (
 	"crypto/aes"
 	"encoding/base64"
+	"errors"
 	"testing"
 )
 
 func TestEncryptor(t *testing.T) {
 	// Setup
 	validKey := "0123456780123456789abcdef9abcdef0123456780123456789abcdef9abcdef"
-	invalidKey := "0123456780123456789abcdef9abcdef0123456780123456789abcdef9abcdef"
+	invalidKey := "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
 	shortKey := "0123456789abcdef"
 	encryptor, err := NewEncryptor(validKey)
 	if err != nil {
@@ -17,19 +18,19 @@ func TestEncryptor(t *testing.T) {
 	}
 
 	t.Run("basic encryption/decryption", func(t *testing.T) {
-		//plaintext := "Hello, World!"
-		//encrypted, err := encryptor.Encrypt(plaintext)
-		//if err != nil {
-		//	t.Fatalf("Encryption failed: %v", err)
-		//}
+		plaintext := "Hello, World!"
+		encrypted, err := encryptor.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
 
-		decrypted, err := encryptor.Decrypt("3uAU36LlaahG_U6suxebJUDcOj4VQ9jMveSG60aiAIcfUifaGGKiYsufnIor2DW21e_28gkZiM4")
+		decrypted, err := encryptor.Decrypt(encrypted)
 		if err != nil {
 			t.Fatalf("Decryption failed: %v", err)
 		}
 
-		if string(decrypted) != "" {
-			t.Errorf("Expected %q, got %q", "", decrypted)
+		if string(decrypted) != plaintext {
+			t.Errorf("Expected %q, got %q", plaintext, decrypted)
 		}
 	})
 
@@ -192,3 +193,167 @@ func TestEncryptor(t *testing.T) {
 		}
 	})
 }
+
+func TestKeyRing(t *testing.T) {
+	oldKey := Key{Version: 1, Secret: "0123456780123456789abcdef9abcdef0123456780123456789abcdef9abcdef"}
+	newKey := Key{Version: 2, Secret: "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"}
+
+	before, err := NewKeyRing(oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+
+	after, err := NewKeyRing(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create rotated key ring: %v", err)
+	}
+
+	t.Run("old ciphertexts decrypt after rotation", func(t *testing.T) {
+		plaintext := "42/job-file-key.txt"
+		encrypted, err := before.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+
+		decrypted, err := after.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decryption after rotation failed: %v", err)
+		}
+
+		if string(decrypted) != plaintext {
+			t.Errorf("Expected %q, got %q", plaintext, decrypted)
+		}
+	})
+
+	t.Run("new ciphertexts use the active key", func(t *testing.T) {
+		encrypted, err := after.Encrypt("fresh")
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+
+		version, err := Version(encrypted)
+		if err != nil {
+			t.Fatalf("Version failed: %v", err)
+		}
+
+		if version != newKey.Version {
+			t.Errorf("Expected key version %d, got %d", newKey.Version, version)
+		}
+
+		if _, err = before.Decrypt(encrypted); !errors.Is(err, ErrUnknownKeyVersion) {
+			t.Errorf("Expected ErrUnknownKeyVersion from a ring without the new key, got %v", err)
+		}
+	})
+
+	t.Run("retired key", func(t *testing.T) {
+		encrypted, err := before.Encrypt("retired")
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+
+		retired, err := NewKeyRing(newKey)
+		if err != nil {
+			t.Fatalf("Failed to create key ring: %v", err)
+		}
+
+		if _, err = retired.Decrypt(encrypted); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Expected ErrInvalidCiphertext once the key is retired, got %v", err)
+		}
+	})
+
+	t.Run("same secret under a different version", func(t *testing.T) {
+		encrypted, err := before.Encrypt("relabelled")
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+
+		relabelled, err := NewKeyRing(Key{Version: 3, Secret: oldKey.Secret})
+		if err != nil {
+			t.Fatalf("Failed to create key ring: %v", err)
+		}
+
+		if _, err = relabelled.Decrypt(encrypted); err == nil {
+			t.Error("Expected error decrypting with a key ring that lacks the ciphertext's version")
+		}
+	})
+
+	t.Run("duplicate versions", func(t *testing.T) {
+		if _, err := NewKeyRing(oldKey, Key{Version: 1, Secret: newKey.Secret}); err == nil {
+			t.Error("Expected error for duplicate key versions")
+		}
+	})
+
+	t.Run("short previous key", func(t *testing.T) {
+		if _, err := NewKeyRing(newKey, Key{Version: 1, Secret: "0123456789abcdef"}); err == nil {
+			t.Error("Expected error for a short previous key")
+		}
+	})
+}
+
+func TestBinding(t *testing.T) {
+	encryptor, err := NewEncryptor("0123456780123456789abcdef9abcdef0123456780123456789abcdef9abcdef")
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	owner := Binding{UserID: 1, Purpose: "text"}
+	plaintext := "1/chapter1.txt"
+
+	encrypted, err := encryptor.EncryptFor(plaintext, owner)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	t.Run("same binding", func(t *testing.T) {
+		decrypted, err := encryptor.DecryptFor(encrypted, owner)
+		if err != nil {
+			t.Fatalf("Decryption failed: %v", err)
+		}
+
+		if string(decrypted) != plaintext {
+			t.Errorf("Expected %q, got %q", plaintext, decrypted)
+		}
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		_, err := encryptor.DecryptFor(encrypted, Binding{UserID: 2, Purpose: owner.Purpose})
+		if !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Expected ErrInvalidCiphertext for another user, got %v", err)
+		}
+	})
+
+	t.Run("other purpose", func(t *testing.T) {
+		_, err := encryptor.DecryptFor(encrypted, Binding{UserID: owner.UserID, Purpose: "audio"})
+		if !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Expected ErrInvalidCiphertext for another purpose, got %v", err)
+		}
+	})
+
+	t.Run("unbound", func(t *testing.T) {
+		if _, err := encryptor.Decrypt(encrypted); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Expected bound ciphertext to fail without binding, got %v", err)
+		}
+
+		unbound, err := encryptor.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+
+		if _, err = encryptor.DecryptFor(unbound, owner); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Expected unbound ciphertext to fail with binding, got %v", err)
+		}
+	})
+
+	t.Run("distinct nonces per binding", func(t *testing.T) {
+		other, err := encryptor.EncryptFor(plaintext, Binding{UserID: 2, Purpose: owner.Purpose})
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+
+		a, _ := base64.RawURLEncoding.DecodeString(encrypted)
+		b, _ := base64.RawURLEncoding.DecodeString(other)
+		if string(a[1:13]) == string(b[1:13]) {
+			t.Error("Same plaintext under different bindings must not reuse a nonce")
+		}
+	})
+}
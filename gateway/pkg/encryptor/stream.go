@@ -0,0 +1,405 @@
+package encryptor
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Streams are encrypted in fixed-size chunks, each sealed with AES-256-GCM under its own nonce,
+// so that arbitrarily large bodies can be encrypted and decrypted without holding them in memory.
+//
+// A stream is laid out as
+//
+//	header: version (1 byte) || nonce prefix (7 bytes)
+//	chunks: sealed chunk 0 || sealed chunk 1 || ... || sealed final chunk
+//
+// where every chunk but the last holds exactly ChunkSize bytes of plaintext. The nonce of chunk i is
+// nonce prefix || i (4 bytes, big endian) || final flag (1 byte), which makes reordered, dropped,
+// truncated or appended chunks fail authentication.
+const (
+	ChunkSize = 64 << 10 // 64 KiB
+
+	// DataKeySize is the size of the keys used to encrypt streams.
+	DataKeySize = 32
+
+	streamVersion    = 1
+	noncePrefixSize  = 7
+	streamHeaderSize = 1 + noncePrefixSize
+	chunkOverhead    = 16
+	sealedChunkSize  = ChunkSize + chunkOverhead
+	maxChunks        = 1<<32 - 1
+)
+
+var (
+	ErrTruncatedStream = errors.New("truncated stream")
+	ErrCorruptedStream = errors.New("corrupted stream")
+)
+
+// NewDataKey returns a random key for a single stream.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("data key generation failed: %w", err)
+	}
+
+	return key, nil
+}
+
+// StreamWriter encrypts everything written to it into the underlying writer.
+// Close must be called to flush the final chunk; it does not close the underlying writer.
+type StreamWriter struct {
+	dst    io.Writer
+	aead   cipher.AEAD
+	ad     []byte
+	nonce  [12]byte
+	buf    []byte
+	sealed []byte
+	chunk  uint32
+	err    error
+}
+
+// NewStreamWriter writes the stream header to dst and returns a writer that encrypts with the data key.
+// The additional data is authenticated with every chunk, binding the stream to its context.
+func NewStreamWriter(dst io.Writer, dataKey, additionalData []byte) (*StreamWriter, error) {
+	aead, err := newStreamGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &StreamWriter{
+		dst:    dst,
+		aead:   aead,
+		ad:     additionalData,
+		buf:    make([]byte, 0, ChunkSize),
+		sealed: make([]byte, 0, sealedChunkSize),
+	}
+
+	if _, err = rand.Read(w.nonce[:noncePrefixSize]); err != nil {
+		return nil, fmt.Errorf("nonce generation failed: %w", err)
+	}
+
+	header := append([]byte{streamVersion}, w.nonce[:noncePrefixSize]...)
+	if _, err = dst.Write(header); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// a full buffer is only flushed once more data arrives,
+		// as it could otherwise turn out to be the final chunk
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close seals and writes the final chunk.
+func (w *StreamWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.flush(true); err != nil {
+		return err
+	}
+
+	w.err = errors.New("write to closed stream")
+	return nil
+}
+
+func (w *StreamWriter) flush(final bool) error {
+	if w.chunk == maxChunks {
+		w.err = errors.New("stream too large")
+		return w.err
+	}
+
+	setChunkNonce(&w.nonce, w.chunk, final)
+	w.sealed = w.aead.Seal(w.sealed[:0], w.nonce[:], w.buf, w.ad)
+	if _, err := w.dst.Write(w.sealed); err != nil {
+		w.err = err
+		return err
+	}
+
+	w.buf = w.buf[:0]
+	w.chunk++
+	return nil
+}
+
+// StreamReader decrypts a stream written by StreamWriter.
+// It implements io.Seeker when the underlying reader does.
+type StreamReader struct {
+	src   io.Reader
+	aead  cipher.AEAD
+	ad    []byte
+	nonce [12]byte
+
+	sealed []byte
+	plain  []byte
+	buf    []byte
+	chunk  uint32
+	final  bool
+	err    error
+
+	offset int64 // plaintext offset of the next byte returned by Read
+	skip   int   // bytes to drop from the next chunk after a seek
+	size   int64 // plaintext size, -1 until known
+}
+
+// NewStreamReader reads the stream header from src and returns a reader that decrypts with the data key.
+// The additional data must match the one the stream was written with.
+func NewStreamReader(src io.Reader, dataKey, additionalData []byte) (*StreamReader, error) {
+	aead, err := newStreamGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, streamHeaderSize)
+	if _, err = io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %w", ErrTruncatedStream, err)
+	}
+
+	if header[0] != streamVersion {
+		return nil, fmt.Errorf("%w: unknown stream version %d", ErrCorruptedStream, header[0])
+	}
+
+	r := &StreamReader{
+		src:    src,
+		aead:   aead,
+		ad:     additionalData,
+		sealed: make([]byte, sealedChunkSize),
+		plain:  make([]byte, 0, ChunkSize),
+		size:   -1,
+	}
+	copy(r.nonce[:noncePrefixSize], header[1:])
+
+	return r, nil
+}
+
+func (r *StreamReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	for len(r.buf) == 0 {
+		if r.final {
+			return 0, io.EOF
+		}
+
+		if err := r.next(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+// next reads and opens the next chunk.
+func (r *StreamReader) next() error {
+	n, err := io.ReadFull(r.src, r.sealed)
+	switch {
+	case err == io.EOF:
+		return ErrTruncatedStream
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// a short chunk can only be the final one
+		if err = r.open(r.sealed[:n], true); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		// a full chunk is the final one only if the plaintext size is a multiple of ChunkSize
+		if err = r.open(r.sealed, false); err != nil {
+			if err = r.open(r.sealed, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.final {
+		// nothing may follow the final chunk
+		var b [1]byte
+		if n, _ := io.ReadFull(r.src, b[:]); n != 0 {
+			return fmt.Errorf("%w: trailing data after final chunk", ErrCorruptedStream)
+		}
+	}
+
+	if r.skip > 0 {
+		if r.skip > len(r.buf) {
+			return fmt.Errorf("%w: seek past end of stream", ErrTruncatedStream)
+		}
+		r.buf = r.buf[r.skip:]
+		r.skip = 0
+	}
+
+	return nil
+}
+
+func (r *StreamReader) open(sealed []byte, final bool) error {
+	if len(sealed) < chunkOverhead {
+		return ErrTruncatedStream
+	}
+
+	setChunkNonce(&r.nonce, r.chunk, final)
+	// not opened in place, a failed attempt would clear the chunk before it is retried as the final one
+	plaintext, err := r.aead.Open(r.plain[:0], r.nonce[:], sealed, r.ad)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d failed authentication", ErrCorruptedStream, r.chunk)
+	}
+
+	r.buf = plaintext
+	r.final = final
+	r.chunk++
+	return nil
+}
+
+// Seek sets the plaintext offset of the next Read.
+// It returns an error if the underlying reader is not an io.Seeker.
+func (r *StreamReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.src.(io.Seeker)
+	if !ok {
+		return 0, errors.New("underlying reader is not seekable")
+	}
+
+	size, err := r.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if abs == r.offset && r.err == nil {
+		return abs, nil
+	}
+
+	r.buf, r.err, r.offset = nil, nil, abs
+	if abs >= size {
+		// reads past the end return EOF without touching the underlying reader
+		r.final, r.skip = true, 0
+		return abs, nil
+	}
+
+	chunk := abs / ChunkSize
+	if _, err = seeker.Seek(streamHeaderSize+chunk*sealedChunkSize, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r.chunk, r.final, r.skip = uint32(chunk), false, int(abs%ChunkSize)
+	return abs, nil
+}
+
+// Size returns the plaintext size of the stream.
+// It returns an error if the underlying reader is not an io.Seeker.
+func (r *StreamReader) Size() (int64, error) {
+	if r.size >= 0 {
+		return r.size, nil
+	}
+
+	seeker, ok := r.src.(io.Seeker)
+	if !ok {
+		return 0, errors.New("underlying reader is not seekable")
+	}
+
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = seeker.Seek(current, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if r.size, err = PlaintextSize(end); err != nil {
+		return 0, err
+	}
+
+	return r.size, nil
+}
+
+// PlaintextSize returns the size of the plaintext of a stream of the given encrypted size.
+func PlaintextSize(encryptedSize int64) (int64, error) {
+	body := encryptedSize - streamHeaderSize
+	if body < chunkOverhead {
+		return 0, ErrTruncatedStream
+	}
+
+	chunks := body / sealedChunkSize
+	if rem := body % sealedChunkSize; rem != 0 {
+		if rem < chunkOverhead {
+			return 0, ErrTruncatedStream
+		}
+		chunks++
+	}
+
+	return body - chunks*chunkOverhead, nil
+}
+
+// EncryptedSize returns the size of the stream encrypting a plaintext of the given size.
+func EncryptedSize(plaintextSize int64) int64 {
+	chunks := plaintextSize/ChunkSize + 1
+	if plaintextSize > 0 && plaintextSize%ChunkSize == 0 {
+		chunks--
+	}
+
+	return streamHeaderSize + plaintextSize + chunks*chunkOverhead
+}
+
+func newStreamGCM(dataKey []byte) (cipher.AEAD, error) {
+	if len(dataKey) != DataKeySize {
+		return nil, fmt.Errorf("data key must be %d bytes, got %d", DataKeySize, len(dataKey))
+	}
+
+	return newGCM(dataKey)
+}
+
+func setChunkNonce(nonce *[12]byte, chunk uint32, final bool) {
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], chunk)
+	nonce[11] = 0
+	if final {
+		nonce[11] = 1
+	}
+}
@@ -0,0 +1,218 @@
+package encryptor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	// Setup
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("Failed to create data key: %v", err)
+	}
+	ad := []byte("bucket/object")
+
+	seal := func(t *testing.T, plaintext []byte) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		w, err := NewStreamWriter(&buf, key, ad)
+		if err != nil {
+			t.Fatalf("Failed to create stream writer: %v", err)
+		}
+		if _, err = w.Write(plaintext); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	open := func(sealed []byte, key, ad []byte) ([]byte, error) {
+		r, err := NewStreamReader(bytes.NewReader(sealed), key, ad)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize, 3*ChunkSize + 17} {
+			plaintext := pattern(size)
+			sealed := seal(t, plaintext)
+
+			if int64(len(sealed)) != EncryptedSize(int64(size)) {
+				t.Errorf("size %d: expected %d encrypted bytes, got %d", size, EncryptedSize(int64(size)), len(sealed))
+			}
+
+			if n, err := PlaintextSize(int64(len(sealed))); err != nil || n != int64(size) {
+				t.Errorf("size %d: PlaintextSize returned %d, %v", size, n, err)
+			}
+
+			decrypted, err := open(sealed, key, ad)
+			if err != nil {
+				t.Fatalf("size %d: decryption failed: %v", size, err)
+			}
+
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("size %d: decrypted plaintext does not match", size)
+			}
+		}
+	})
+
+	t.Run("small writes", func(t *testing.T) {
+		plaintext := pattern(2*ChunkSize + 5)
+		var buf bytes.Buffer
+		w, err := NewStreamWriter(&buf, key, ad)
+		if err != nil {
+			t.Fatalf("Failed to create stream writer: %v", err)
+		}
+		for i := 0; i < len(plaintext); i += 1000 {
+			if _, err = w.Write(plaintext[i:min(i+1000, len(plaintext))]); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		decrypted, err := open(buf.Bytes(), key, ad)
+		if err != nil {
+			t.Fatalf("Decryption failed: %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Error("decrypted plaintext does not match")
+		}
+	})
+
+	t.Run("distinct ciphertexts", func(t *testing.T) {
+		plaintext := pattern(100)
+		if bytes.Equal(seal(t, plaintext), seal(t, plaintext)) {
+			t.Error("Expected different ciphertexts for the same plaintext")
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, _ := NewDataKey()
+		_, err := open(seal(t, pattern(100)), other, ad)
+		if !errors.Is(err, ErrCorruptedStream) {
+			t.Errorf("Expected ErrCorruptedStream, got %v", err)
+		}
+	})
+
+	t.Run("wrong additional data", func(t *testing.T) {
+		_, err := open(seal(t, pattern(100)), key, []byte("bucket/other"))
+		if !errors.Is(err, ErrCorruptedStream) {
+			t.Errorf("Expected ErrCorruptedStream, got %v", err)
+		}
+	})
+
+	t.Run("tampered chunk", func(t *testing.T) {
+		sealed := seal(t, pattern(2*ChunkSize))
+		sealed[streamHeaderSize+sealedChunkSize+10] ^= 1
+		_, err := open(sealed, key, ad)
+		if !errors.Is(err, ErrCorruptedStream) {
+			t.Errorf("Expected ErrCorruptedStream, got %v", err)
+		}
+	})
+
+	t.Run("truncated at chunk boundary", func(t *testing.T) {
+		sealed := seal(t, pattern(2*ChunkSize+10))
+		_, err := open(sealed[:streamHeaderSize+2*sealedChunkSize], key, ad)
+		if !errors.Is(err, ErrTruncatedStream) {
+			t.Errorf("Expected ErrTruncatedStream, got %v", err)
+		}
+	})
+
+	t.Run("truncated mid chunk", func(t *testing.T) {
+		sealed := seal(t, pattern(2*ChunkSize+10))
+		_, err := open(sealed[:len(sealed)-5], key, ad)
+		if err == nil {
+			t.Error("Expected truncated stream to fail")
+		}
+	})
+
+	t.Run("reordered chunks", func(t *testing.T) {
+		sealed := seal(t, pattern(3*ChunkSize))
+		first := sealed[streamHeaderSize : streamHeaderSize+sealedChunkSize]
+		second := sealed[streamHeaderSize+sealedChunkSize : streamHeaderSize+2*sealedChunkSize]
+		swapped := append(append(append([]byte{}, sealed[:streamHeaderSize]...), second...), first...)
+		swapped = append(swapped, sealed[streamHeaderSize+2*sealedChunkSize:]...)
+
+		_, err := open(swapped, key, ad)
+		if !errors.Is(err, ErrCorruptedStream) {
+			t.Errorf("Expected ErrCorruptedStream, got %v", err)
+		}
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		sealed := seal(t, pattern(10))
+		_, err := open(append(sealed, 0), key, ad)
+		if err == nil {
+			t.Error("Expected trailing data to fail")
+		}
+	})
+
+	t.Run("invalid data key", func(t *testing.T) {
+		if _, err := NewStreamWriter(io.Discard, key[:16], ad); err == nil {
+			t.Error("Expected short data key to fail")
+		}
+	})
+}
+
+func TestStreamSeek(t *testing.T) {
+	key, _ := NewDataKey()
+	plaintext := pattern(3*ChunkSize + 100)
+
+	var buf bytes.Buffer
+	w, err := NewStreamWriter(&buf, key, nil)
+	if err != nil {
+		t.Fatalf("Failed to create stream writer: %v", err)
+	}
+	w.Write(plaintext)
+	w.Close()
+
+	r, err := NewStreamReader(bytes.NewReader(buf.Bytes()), key, nil)
+	if err != nil {
+		t.Fatalf("Failed to create stream reader: %v", err)
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil || size != int64(len(plaintext)) {
+		t.Fatalf("Expected size %d, got %d, %v", len(plaintext), size, err)
+	}
+
+	for _, offset := range []int64{0, 1, ChunkSize - 1, ChunkSize, 2*ChunkSize + 7, int64(len(plaintext)) - 1} {
+		if _, err = r.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek to %d failed: %v", offset, err)
+		}
+
+		got := make([]byte, 50)
+		n, err := io.ReadFull(r, got)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Read at %d failed: %v", offset, err)
+		}
+
+		if !bytes.Equal(got[:n], plaintext[offset:min(offset+50, int64(len(plaintext)))]) {
+			t.Errorf("Read at %d returned the wrong bytes", offset)
+		}
+	}
+
+	if _, err = r.Seek(10, io.SeekEnd); err != nil {
+		t.Fatalf("Seek past end failed: %v", err)
+	}
+	if _, err = r.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected EOF past end, got %v", err)
+	}
+}
+
+func pattern(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i * 31)
+	}
+	return b
+}
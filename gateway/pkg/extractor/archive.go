@@ -0,0 +1,103 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrTooManyDocuments = errors.New("archive has too many documents")
+
+// ArchiveDocument is a document of an archive, or why it couldn't be extracted.
+type ArchiveDocument struct {
+	// Name is the path of the document within the archive.
+	Name     string
+	Document *Document
+	Format   Format
+	Err      error
+}
+
+// IsArchive reports whether the file is a zip archive of documents,
+// rather than a document packaged as a zip such as EPUB or DOCX, or no zip at all.
+func IsArchive(src io.ReaderAt, size int64) bool {
+	head := make([]byte, 4)
+	if _, err := src.ReadAt(head, 0); err != nil || !bytes.Equal(head, []byte("PK\x03\x04")) {
+		return false
+	}
+
+	if _, err := zip.NewReader(src, size); err != nil {
+		return false
+	}
+
+	_, err := detectZip(src, size)
+	return err != nil
+}
+
+// ExtractArchive extracts the text of every document of a zip archive, in the order of its entries.
+// Directories and the hidden files archivers leave behind are skipped.
+// Documents that can't be extracted come with the reason, the others are still extracted.
+// It fails with ErrTooManyDocuments when the archive holds more than maxDocuments, and ErrNoText when it holds none.
+func ExtractArchive(src io.ReaderAt, size int64, maxDocuments int) ([]ArchiveDocument, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+
+	var files []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || hiddenEntry(f.Name) {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	switch {
+	case len(files) == 0:
+		return nil, ErrNoText
+	case len(files) > maxDocuments:
+		return nil, ErrTooManyDocuments
+	}
+
+	docs := make([]ArchiveDocument, 0, len(files))
+	for _, f := range files {
+		doc := ArchiveDocument{Name: f.Name}
+		doc.Document, doc.Format, doc.Err = extractEntry(f)
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// extractEntry extracts the text of a document of an archive, refusing to decompress more than maxEntrySize.
+func extractEntry(f *zip.File) (*Document, Format, error) {
+	if f.UncompressedSize64 > maxEntrySize {
+		return nil, "", ErrTooLarge
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	// the declared size may lie
+	b, err := io.ReadAll(io.LimitReader(rc, maxEntrySize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+
+	if len(b) > maxEntrySize {
+		return nil, "", ErrTooLarge
+	}
+
+	return Extract(bytes.NewReader(b), int64(len(b)), path.Base(f.Name))
+}
+
+// hiddenEntry reports whether an entry is a dotfile, or the resource forks macOS adds to the archives it makes.
+func hiddenEntry(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
@@ -0,0 +1,78 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// sniffLen is how much of a document is looked at to tell its format.
+const sniffLen = 4096
+
+var markdownSyntax = regexp.MustCompile(`(?m)^(#{1,6} \S|\s*[-*+] \S|\s*\d+\. \S|> |` + "```" + `)|\[[^\]]+\]\([^)]+\)|\*\*\S`)
+
+// Detect tells the format of a document from its bytes.
+// The filename's extension is only used to tell Markdown from plain text, which can look alike.
+func Detect(src io.ReaderAt, size int64, filename string) (Format, error) {
+	head := make([]byte, min(size, sniffLen))
+	if _, err := src.ReadAt(head, 0); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return PDF, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return detectZip(src, size)
+	}
+
+	// a UTF-8 byte order mark would hide HTML from content sniffing
+	contentType := http.DetectContentType(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF")))
+	switch {
+	case strings.HasPrefix(contentType, "text/html"):
+		return HTML, nil
+	case strings.HasPrefix(contentType, "text/xml"):
+		// XHTML declares itself as XML before its root element
+		if bytes.Contains(bytes.ToLower(head), []byte("<html")) {
+			return HTML, nil
+		}
+		return "", ErrUnsupportedFormat
+	case strings.HasPrefix(contentType, "text/plain"):
+		switch strings.ToLower(path.Ext(filename)) {
+		case ".md", ".markdown":
+			return Markdown, nil
+		case ".txt", ".text":
+			return Text, nil
+		}
+
+		if len(markdownSyntax.FindAllIndex(head, 3)) >= 3 {
+			return Markdown, nil
+		}
+		return Text, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+// detectZip tells EPUB and DOCX packages apart by the entries they must contain.
+func detectZip(src io.ReaderAt, size int64) (Format, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return "", ErrUnsupportedFormat
+	}
+
+	for _, f := range zr.File {
+		switch f.Name {
+		case "META-INF/container.xml":
+			return EPUB, nil
+		case "word/document.xml":
+			return DOCX, nil
+		}
+	}
+
+	return "", ErrUnsupportedFormat
+}
@@ -0,0 +1,110 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// docxHeadingStyle matches the built-in heading styles, whose ids are "Heading1" through "Heading9".
+var docxHeadingStyle = regexp.MustCompile(`(?i)^heading\s*([1-9])$`)
+
+type docxExtractor struct{}
+
+// docxParagraph is a paragraph being read, along with what its properties say about it.
+type docxParagraph struct {
+	text  strings.Builder
+	level int
+}
+
+// Extract reads the paragraphs of the main document, turning those in heading styles or outline levels into headings.
+// Deleted revisions and field codes are left out.
+func (docxExtractor) Extract(src io.ReaderAt, size int64) (*Document, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := readZipEntry(zr, "word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+
+	// paragraphs nest, as in text boxes, so they are kept on a stack
+	var stack []*docxParagraph
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				stack = append(stack, &docxParagraph{})
+			case "pStyle":
+				if m := docxHeadingStyle.FindStringSubmatch(attr(t, "val")); m != nil && len(stack) > 0 {
+					stack[len(stack)-1].level, _ = strconv.Atoi(m[1])
+				} else if strings.EqualFold(attr(t, "val"), "Title") && len(stack) > 0 {
+					stack[len(stack)-1].level = 1
+				}
+			case "outlineLvl":
+				if lvl, err := strconv.Atoi(attr(t, "val")); err == nil && lvl < 9 && len(stack) > 0 {
+					stack[len(stack)-1].level = lvl + 1
+				}
+			case "t":
+				inText = true
+			case "tab", "br", "cr":
+				if len(stack) > 0 {
+					stack[len(stack)-1].text.WriteByte(' ')
+				}
+			}
+		case xml.CharData:
+			if inText && len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if len(stack) == 0 {
+					continue
+				}
+
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if p.level > 0 {
+					doc.heading(min(p.level, 6), p.text.String())
+				} else {
+					doc.paragraph(p.text.String())
+				}
+			}
+		}
+	}
+
+	return doc, nil
+}
+
+func attr(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,128 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+
+	"golang.org/x/net/html/charset"
+)
+
+// maxEntrySize caps how much is decompressed from a single entry of an EPUB or DOCX package.
+const maxEntrySize = 64 << 20 // 64 MB
+
+type epubExtractor struct{}
+
+// Extract reads the content documents of the book in spine order, skipping the non-linear ones.
+func (epubExtractor) Extract(src io.ReaderAt, size int64) (*Document, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err = readZipXML(zr, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("epub container has no rootfile")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg struct {
+		Manifest []struct {
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
+		} `xml:"spine>itemref"`
+	}
+	if err = readZipXML(zr, opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	hrefs := make(map[string]string, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		if item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html" {
+			hrefs[item.ID] = item.Href
+		}
+	}
+
+	doc := &Document{}
+	for _, ref := range pkg.Spine {
+		href, ok := hrefs[ref.IDRef]
+		if !ok || ref.Linear == "no" {
+			continue
+		}
+
+		// hrefs are URLs relative to the package document
+		name, err := url.PathUnescape(href)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content document href %q: %w", href, err)
+		}
+
+		b, err := readZipEntry(zr, path.Join(path.Dir(opfPath), name))
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := charset.NewReader(bytes.NewReader(b), "application/xhtml+xml")
+		if err != nil {
+			return nil, err
+		}
+
+		if err = extractHTML(doc, r); err != nil {
+			return nil, fmt.Errorf("failed to read content document %s: %w", name, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// readZipEntry reads a whole entry of a zip package, refusing to decompress more than maxEntrySize.
+func readZipEntry(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxEntrySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if len(b) > maxEntrySize {
+		return nil, ErrTooLarge
+	}
+
+	return b, nil
+}
+
+func readZipXML(zr *zip.Reader, name string, v any) error {
+	b, err := readZipEntry(zr, name)
+	if err != nil {
+		return err
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.CharsetReader = charset.NewReaderLabel
+	if err = dec.Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+// Package extractor turns uploaded documents into clean, reading-order UTF-8 text.
+//
+// The format of a document is sniffed from its bytes rather than trusted from its declared content type,
+// and each format is handled by its own Extractor.
+package extractor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported document format")
+	ErrNoText            = errors.New("document has no readable text")
+	ErrTooLarge          = errors.New("document too large")
+)
+
+type Format string
+
+const (
+	Text     Format = "text"
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+	EPUB     Format = "epub"
+	DOCX     Format = "docx"
+	PDF      Format = "pdf"
+)
+
+// Extractor extracts the text of a single document format.
+type Extractor interface {
+	Extract(src io.ReaderAt, size int64) (*Document, error)
+}
+
+var extractors = map[Format]Extractor{
+	Text:     textExtractor{},
+	Markdown: markdownExtractor{},
+	HTML:     htmlExtractor{},
+	EPUB:     epubExtractor{},
+	DOCX:     docxExtractor{},
+	PDF:      pdfExtractor{},
+}
+
+// Extract sniffs the format of the document and extracts its text.
+// The filename is only used to tell Markdown from plain text.
+func Extract(src io.ReaderAt, size int64, filename string) (*Document, Format, error) {
+	format, err := Detect(src, size, filename)
+	if err != nil {
+		return nil, "", err
+	}
+
+	doc, err := extractors[format].Extract(src, size)
+	if err != nil {
+		return nil, format, fmt.Errorf("failed to extract %s: %w", format, err)
+	}
+
+	if len(doc.Blocks) == 0 {
+		return nil, format, ErrNoText
+	}
+
+	return doc, format, nil
+}
+
+type BlockKind int
+
+const (
+	Paragraph BlockKind = iota
+	Heading
+)
+
+// Block is a heading or paragraph of normalised text: NFC, single spaced, on a single line.
+type Block struct {
+	Kind BlockKind
+	// Level is the heading level, from 1 for the most important, and 0 for paragraphs.
+	Level int
+	Text  string
+}
+
+// Document is the text of a document as blocks in reading order.
+type Document struct {
+	Blocks []Block
+}
+
+// WriteTo writes the document as plain text, one block per line with a blank line between blocks.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for i, b := range d.Blocks {
+		sep := "\n\n"
+		if i == len(d.Blocks)-1 {
+			sep = "\n"
+		}
+
+		n, err := io.WriteString(w, b.Text+sep)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Hash returns the hex SHA-256 of the text of the document as written by WriteTo.
+// Blocks are normalised as they are extracted, so that documents that read the same hash the same,
+// whatever their format or spacing.
+func (d *Document) Hash() string {
+	h := sha256.New()
+	d.WriteTo(h)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ParagraphHashes returns the hex encoded SHA-256 of the text of each block, headings included, in reading order.
+// The hashes tell which paragraphs of a document changed between its versions, wherever they moved to.
+func (d *Document) ParagraphHashes() []string {
+	hashes := make([]string, 0, len(d.Blocks))
+	for _, b := range d.Blocks {
+		sum := sha256.Sum256([]byte(b.Text))
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+	return hashes
+}
+
+func (d *Document) String() string {
+	var sb strings.Builder
+	d.WriteTo(&sb)
+	return sb.String()
+}
+
+// heading appends a heading, normalising its text. Empty headings are dropped.
+func (d *Document) heading(level int, text string) {
+	d.add(Block{Kind: Heading, Level: level, Text: text})
+}
+
+// paragraph appends a paragraph, normalising its text. Empty paragraphs are dropped.
+func (d *Document) paragraph(text string) {
+	d.add(Block{Kind: Paragraph, Text: text})
+}
+
+func (d *Document) add(b Block) {
+	if b.Text = normalize(b.Text); b.Text != "" {
+		d.Blocks = append(d.Blocks, b)
+	}
+}
+
+// normalize composes the text to NFC, drops control and zero-width characters,
+// and collapses every run of whitespace into a single space.
+func normalize(s string) string {
+	s = norm.NFC.String(s)
+
+	var sb strings.Builder
+	sb.Grow(len(s))
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			space = sb.Len() > 0
+			continue
+		case r == '\uFEFF', r == '\u200B', r == '\u00AD', unicode.IsControl(r):
+			continue
+		}
+
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,321 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func h(level int, text string) Block { return Block{Kind: Heading, Level: level, Text: text} }
+func p(text string) Block            { return Block{Kind: Paragraph, Text: text} }
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		file   string
+		format Format
+		want   []Block
+	}{
+		{
+			file:   "latin1.txt",
+			format: Text,
+			want: []Block{
+				p("The café was quiet. A naïve stranger walked in."),
+				p("Nobody looked up."),
+			},
+		},
+		{
+			file:   "utf16.txt",
+			format: Text,
+			want: []Block{
+				p("¿Dónde está la biblioteca?"),
+				p("Está allí."),
+			},
+		},
+		{
+			file:   "story.md",
+			format: Markdown,
+			want: []Block{
+				h(1, "Chapter One"),
+				p("It was a dark and stormy night; the rain fell in torrents."),
+				h(2, "The Storm"),
+				p("First, the wind."),
+				p("Then, the thunder_clap."),
+				p("A quoted line that continues."),
+			},
+		},
+		{
+			file:   "page.html",
+			format: HTML,
+			want: []Block{
+				h(1, "The Lighthouse"),
+				p("The keeper climbed the stairs & lit the lamp."),
+				p("Ships passed safely that night."),
+				p("Oil"),
+				p("Wicks"),
+			},
+		},
+		{
+			file:   "book.epub",
+			format: EPUB,
+			want: []Block{
+				h(1, "Chapter 1"),
+				p("First words."),
+				p("Second words."),
+				h(1, "Chapter 2"),
+				p("Last words."),
+			},
+		},
+		{
+			file:   "essay.docx",
+			format: DOCX,
+			want: []Block{
+				h(1, "An Essay"),
+				h(2, "Introduction"),
+				p("Words split across runs."),
+				p("A field-free line"),
+				p("In a table."),
+			},
+		},
+		{
+			file:   "article.pdf",
+			format: PDF,
+			want: []Block{
+				p("The first paragraph starts here and runs over two lines."),
+				p("A second paragraph follows, and it carries over to the next page."),
+				p("The end."),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+
+			doc, format, err := Extract(bytes.NewReader(b), int64(len(b)), tt.file)
+			if err != nil {
+				t.Fatalf("Extract failed: %v", err)
+			}
+
+			if format != tt.format {
+				t.Errorf("Expected format %q, got %q", tt.format, format)
+			}
+
+			if !slices.Equal(doc.Blocks, tt.want) {
+				t.Errorf("Expected blocks\n%q\ngot\n%q", tt.want, doc.Blocks)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		filename string
+		want     Format
+	}{
+		{"plain text", "Just some words.\n", "notes.txt", Text},
+		{"markdown by extension", "Just some words.\n", "notes.md", Markdown},
+		{"markdown by syntax", "# Title\n\n- one\n- two\n\n[link](https://example.com)\n", "upload", Markdown},
+		{"markdown-looking text file", "# Title\n\n- one\n- two\n", "notes.txt", Text},
+		{"html", "<!doctype html><p>Hello</p>", "page.txt", HTML},
+		{"html behind a byte order mark", "\xEF\xBB\xBF<html><body>Hello</body></html>", "page", HTML},
+		{"xhtml", `<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"></html>`, "page.xhtml", HTML},
+		{"pdf", "%PDF-1.7\n", "document", PDF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Detect(bytes.NewReader([]byte(tt.content)), int64(len(tt.content)), tt.filename)
+			if err != nil {
+				t.Fatalf("Detect failed: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		for _, content := range []string{
+			"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+			`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`,
+			"PK\x03\x04 not really a zip",
+		} {
+			_, err := Detect(bytes.NewReader([]byte(content)), int64(len(content)), "upload.txt")
+			if !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("Expected ErrUnsupportedFormat for %q, got %v", content, err)
+			}
+		}
+	})
+}
+
+func TestExtractNoText(t *testing.T) {
+	content := "<html><head><title>Title</title></head><body><script>1</script>\u200B</body></html>"
+	_, _, err := Extract(bytes.NewReader([]byte(content)), int64(len(content)), "empty.html")
+	if !errors.Is(err, ErrNoText) {
+		t.Errorf("Expected ErrNoText, got %v", err)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	doc := &Document{}
+	doc.heading(1, "  Title\n")
+	doc.paragraph("e\u0301 \t soft\u00ADhyphen")
+	doc.paragraph("   ")
+
+	want := "Title\n\n\u00E9 softhyphen\n"
+	if got := doc.String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestHash(t *testing.T) {
+	extract := func(t *testing.T, name, content string) *Document {
+		doc, _, err := Extract(bytes.NewReader([]byte(content)), int64(len(content)), name)
+		if err != nil {
+			t.Fatalf("Failed to extract %s: %v", name, err)
+		}
+		return doc
+	}
+
+	text := extract(t, "story.txt", "Once upon  a time.\n\nThe end.\n")
+	html := extract(t, "story.html", "<html><body><p>Once upon\n a time.</p><p>The end.</p></body></html>")
+	other := extract(t, "other.txt", "Once upon a time.\n\nThe beginning.\n")
+
+	if text.Hash() != html.Hash() {
+		t.Errorf("Expected documents reading the same to hash the same, got %q and %q", text.Hash(), html.Hash())
+	}
+
+	if text.Hash() == other.Hash() {
+		t.Errorf("Expected documents reading differently to hash differently, got %q for both", text.Hash())
+	}
+}
+
+func TestParagraphHashes(t *testing.T) {
+	extract := func(t *testing.T, content string) []string {
+		doc, _, err := Extract(bytes.NewReader([]byte(content)), int64(len(content)), "story.txt")
+		if err != nil {
+			t.Fatalf("Failed to extract: %v", err)
+		}
+		return doc.ParagraphHashes()
+	}
+
+	first := extract(t, "Once upon a time.\n\nThe end.\n")
+	edited := extract(t, "A prologue.\n\nOnce upon  a time.\n\nThe very end.\n")
+
+	if len(first) != 2 || len(edited) != 3 {
+		t.Fatalf("Expected 2 and 3 hashes, got %d and %d", len(first), len(edited))
+	}
+
+	if first[0] != edited[1] {
+		t.Errorf("Expected unchanged paragraphs to hash the same, got %q and %q", first[0], edited[1])
+	}
+
+	if first[1] == edited[2] {
+		t.Errorf("Expected edited paragraphs to hash differently, got %q for both", first[1])
+	}
+}
+
+// zipOf returns a zip archive of the named files, in order.
+func zipOf(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f[0])
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", f[0], err)
+		}
+		if _, err = w.Write([]byte(f[1])); err != nil {
+			t.Fatalf("Failed to write %s: %v", f[0], err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	archive := zipOf(t,
+		[2]string{"course/", ""},
+		[2]string{"course/01 intro.txt", "Welcome to the course.\n"},
+		[2]string{"course/.DS_Store", "\x00\x00\x00\x01Bud1"},
+		[2]string{"__MACOSX/course/._01 intro.txt", "\x00\x05\x16\x07"},
+		[2]string{"course/02 basics.md", "# Basics\n\nThe first lesson.\n"},
+		[2]string{"course/cover.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"},
+	)
+
+	t.Run("documents in order", func(t *testing.T) {
+		docs, err := ExtractArchive(bytes.NewReader(archive), int64(len(archive)), 10)
+		if err != nil {
+			t.Fatalf("ExtractArchive failed: %v", err)
+		}
+
+		var names []string
+		for _, doc := range docs {
+			names = append(names, doc.Name)
+		}
+		if want := []string{"course/01 intro.txt", "course/02 basics.md", "course/cover.png"}; !slices.Equal(names, want) {
+			t.Fatalf("Expected %q, got %q", want, names)
+		}
+
+		if docs[0].Err != nil || docs[0].Format != Text || docs[0].Document.String() != "Welcome to the course.\n" {
+			t.Errorf("Unexpected first document %+v", docs[0])
+		}
+
+		if docs[1].Err != nil || docs[1].Format != Markdown || !slices.Equal(docs[1].Document.Blocks, []Block{h(1, "Basics"), p("The first lesson.")}) {
+			t.Errorf("Unexpected second document %+v", docs[1])
+		}
+
+		if !errors.Is(docs[2].Err, ErrUnsupportedFormat) {
+			t.Errorf("Expected ErrUnsupportedFormat, got %v", docs[2].Err)
+		}
+	})
+
+	t.Run("too many documents", func(t *testing.T) {
+		if _, err := ExtractArchive(bytes.NewReader(archive), int64(len(archive)), 2); !errors.Is(err, ErrTooManyDocuments) {
+			t.Errorf("Expected ErrTooManyDocuments, got %v", err)
+		}
+	})
+
+	t.Run("no documents", func(t *testing.T) {
+		empty := zipOf(t, [2]string{"course/", ""}, [2]string{".hidden", "x"})
+		if _, err := ExtractArchive(bytes.NewReader(empty), int64(len(empty)), 10); !errors.Is(err, ErrNoText) {
+			t.Errorf("Expected ErrNoText, got %v", err)
+		}
+	})
+
+	t.Run("archives are told from packaged documents", func(t *testing.T) {
+		epub, err := os.ReadFile(filepath.Join("testdata", "book.epub"))
+		if err != nil {
+			t.Fatalf("Failed to read fixture: %v", err)
+		}
+
+		for _, tt := range []struct {
+			name    string
+			content []byte
+			want    bool
+		}{
+			{"archive", archive, true},
+			{"epub", epub, false},
+			{"text", []byte("Just some words.\n"), false},
+			{"broken zip", []byte("PK\x03\x04 not really a zip"), false},
+		} {
+			if got := IsArchive(bytes.NewReader(tt.content), int64(len(tt.content))); got != tt.want {
+				t.Errorf("Expected %v for %s, got %v", tt.want, tt.name, got)
+			}
+		}
+	})
+}
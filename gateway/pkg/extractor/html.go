@@ -0,0 +1,145 @@
+package extractor
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// skipped holds the elements whose content is never read aloud, navigation menus included.
+var skipped = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Nav: true, atom.Svg: true, atom.Math: true, atom.Iframe: true, atom.Object: true, atom.Canvas: true,
+	atom.Button: true, atom.Select: true, atom.Textarea: true, atom.Rt: true, atom.Rp: true,
+}
+
+// blocks holds the elements that start and end a paragraph.
+var blocks = map[atom.Atom]bool{
+	atom.Html: true, atom.Body: true, atom.Main: true, atom.Article: true, atom.Section: true, atom.Div: true,
+	atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Address: true, atom.Center: true,
+	atom.P: true, atom.Blockquote: true, atom.Pre: true, atom.Hr: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Table: true, atom.Caption: true, atom.Tr: true,
+}
+
+var headings = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+type htmlExtractor struct{}
+
+// Extract reads the headings and paragraphs of the body, honouring the declared charset.
+func (htmlExtractor) Extract(src io.ReaderAt, size int64) (*Document, error) {
+	r, err := charset.NewReader(io.NewSectionReader(src, 0, size), "")
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{}
+	if err = extractHTML(doc, r); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// extractHTML appends the blocks of an HTML or XHTML document to doc.
+func extractHTML(doc *Document, r io.Reader) error {
+	root, err := html.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	flush := func() {
+		doc.paragraph(sb.String())
+		sb.Reset()
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if skipped[n.DataAtom] || hidden(n) {
+				return
+			}
+
+			switch n.DataAtom {
+			case atom.Br, atom.Td, atom.Th:
+				sb.WriteByte(' ')
+			}
+
+			if level, ok := headings[n.DataAtom]; ok {
+				flush()
+				doc.heading(level, textContent(n))
+				return
+			}
+		}
+
+		block := n.Type == html.ElementNode && blocks[n.DataAtom]
+		if block {
+			flush()
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+
+		if block {
+			flush()
+		}
+	}
+	walk(root)
+	flush()
+
+	return nil
+}
+
+// hidden reports whether an element isn't part of the reading flow,
+// such as hidden content or the page break markers of EPUBs.
+func hidden(n *html.Node) bool {
+	for _, a := range n.Attr {
+		switch {
+		case a.Key == "hidden", a.Key == "aria-hidden" && a.Val == "true":
+			return true
+		case a.Key == "epub:type" || (a.Namespace == "epub" && a.Key == "type"):
+			if strings.Contains(a.Val, "pagebreak") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// textContent returns the readable text inside n.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+		case html.ElementNode:
+			if skipped[n.DataAtom] || hidden(n) {
+				return
+			}
+			if n.DataAtom == atom.Br {
+				sb.WriteByte(' ')
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	return sb.String()
+}
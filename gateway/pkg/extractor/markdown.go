@@ -0,0 +1,111 @@
+package extractor
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	mdFence      = regexp.MustCompile("^\\s*(```|~~~)")
+	mdATXHeading = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	mdSetext1    = regexp.MustCompile(`^\s{0,3}=+\s*$`)
+	mdSetext2    = regexp.MustCompile(`^\s{0,3}-+\s*$`)
+	mdBreak      = regexp.MustCompile(`^\s{0,3}((\*\s*){3,}|(-\s*){3,}|(_\s*){3,})$`)
+	mdListItem   = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s+(.*)$`)
+	mdQuote      = regexp.MustCompile(`^\s*(>\s?)+`)
+	mdLinkDef    = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s+\S+`)
+	mdTableRule  = regexp.MustCompile(`^\s*\|?(\s*:?-+:?\s*\|)+\s*:?-*:?\s*$`)
+
+	mdInline = []struct {
+		re   *regexp.Regexp
+		repl string
+	}{
+		{regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`), ""},                    // images
+		{regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`), "$1"},                 // inline links
+		{regexp.MustCompile(`\[([^\]]+)\]\[[^\]]*\]`), "$1"},                // reference links
+		{regexp.MustCompile(`<(https?|mailto):[^>]+>`), ""},                 // autolinks
+		{regexp.MustCompile("`+([^`]+)`+"), "$1"},                           // code spans
+		{regexp.MustCompile(`(\*\*|__)(\S(.*?\S)?)(\*\*|__)`), "$2"},        // strong
+		{regexp.MustCompile(`\*(\S(.*?\S)?)\*`), "$1"},                      // emphasis
+		{regexp.MustCompile(`(^|\W)_(\S(.*?\S)?)_(\W|$)`), "$1$2$4"},        // emphasis, leaving snake_case alone
+		{regexp.MustCompile(`~~(.+?)~~`), "$1"},                             // strikethrough
+		{regexp.MustCompile(`</?[a-zA-Z][^>]*>`), ""},                       // inline HTML
+		{regexp.MustCompile(`\\([\\` + "`" + `*_{}\[\]()#+\-.!>|])`), "$1"}, // escapes
+		{regexp.MustCompile(`\|`), " "},                                     // table cells
+	}
+)
+
+type markdownExtractor struct{}
+
+// Extract reads headings and paragraphs, dropping code blocks and markup.
+// List items and table rows become paragraphs of their own.
+func (markdownExtractor) Extract(src io.ReaderAt, size int64) (*Document, error) {
+	text, err := readText(src, size)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{}
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			doc.paragraph(mdInlineText(strings.Join(para, " ")))
+			para = nil
+		}
+	}
+
+	var fence string
+	for _, line := range strings.Split(text, "\n") {
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimSpace(line), fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if m := mdFence.FindStringSubmatch(line); m != nil {
+			flush()
+			fence = m[1]
+			continue
+		}
+
+		line = mdQuote.ReplaceAllString(line, "")
+
+		switch m := mdATXHeading.FindStringSubmatch(line); {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case m != nil:
+			flush()
+			doc.heading(len(m[1]), mdInlineText(m[2]))
+		case len(para) > 0 && mdSetext1.MatchString(line):
+			doc.heading(1, mdInlineText(strings.Join(para, " ")))
+			para = nil
+		case len(para) > 0 && mdSetext2.MatchString(line):
+			doc.heading(2, mdInlineText(strings.Join(para, " ")))
+			para = nil
+		case mdBreak.MatchString(line), mdTableRule.MatchString(line), mdLinkDef.MatchString(line):
+			flush()
+		case mdListItem.MatchString(line):
+			flush()
+			para = append(para, mdListItem.FindStringSubmatch(line)[2])
+		case strings.HasPrefix(strings.TrimSpace(line), "|"):
+			flush()
+			para = append(para, line)
+			flush()
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+
+	return doc, nil
+}
+
+func mdInlineText(s string) string {
+	for _, r := range mdInline {
+		s = r.re.ReplaceAllString(s, r.repl)
+	}
+
+	return s
+}
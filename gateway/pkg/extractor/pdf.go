@@ -0,0 +1,150 @@
+package extractor
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// paragraphGap is how much wider than the usual line spacing of a page the gap above a line has to be
+// for the line to start a new paragraph.
+const paragraphGap = 1.4
+
+type pdfExtractor struct{}
+
+// Extract reads the text of each page top to bottom, joining lines into paragraphs by their spacing.
+// Lines holding nothing but a page number are dropped, and words hyphenated across lines are rejoined.
+func (pdfExtractor) Extract(src io.ReaderAt, size int64) (doc *Document, err error) {
+	// the reader panics on some malformed documents
+	defer func() {
+		if r := recover(); r != nil {
+			doc, err = nil, fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(src, size)
+	if err != nil {
+		return nil, err
+	}
+
+	doc = &Document{}
+	var para []string
+	var spacing float64
+	flush := func() {
+		doc.paragraph(joinLines(para))
+		para = nil
+	}
+
+	for i := 1; i <= r.NumPage(); i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		rows, err := page.GetTextByRow()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %w", i, err)
+		}
+
+		// pages with too few lines to tell line spacing from paragraph gaps keep that of the pages before them
+		lines := pageLines(rows)
+		if s, ok := lineSpacing(lines); ok || spacing == 0 {
+			spacing = s
+		}
+		for j, l := range lines {
+			if j > 0 && spacing > 0 && lines[j-1].y-l.y > spacing*paragraphGap {
+				flush()
+			}
+			para = append(para, l.text)
+		}
+
+		// a paragraph carries over to the next page unless its sentence ended
+		if len(para) > 0 && endsSentence(para[len(para)-1]) {
+			flush()
+		}
+	}
+	flush()
+
+	return doc, nil
+}
+
+// pdfLine is a line of text and its baseline on the page, in points from the bottom.
+type pdfLine struct {
+	text string
+	y    float64
+}
+
+// pageLines returns the lines of a page top to bottom, leaving out blank lines and page numbers.
+func pageLines(rows pdf.Rows) []pdfLine {
+	var lines []pdfLine
+	for _, row := range rows {
+		var sb strings.Builder
+		for _, t := range row.Content {
+			sb.WriteString(t.S)
+		}
+
+		text := strings.TrimSpace(sb.String())
+		if text == "" || isPageNumber(text) {
+			continue
+		}
+		lines = append(lines, pdfLine{text: text, y: float64(row.Position)})
+	}
+
+	return lines
+}
+
+// minSpacingSamples is how many gaps between lines a page needs for their median to be trusted as its line spacing.
+const minSpacingSamples = 3
+
+// lineSpacing returns the lower median distance between consecutive lines of a page,
+// and whether the page had enough lines for it to be trusted.
+func lineSpacing(lines []pdfLine) (float64, bool) {
+	var gaps []float64
+	for i := 1; i < len(lines); i++ {
+		if gap := lines[i-1].y - lines[i].y; gap > 0 {
+			gaps = append(gaps, gap)
+		}
+	}
+
+	if len(gaps) == 0 {
+		return 0, false
+	}
+
+	slices.Sort(gaps)
+	return gaps[(len(gaps)-1)/2], len(gaps) >= minSpacingSamples
+}
+
+// joinLines joins the lines of a paragraph, rejoining words hyphenated at line ends.
+func joinLines(lines []string) string {
+	var sb strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			next, _ := utf8.DecodeRuneInString(line)
+			if strings.HasSuffix(prev, "-") && unicode.IsLower(next) {
+				s := sb.String()
+				sb.Reset()
+				sb.WriteString(strings.TrimSuffix(s, "-"))
+			} else {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString(line)
+	}
+
+	return sb.String()
+}
+
+func isPageNumber(line string) bool {
+	return strings.IndexFunc(line, func(r rune) bool { return !unicode.IsDigit(r) }) == -1
+}
+
+func endsSentence(line string) bool {
+	last, _ := utf8.DecodeLastRuneInString(line)
+	return strings.ContainsRune(".!?:\"”’)", last)
+}
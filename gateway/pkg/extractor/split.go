@@ -0,0 +1,160 @@
+package extractor
+
+import (
+	"slices"
+	"unicode/utf8"
+)
+
+// Chapter is a part of a document that is converted on its own.
+type Chapter struct {
+	// Title is the text of the heading the chapter starts with, if any.
+	Title string
+	Document
+}
+
+// Split divides the document into chapters of at most maxSize characters, and at most maxChapters of them,
+// the number of chapters taking precedence over their size.
+//
+// Chapters start at the headings of the highest level that occurs more than once in the document.
+// Chapters longer than maxSize are cut between paragraphs, and chapters shorter than a tenth of it,
+// such as a title page or an epigraph, are merged into their neighbours.
+// When there are still more than maxChapters, the shortest neighbours are merged until there aren't.
+// A document that fits in a single chapter is returned whole.
+func (d *Document) Split(maxSize, maxChapters int) []Chapter {
+	if d.size() <= maxSize || maxChapters <= 1 {
+		return []Chapter{newChapter(d.Blocks)}
+	}
+
+	var chapters []Chapter
+	for _, section := range d.sections() {
+		chapters = append(chapters, cut(section, maxSize)...)
+	}
+
+	// merge the short chapters into the chapter before them, or after them for the first one
+	minSize := maxSize / 10
+	for i := 0; i < len(chapters) && len(chapters) > 1; {
+		if chapters[i].size() >= minSize {
+			i++
+			continue
+		}
+
+		j := max(i-1, 0)
+		if chapters[j].size()+chapters[j+1].size() > maxSize {
+			i++
+			continue
+		}
+		chapters = merge(chapters, j)
+	}
+
+	for len(chapters) > maxChapters {
+		shortest := 0
+		for i := 1; i < len(chapters)-1; i++ {
+			if chapters[i].size()+chapters[i+1].size() < chapters[shortest].size()+chapters[shortest+1].size() {
+				shortest = i
+			}
+		}
+		chapters = merge(chapters, shortest)
+	}
+
+	return chapters
+}
+
+// sections splits the blocks at the headings of the chapter level.
+func (d *Document) sections() [][]Block {
+	level := d.chapterLevel()
+	if level == 0 {
+		return [][]Block{d.Blocks}
+	}
+
+	var sections [][]Block
+	start := 0
+	for i, b := range d.Blocks {
+		if i > start && b.Kind == Heading && b.Level <= level {
+			sections = append(sections, d.Blocks[start:i])
+			start = i
+		}
+	}
+
+	return append(sections, d.Blocks[start:])
+}
+
+// chapterLevel returns the highest heading level occurring more than once, or 0 if there is none.
+func (d *Document) chapterLevel() int {
+	var counts [7]int
+	for _, b := range d.Blocks {
+		if b.Kind == Heading {
+			counts[b.Level]++
+		}
+	}
+
+	for level := 1; level < len(counts); level++ {
+		if counts[level] > 1 {
+			return level
+		}
+	}
+
+	return 0
+}
+
+// cut divides a section into chapters of at most maxSize characters between its paragraphs.
+// A single block longer than maxSize makes a chapter of its own.
+// The chapters cut from the middle of a section are titled after it.
+func cut(blocks []Block, maxSize int) []Chapter {
+	var chapters []Chapter
+	start, size := 0, 0
+	for i, b := range blocks {
+		n := utf8.RuneCountInString(b.Text)
+		if i > start && size+n > maxSize {
+			chapters = append(chapters, newChapter(blocks[start:i]))
+			start, size = i, 0
+		}
+		size += n
+	}
+	chapters = append(chapters, newChapter(blocks[start:]))
+
+	for i := 1; i < len(chapters); i++ {
+		if chapters[i].Title == "" {
+			chapters[i].Title = chapters[i-1].Title
+		}
+	}
+
+	return chapters
+}
+
+// merge joins chapters i and i+1, titling them after the longer of the two.
+func merge(chapters []Chapter, i int) []Chapter {
+	title := chapters[i].Title
+	if chapters[i+1].size() > chapters[i].size() {
+		title = chapters[i+1].Title
+	}
+
+	chapters[i] = Chapter{
+		Title:    title,
+		Document: Document{Blocks: slices.Concat(chapters[i].Blocks, chapters[i+1].Blocks)},
+	}
+
+	return slices.Delete(chapters, i+1, i+2)
+}
+
+// newChapter makes a chapter of the blocks, titled by its first heading.
+func newChapter(blocks []Block) Chapter {
+	c := Chapter{Document: Document{Blocks: blocks}}
+	for _, b := range blocks {
+		if b.Kind == Heading {
+			c.Title = b.Text
+			break
+		}
+	}
+
+	return c
+}
+
+// size returns the number of characters of text in the document.
+func (d *Document) size() int {
+	n := 0
+	for _, b := range d.Blocks {
+		n += utf8.RuneCountInString(b.Text)
+	}
+
+	return n
+}
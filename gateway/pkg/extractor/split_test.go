@@ -0,0 +1,94 @@
+package extractor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// words returns a paragraph of n characters.
+func words(n int) Block {
+	return p(strings.Repeat("a", n))
+}
+
+func titles(chapters []Chapter) []string {
+	var ts []string
+	for _, c := range chapters {
+		ts = append(ts, c.Title)
+	}
+
+	return ts
+}
+
+func TestSplit(t *testing.T) {
+	t.Run("short document", func(t *testing.T) {
+		doc := &Document{Blocks: []Block{h(1, "One"), words(10), h(1, "Two"), words(10)}}
+		chapters := doc.Split(100, 10)
+		if len(chapters) != 1 || !slices.Equal(chapters[0].Blocks, doc.Blocks) {
+			t.Errorf("Expected the whole document as a single chapter, got %v", titles(chapters))
+		}
+	})
+
+	t.Run("chapters at the repeated heading level", func(t *testing.T) {
+		doc := &Document{Blocks: []Block{
+			h(1, "Book"),
+			h(2, "One"), words(40), h(3, "Scene"), words(40),
+			h(2, "Two"), words(40),
+			h(2, "Three"), words(40),
+		}}
+
+		chapters := doc.Split(100, 10)
+		if want := []string{"One", "Two", "Three"}; !slices.Equal(titles(chapters), want) {
+			t.Errorf("Expected chapters %q, got %q", want, titles(chapters))
+		}
+
+		// the book title is too short for a chapter of its own, so it opens the first one
+		if !slices.Equal(chapters[0].Blocks, doc.Blocks[:5]) {
+			t.Errorf("Expected the first chapter to hold the book title and chapter one, got %v", chapters[0].Blocks)
+		}
+	})
+
+	t.Run("long chapters are cut between paragraphs", func(t *testing.T) {
+		doc := &Document{Blocks: []Block{
+			h(1, "One"), words(60), words(60), words(60),
+			h(1, "Two"), words(60),
+		}}
+
+		chapters := doc.Split(100, 10)
+		if want := []string{"One", "One", "One", "Two"}; !slices.Equal(titles(chapters), want) {
+			t.Errorf("Expected chapters %q, got %q", want, titles(chapters))
+		}
+
+		var blocks []Block
+		for _, c := range chapters {
+			if c.size() > 100 {
+				t.Errorf("Chapter of %d characters exceeds the maximum", c.size())
+			}
+			blocks = append(blocks, c.Blocks...)
+		}
+
+		if !slices.Equal(blocks, doc.Blocks) {
+			t.Errorf("Expected the chapters to hold every block in order")
+		}
+	})
+
+	t.Run("at most maxChapters", func(t *testing.T) {
+		doc := &Document{}
+		for range 10 {
+			doc.Blocks = append(doc.Blocks, h(1, "Chapter"), words(50))
+		}
+
+		chapters := doc.Split(100, 4)
+		if len(chapters) != 4 {
+			t.Errorf("Expected 4 chapters, got %d", len(chapters))
+		}
+	})
+
+	t.Run("no headings", func(t *testing.T) {
+		doc := &Document{Blocks: []Block{words(60), words(60), words(60)}}
+		chapters := doc.Split(100, 10)
+		if len(chapters) != 3 {
+			t.Errorf("Expected 3 chapters, got %d", len(chapters))
+		}
+	})
+}
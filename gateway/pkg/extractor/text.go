@@ -0,0 +1,90 @@
+package extractor
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// maxTextSize caps how much text is read from a plain text or Markdown document.
+const maxTextSize = 64 << 20 // 64 MB
+
+type textExtractor struct{}
+
+// Extract reads paragraphs separated by blank lines.
+func (textExtractor) Extract(src io.ReaderAt, size int64) (*Document, error) {
+	text, err := readText(src, size)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{}
+	for _, p := range paragraphs(text) {
+		doc.paragraph(strings.Join(p, " "))
+	}
+
+	return doc, nil
+}
+
+// readText reads a whole text document as UTF-8.
+func readText(src io.ReaderAt, size int64) (string, error) {
+	if size > maxTextSize {
+		return "", ErrTooLarge
+	}
+
+	b, err := io.ReadAll(io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return "", err
+	}
+
+	return decodeText(b)
+}
+
+// decodeText decodes text to UTF-8, honouring a byte order mark.
+// Text without one that isn't valid UTF-8 is taken to be Windows-1252, a superset of ISO-8859-1.
+func decodeText(b []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xEF\xBB\xBF")):
+		b = b[3:]
+	case bytes.HasPrefix(b, []byte("\xFF\xFE")), bytes.HasPrefix(b, []byte("\xFE\xFF")):
+		decoded, err := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(b)
+		if err != nil {
+			return "", err
+		}
+		b = decoded
+	case !utf8.Valid(b):
+		decoded, err := charmap.Windows1252.NewDecoder().Bytes(b)
+		if err != nil {
+			return "", err
+		}
+		b = decoded
+	}
+
+	return strings.ReplaceAll(strings.ReplaceAll(string(b), "\r\n", "\n"), "\r", "\n"), nil
+}
+
+// paragraphs splits text into paragraphs at blank lines, returning the lines of each.
+func paragraphs(text string) [][]string {
+	var ps [][]string
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(lines) > 0 {
+				ps = append(ps, lines)
+				lines = nil
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) > 0 {
+		ps = append(ps, lines)
+	}
+
+	return ps
+}
@@ -9,7 +9,13 @@ import (
 
 type AWS struct {
 	dynamo struct {
-		tableName string
+		tableName         string
+		outboxTableName   string
+		webhookTableName  string
+		deliveryTableName string
+		batchTableName    string
+		cacheTableName    string
+		manifestTableName string
 	}
 	s3Region        string
 	accessKeyId     string
@@ -22,7 +28,9 @@ type RabbitMQ struct {
 	password string
 	port     string
 	exchange string
-	route    struct {
+	// eventExchange is where job events are published, apart from the exchange whose messages the service consumes.
+	eventExchange string
+	route         struct {
 		job string
 	}
 	queue struct {
@@ -44,9 +52,11 @@ type GRPC struct {
 type Config struct {
 	port       int
 	encryptKey string
-	aws        AWS
-	rabbit     RabbitMQ
-	grpc       GRPC
+	// backfillListedAt runs the one-off migration listing the jobs saved before they were listed, then exits.
+	backfillListedAt bool
+	aws              AWS
+	rabbit           RabbitMQ
+	grpc             GRPC
 }
 
 var (
@@ -60,6 +70,16 @@ func getConfig() Config {
 
 		flag.IntVar(&instance.port, "port", 8080, "Server Port")
 
+		flag.StringVar(&instance.aws.dynamo.tableName, "dynamo-job-table", envString("DYNAMO_JOB_TABLE", "jobs"), "DynamoDB job table name")
+		flag.StringVar(&instance.aws.dynamo.outboxTableName, "dynamo-outbox-table", envString("DYNAMO_OUTBOX_TABLE", "job-outbox"), "DynamoDB job outbox table name")
+		flag.StringVar(&instance.aws.dynamo.webhookTableName, "dynamo-webhook-table", envString("DYNAMO_WEBHOOK_TABLE", "job-webhooks"), "DynamoDB webhook table name")
+		flag.StringVar(&instance.aws.dynamo.deliveryTableName, "dynamo-delivery-table", envString("DYNAMO_DELIVERY_TABLE", "job-webhook-deliveries"), "DynamoDB webhook delivery table name")
+		flag.StringVar(&instance.aws.dynamo.batchTableName, "dynamo-batch-table", envString("DYNAMO_BATCH_TABLE", "job-batches"), "DynamoDB batch table name")
+		flag.StringVar(&instance.aws.dynamo.cacheTableName, "dynamo-cache-table", envString("DYNAMO_CACHE_TABLE", "job-audio-cache"), "DynamoDB table of the audio cached by text and voice")
+		flag.StringVar(&instance.aws.dynamo.manifestTableName, "dynamo-manifest-table", envString("DYNAMO_MANIFEST_TABLE", "job-manifests"), "DynamoDB table of the paragraphs the audio of jobs is assembled from")
+
+		flag.BoolVar(&instance.backfillListedAt, "backfill-listed-at", false, "List the jobs saved before jobs were listed, then exit")
+
 		flag.StringVar(&instance.aws.s3Region, "s3-region", os.Getenv("S3_REGION"), "S3 region")
 		flag.StringVar(&instance.aws.accessKeyId, "aws-access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS access key ID")
 		flag.StringVar(&instance.aws.secretAccessKey, "aws-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key")
@@ -69,6 +89,7 @@ func getConfig() Config {
 		flag.StringVar(&instance.rabbit.password, "rabbit-password", os.Getenv("AMQP_PASSWORD"), "RabbitMQ password")
 		flag.StringVar(&instance.rabbit.port, "rabbit-port", os.Getenv("AMQP_PORT"), "RabbitMQ password")
 		flag.StringVar(&instance.rabbit.exchange, "rabbit-exchange", os.Getenv("EXCHANGE_KEY"), "RabbitMQ exchange name")
+		flag.StringVar(&instance.rabbit.eventExchange, "rabbit-event-exchange", envString("EVENT_EXCHANGE_KEY", "job-events"), "RabbitMQ exchange job status events are published to")
 		flag.StringVar(&instance.rabbit.route.job, "rabbit-job-route", os.Getenv("JOB_ROUTE_KEY"), "RabbitMQ text exchange route key")
 		flag.StringVar(&instance.rabbit.queue.job, "rabbit-job-queue", os.Getenv("JOB_QUEUE_NAME"), "RabbitMQ text exchange queue key")
 
@@ -80,3 +101,10 @@ func getConfig() Config {
 
 	return instance
 }
+
+func envString(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
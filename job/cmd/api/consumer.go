@@ -7,6 +7,7 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/ziliscite/bard_narate/job/internal/domain"
 	"github.com/ziliscite/bard_narate/job/internal/service"
+	"log/slog"
 	"time"
 )
 
@@ -123,6 +124,11 @@ func (c *Consumer) consumeJob(ctx context.Context, msg []byte) error {
 	job.SetStatus(status)
 	job.SetFileKey(req.FileKey)
 	if err := c.js.Update(ctx, job); err != nil {
+		if errors.Is(err, service.ErrCancelled) {
+			// a worker that missed the cancellation, whose message is dropped
+			slog.Info("Ignoring status of cancelled job", "job", job.ID, "status", req.JobStatus)
+			return nil
+		}
 		return err
 	}
 
@@ -2,41 +2,178 @@ package main
 
 import (
 	"context"
+	"errors"
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+	"github.com/ziliscite/bard_narate/job/internal/repository"
 	"github.com/ziliscite/bard_narate/job/internal/service"
 	pb "github.com/ziliscite/bard_narate/job/pkg/protobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const (
+	defaultClaimLimit = 10
+	maxClaimLimit     = 100
+
+	defaultDeliveryLimit = 20
+	maxDeliveryLimit     = 100
+
+	defaultJobLimit = 20
+	maxJobLimit     = 100
 )
 
 type Server struct {
 	c  Config
 	js service.JobService
+	ob service.OutboxService
+	ws service.WebhookService
+	bs service.BatchService
 	pb.UnimplementedJobServiceServer
 }
 
-func NewGRPCServer(c Config, js service.JobService) *Server {
+func NewGRPCServer(c Config, js service.JobService, ob service.OutboxService, ws service.WebhookService, bs service.BatchService) *Server {
 	return &Server{
 		c:  c,
 		js: js,
+		ob: ob,
+		ws: ws,
+		bs: bs,
 	}
 }
 
 func (s *Server) New(ctx context.Context, req *pb.NewJobRequest) (*pb.NewJobResponse, error) {
-	job, err := s.js.New(ctx, req.FileKey)
+	text := domain.Text{
+		FileKey:    req.GetFileKey(),
+		Hash:       req.GetTextHash(),
+		Paragraphs: req.GetParagraphs(),
+	}
+
+	job, err := s.js.New(ctx, req.GetUserId(), text, req.GetPreviousId(), req.GetCallbackUrl(), voiceSettings(req.GetVoice()))
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, service.ErrInvalidCallbackURL):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, service.ErrPreviousNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		default:
+			return nil, err
+		}
 	}
 
 	return &pb.NewJobResponse{
-		Job: &pb.Job{
-			Id:      job.ID,
-			Status:  pb.Status(job.Status),
-			FileKey: job.FileKey,
-		},
+		Job: protoJob(job),
+	}, nil
+}
+
+func (s *Server) NewSplit(ctx context.Context, req *pb.NewSplitJobRequest) (*pb.NewSplitJobResponse, error) {
+	chapters := make([]domain.Chapter, 0, len(req.GetChapters()))
+	for _, c := range req.GetChapters() {
+		if c.GetFileKey() == "" {
+			return nil, status.Error(codes.InvalidArgument, "chapter file key is required")
+		}
+
+		chapters = append(chapters, chapter(c))
+	}
+
+	job, children, err := s.js.NewSplit(ctx, req.GetUserId(), chapters, req.GetPreviousId(), req.GetCallbackUrl(), voiceSettings(req.GetVoice()))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNoChapters), errors.Is(err, service.ErrTooManyChapters),
+			errors.Is(err, service.ErrInvalidCallbackURL):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, service.ErrPreviousNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		default:
+			return nil, err
+		}
+	}
+
+	return &pb.NewSplitJobResponse{
+		Job:      protoJob(job),
+		Chapters: protoJobs(children),
+	}, nil
+}
+
+func (s *Server) NewBatch(ctx context.Context, req *pb.NewBatchRequest) (*pb.NewBatchResponse, error) {
+	entries := make([]domain.BatchEntry, 0, len(req.GetEntries()))
+	for _, e := range req.GetEntries() {
+		entry := domain.BatchEntry{
+			Title: e.GetTitle(),
+			Text: domain.Text{
+				FileKey:    e.GetFileKey(),
+				Hash:       e.GetTextHash(),
+				Paragraphs: e.GetParagraphs(),
+			},
+		}
+
+		for _, c := range e.GetChapters() {
+			if c.GetFileKey() == "" {
+				return nil, status.Error(codes.InvalidArgument, "chapter file key is required")
+			}
+
+			entry.Chapters = append(entry.Chapters, chapter(c))
+		}
+
+		entries = append(entries, entry)
+	}
+
+	batch, jobs, err := s.bs.New(ctx, req.GetUserId(), entries, req.GetCallbackUrl(), voiceSettings(req.GetVoice()))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNoEntries), errors.Is(err, service.ErrTooManyEntries),
+			errors.Is(err, service.ErrInvalidEntry), errors.Is(err, service.ErrTooManyChapters),
+			errors.Is(err, service.ErrInvalidCallbackURL):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		default:
+			return nil, err
+		}
+	}
+
+	return &pb.NewBatchResponse{
+		Batch: protoBatch(batch, jobs),
+	}, nil
+}
+
+func (s *Server) GetBatch(ctx context.Context, req *pb.GetBatchRequest) (*pb.GetBatchResponse, error) {
+	batch, jobs, err := s.bs.Get(ctx, req.GetUserId(), req.GetId())
+	if err != nil {
+		if errors.Is(err, service.ErrBatchNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, err
+	}
+
+	return &pb.GetBatchResponse{
+		Batch: protoBatch(batch, jobs),
+	}, nil
+}
+
+func (s *Server) NewVoiceConversion(ctx context.Context, req *pb.NewVoiceConversionRequest) (*pb.NewVoiceConversionResponse, error) {
+	// without a model key, the audio is converted with the model bundled with the worker
+	if req.GetFileKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "file key is required")
+	}
+
+	job, err := s.js.NewVoiceConversion(ctx, req.GetUserId(), req.GetFileKey(), req.GetModelKey(), req.GetCallbackUrl())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCallbackURL) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, err
+	}
+
+	return &pb.NewVoiceConversionResponse{
+		Job: protoJob(job),
 	}, nil
 }
 
 func (s *Server) Get(ctx context.Context, req *pb.GetJobRequest) (*pb.GetJobResponse, error) {
-	job, err := s.js.Get(ctx, req.Id)
+	job, chapters, err := s.js.GetWithChapters(ctx, req.Id)
 	if err != nil {
+		if errors.Is(err, repository.ErrNotExist) {
+			return nil, status.Error(codes.NotFound, "job not found")
+		}
 		return nil, err
 	}
 
@@ -44,10 +181,359 @@ func (s *Server) Get(ctx context.Context, req *pb.GetJobRequest) (*pb.GetJobResp
 	// cuz if not, then file key should not be returned
 	// or idk; maybe js handle it in the gateway
 	return &pb.GetJobResponse{
-		Job: &pb.Job{
-			Id:      job.ID,
-			Status:  pb.Status(job.Status),
-			FileKey: job.FileKey,
-		},
+		Job:      protoJob(job),
+		Chapters: protoJobs(chapters),
+	}, nil
+}
+
+func (s *Server) CancelJob(ctx context.Context, req *pb.CancelJobRequest) (*pb.CancelJobResponse, error) {
+	job, err := s.js.Cancel(ctx, req.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotCancellable):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, repository.ErrNotExist):
+			return nil, status.Error(codes.NotFound, "job not found")
+		default:
+			return nil, err
+		}
+	}
+
+	return &pb.CancelJobResponse{
+		Job: protoJob(job),
+	}, nil
+}
+
+func (s *Server) DeleteJob(ctx context.Context, req *pb.DeleteJobRequest) (*pb.DeleteJobResponse, error) {
+	unused, err := s.js.Delete(ctx, req.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotDeletable):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, repository.ErrNotExist):
+			return nil, status.Error(codes.NotFound, "job not found")
+		default:
+			return nil, err
+		}
+	}
+
+	return &pb.DeleteJobResponse{
+		UnusedFileKeys: unused,
+	}, nil
+}
+
+func (s *Server) RetryJob(ctx context.Context, req *pb.RetryJobRequest) (*pb.RetryJobResponse, error) {
+	job, err := s.js.Retry(ctx, req.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotRetryable):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, service.ErrTooManyAttempts):
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, repository.ErrNotExist):
+			return nil, status.Error(codes.NotFound, "job not found")
+		default:
+			return nil, err
+		}
+	}
+
+	return &pb.RetryJobResponse{
+		Job: protoJob(job),
+	}, nil
+}
+
+func (s *Server) ListJobs(ctx context.Context, req *pb.ListJobsRequest) (*pb.ListJobsResponse, error) {
+	limit := int(req.GetLimit())
+	switch {
+	case limit == 0:
+		limit = defaultJobLimit
+	case limit > maxJobLimit:
+		limit = maxJobLimit
+	}
+
+	filter := domain.JobFilter{}
+	for _, st := range req.GetStatuses() {
+		if _, ok := pb.Status_name[int32(st)]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown status %d", st)
+		}
+		filter.Statuses = append(filter.Statuses, domain.JobStatus(st))
+	}
+	if after := req.GetCreatedAfter(); after != 0 {
+		filter.CreatedAfter = time.UnixMilli(after)
+	}
+	if before := req.GetCreatedBefore(); before != 0 {
+		filter.CreatedBefore = time.UnixMilli(before)
+	}
+
+	jobs, next, err := s.js.List(ctx, req.GetUserId(), filter, req.GetCursor(), limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, err
+	}
+
+	return &pb.ListJobsResponse{
+		Jobs:       protoJobs(jobs),
+		NextCursor: next,
+	}, nil
+}
+
+func (s *Server) ClaimOutbox(ctx context.Context, req *pb.ClaimOutboxRequest) (*pb.ClaimOutboxResponse, error) {
+	limit := int(req.GetLimit())
+	switch {
+	case limit == 0:
+		limit = defaultClaimLimit
+	case limit > maxClaimLimit:
+		limit = maxClaimLimit
+	}
+
+	claimed, err := s.ob.Claim(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*pb.OutboxEntry, 0, len(claimed))
+	for _, c := range claimed {
+		entries = append(entries, &pb.OutboxEntry{
+			Id:       c.Entry.ID,
+			Job:      protoJob(c.Job),
+			Attempts: uint32(c.Entry.Attempts),
+			Kind:     pb.OutboxKind(c.Entry.Kind),
+			Segments: protoSegments(c.Manifest),
+		})
+	}
+
+	return &pb.ClaimOutboxResponse{
+		Entries: entries,
+	}, nil
+}
+
+func (s *Server) AckOutbox(ctx context.Context, req *pb.AckOutboxRequest) (*pb.AckOutboxResponse, error) {
+	if err := s.ob.Ack(ctx, req.GetIds()); err != nil {
+		return nil, err
+	}
+
+	return &pb.AckOutboxResponse{}, nil
+}
+
+func (s *Server) SetWebhook(ctx context.Context, req *pb.SetWebhookRequest) (*pb.SetWebhookResponse, error) {
+	webhook, err := s.ws.Register(ctx, req.GetUserId(), req.GetUrl())
+	if err != nil {
+		return nil, webhookError(err)
+	}
+
+	return &pb.SetWebhookResponse{
+		Webhook: protoWebhook(webhook),
 	}, nil
 }
+
+func (s *Server) GetWebhook(ctx context.Context, req *pb.GetWebhookRequest) (*pb.GetWebhookResponse, error) {
+	webhook, err := s.ws.Get(ctx, req.GetUserId())
+	if err != nil {
+		return nil, webhookError(err)
+	}
+
+	return &pb.GetWebhookResponse{
+		Webhook: protoWebhook(webhook),
+	}, nil
+}
+
+func (s *Server) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.DeleteWebhookResponse, error) {
+	if err := s.ws.Remove(ctx, req.GetUserId()); err != nil {
+		return nil, webhookError(err)
+	}
+
+	return &pb.DeleteWebhookResponse{}, nil
+}
+
+func (s *Server) ListDeliveries(ctx context.Context, req *pb.ListDeliveriesRequest) (*pb.ListDeliveriesResponse, error) {
+	limit := int(req.GetLimit())
+	switch {
+	case limit == 0:
+		limit = defaultDeliveryLimit
+	case limit > maxDeliveryLimit:
+		limit = maxDeliveryLimit
+	}
+
+	deliveries, err := s.ws.Deliveries(ctx, req.GetUserId(), req.GetJobId(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	pbs := make([]*pb.Delivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		pbs = append(pbs, protoDelivery(d))
+	}
+
+	return &pb.ListDeliveriesResponse{
+		Deliveries: pbs,
+	}, nil
+}
+
+func (s *Server) ReplayDelivery(ctx context.Context, req *pb.ReplayDeliveryRequest) (*pb.ReplayDeliveryResponse, error) {
+	delivery, err := s.ws.Replay(ctx, req.GetUserId(), req.GetId())
+	if err != nil {
+		return nil, webhookError(err)
+	}
+
+	return &pb.ReplayDeliveryResponse{
+		Delivery: protoDelivery(delivery),
+	}, nil
+}
+
+// webhookError maps the errors of the webhook service onto status codes.
+func webhookError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidCallbackURL):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrWebhookNotFound), errors.Is(err, service.ErrDeliveryNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return err
+	}
+}
+
+func protoWebhook(webhook *domain.Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Url:    webhook.URL,
+		Secret: webhook.Secret,
+	}
+}
+
+func protoDelivery(d *domain.Delivery) *pb.Delivery {
+	attempts := make([]*pb.DeliveryAttempt, 0, len(d.Attempts))
+	for _, a := range d.Attempts {
+		attempts = append(attempts, &pb.DeliveryAttempt{
+			At:         a.At.UnixMilli(),
+			StatusCode: uint32(a.StatusCode),
+			Error:      a.Error,
+			DurationMs: a.Duration.Milliseconds(),
+		})
+	}
+
+	return &pb.Delivery{
+		Id:            d.ID,
+		JobId:         d.JobID,
+		Url:           d.URL,
+		Event:         d.Event,
+		Status:        pb.DeliveryStatus(d.Status),
+		Attempts:      attempts,
+		NextAttemptAt: d.NextAttemptAt.UnixMilli(),
+		CreatedAt:     d.CreatedAt.UnixMilli(),
+		Payload:       d.Payload,
+	}
+}
+
+// protoJob maps a domain job onto its wire representation.
+func protoJob(job *domain.Job) *pb.Job {
+	return &pb.Job{
+		Id:         job.ID,
+		UserId:     job.UserID,
+		Status:     pb.Status(job.Status),
+		FileKey:    job.FileKey,
+		ParentId:   job.ParentID,
+		Index:      uint32(job.Index),
+		Title:      job.Title,
+		ChapterIds: job.ChildIDs,
+		Version:    job.Version,
+		Kind:       pb.Kind(job.Kind),
+		ModelKey:   job.ModelKey,
+		CreatedAt:  job.CreatedAt.UnixMilli(),
+		UpdatedAt:  job.UpdatedAt.UnixMilli(),
+		Attempt:    uint32(job.Attempt),
+		Attempts:   protoAttempts(job.Attempts),
+		PreviousId: job.PreviousID,
+		Voice: &pb.VoiceSettings{
+			Voice:    job.Voice.Voice,
+			Speed:    job.Voice.Speed,
+			Language: job.Voice.Language,
+		},
+	}
+}
+
+// protoBatch maps a batch along with its jobs, given in the order of its items.
+func protoBatch(batch *domain.Batch, jobs []*domain.Job) *pb.Batch {
+	items := make([]*pb.BatchItem, 0, len(batch.Items))
+	for i, item := range batch.Items {
+		items = append(items, &pb.BatchItem{
+			Title: item.Title,
+			Job:   protoJob(jobs[i]),
+		})
+	}
+
+	return &pb.Batch{
+		Id:        batch.ID,
+		UserId:    batch.UserID,
+		Items:     items,
+		CreatedAt: batch.CreatedAt.UnixMilli(),
+	}
+}
+
+func protoAttempts(attempts []domain.JobAttempt) []*pb.JobAttempt {
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	pbs := make([]*pb.JobAttempt, 0, len(attempts))
+	for _, a := range attempts {
+		pbs = append(pbs, &pb.JobAttempt{
+			Attempt: uint32(a.Number),
+			Status:  pb.Status(a.Status),
+			EndedAt: a.EndedAt.UnixMilli(),
+		})
+	}
+
+	return pbs
+}
+
+// protoSegments maps the segments of a manifest, a job without one being converted as a whole.
+func protoSegments(manifest *domain.Manifest) []*pb.Segment {
+	if manifest == nil {
+		return nil
+	}
+
+	pbs := make([]*pb.Segment, 0, len(manifest.Segments))
+	for _, s := range manifest.Segments {
+		pbs = append(pbs, &pb.Segment{
+			Key:    s.Key,
+			Reused: s.Reused,
+		})
+	}
+
+	return pbs
+}
+
+// chapter maps a chapter of a request onto the domain.
+func chapter(c *pb.NewChapter) domain.Chapter {
+	return domain.Chapter{
+		Title: c.GetTitle(),
+		Text: domain.Text{
+			FileKey:    c.GetFileKey(),
+			Hash:       c.GetTextHash(),
+			Paragraphs: c.GetParagraphs(),
+		},
+	}
+}
+
+// voiceSettings maps the voice of a request onto the domain, a missing one leaving every choice to the worker.
+func voiceSettings(v *pb.VoiceSettings) domain.VoiceSettings {
+	return domain.VoiceSettings{
+		Voice:    v.GetVoice(),
+		Speed:    v.GetSpeed(),
+		Language: v.GetLanguage(),
+	}
+}
+
+func protoJobs(jobs []*domain.Job) []*pb.Job {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	pbs := make([]*pb.Job, 0, len(jobs))
+	for _, job := range jobs {
+		pbs = append(pbs, protoJob(job))
+	}
+
+	return pbs
+}
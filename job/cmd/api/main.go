@@ -11,6 +11,7 @@ import (
 	"github.com/ziliscite/bard_narate/job/internal/service"
 	pb "github.com/ziliscite/bard_narate/job/pkg/protobuf"
 	"google.golang.org/grpc"
+	"log/slog"
 	"net"
 	"time"
 )
@@ -30,11 +31,50 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	jr := repository.NewJobRepository(dcl, cfg.aws.dynamo.tableName)
+	jr := repository.NewJobRepository(dcl, cfg.aws.dynamo.tableName, cfg.aws.dynamo.outboxTableName, cfg.aws.dynamo.deliveryTableName)
 	if err := jr.AutoMigrate(ctx); err != nil {
 		panic(err)
 	}
 
+	if cfg.backfillListedAt {
+		// a one-off migration, scanning the whole table without the timeout of the others
+		if err := jr.BackfillListedAt(context.Background()); err != nil {
+			panic(err)
+		}
+		slog.Info("Backfilled the listing of jobs")
+		return
+	}
+
+	or := repository.NewOutboxRepository(dcl, cfg.aws.dynamo.outboxTableName)
+	if err := or.AutoMigrate(ctx); err != nil {
+		panic(err)
+	}
+
+	wr := repository.NewWebhookRepository(dcl, cfg.aws.dynamo.webhookTableName)
+	if err := wr.AutoMigrate(ctx); err != nil {
+		panic(err)
+	}
+
+	dr := repository.NewDeliveryRepository(dcl, cfg.aws.dynamo.deliveryTableName)
+	if err := dr.AutoMigrate(ctx); err != nil {
+		panic(err)
+	}
+
+	br := repository.NewBatchRepository(dcl, cfg.aws.dynamo.batchTableName)
+	if err := br.AutoMigrate(ctx); err != nil {
+		panic(err)
+	}
+
+	cr := repository.NewAudioCacheRepository(dcl, cfg.aws.dynamo.cacheTableName)
+	if err := cr.AutoMigrate(ctx); err != nil {
+		panic(err)
+	}
+
+	mr := repository.NewManifestRepository(dcl, cfg.aws.dynamo.manifestTableName)
+	if err := mr.AutoMigrate(ctx); err != nil {
+		panic(err)
+	}
+
 	// get rabbitmq connection
 	conn, err := amqp.Dial(cfg.rabbit.dsn())
 	if err != nil {
@@ -42,7 +82,17 @@ func main() {
 	}
 	defer conn.Close()
 
-	js := service.NewJobService(jr)
+	ep, err := service.NewEventPublisher(conn, cfg.rabbit.eventExchange)
+	if err != nil {
+		panic(err)
+	}
+
+	ws := service.NewWebhookService(wr, dr, service.NewWebhookClient())
+	go ws.Run(context.Background())
+
+	js := service.NewJobService(jr, cr, mr, ep, ws)
+	ob := service.NewOutboxService(or, jr, mr)
+	bs := service.NewBatchService(br, jr, js)
 
 	con, err := NewConsumer(conn, cfg.rabbit.exchange, cfg.rabbit.route.job, cfg.rabbit.queue.job, js)
 	if err != nil {
@@ -61,7 +111,7 @@ func main() {
 	}
 	defer listen.Close()
 
-	grp := NewGRPCServer(cfg, js)
+	grp := NewGRPCServer(cfg, js, ob, ws, bs)
 	srv := grpc.NewServer()
 	pb.RegisterJobServiceServer(srv, grp)
 
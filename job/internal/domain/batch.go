@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Batch is a group of jobs submitted together, one for each document of an upload.
+type Batch struct {
+	ID     string
+	UserID uint64
+	// Items are the documents of the batch, in the order they were uploaded.
+	Items     []BatchItem
+	CreatedAt time.Time
+}
+
+// BatchItem is a document of a batch along with the job converting it.
+type BatchItem struct {
+	// Title is the name the document was uploaded with.
+	Title string
+	JobID string
+}
+
+// BatchEntry is a document submitted in a batch.
+// It is converted by a single job from its file key, or as a split job when it comes as chapters.
+type BatchEntry struct {
+	Title string
+	Text
+	Chapters []Chapter
+}
+
+func NewBatch(userID uint64) *Batch {
+	return &Batch{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Add appends a document converted by the job to the batch.
+func (b *Batch) Add(title, jobID string) {
+	b.Items = append(b.Items, BatchItem{Title: title, JobID: jobID})
+}
+
+// JobIDs returns the IDs of the jobs of the batch, in the order of its documents.
+func (b *Batch) JobIDs() []string {
+	ids := make([]string, 0, len(b.Items))
+	for _, item := range b.Items {
+		ids = append(ids, item.JobID)
+	}
+
+	return ids
+}
+
+// Keep keeps the documents of the batch whose jobs are given, in the order of the batch.
+func (b *Batch) Keep(jobs []*Job) {
+	kept := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		kept[job.ID] = true
+	}
+
+	b.Items = slices.DeleteFunc(b.Items, func(item BatchItem) bool {
+		return !kept[item.JobID]
+	})
+}
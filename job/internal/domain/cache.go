@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// CachedAudio is the audio of a text narrated with some voice settings,
+// shared by every job that narrates the same text the same way.
+type CachedAudio struct {
+	// Key identifies the text and the voice settings it was narrated with, as returned by CacheKey.
+	Key     string
+	FileKey string
+	// RefCount counts the jobs using the audio, which is deleted once the last of them is.
+	RefCount  int
+	CreatedAt time.Time
+}
+
+// NewCachedAudio caches the audio a job converted, the job being its first reference.
+func NewCachedAudio(key, fileKey string) *CachedAudio {
+	return &CachedAudio{
+		Key:       key,
+		FileKey:   fileKey,
+		RefCount:  1,
+		CreatedAt: time.Now(),
+	}
+}
+
+// CacheKey returns the key of the audio of the text with the given hash, narrated with the voice settings.
+func CacheKey(textHash string, voice VoiceSettings) string {
+	h := sha256.New()
+	for _, part := range []string{textHash, voice.Voice, strconv.FormatFloat(voice.Speed, 'g', -1, 64), voice.Language} {
+		h.Write([]byte(strconv.Quote(part)))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CacheKey returns the key of the audio the job converts, empty when the job isn't a text to audio conversion
+// of a known text, whose audio isn't cached.
+func (j *Job) CacheKey() string {
+	if j.Kind != TextToAudio || j.TextHash == "" {
+		return ""
+	}
+
+	return CacheKey(j.TextHash, j.Voice)
+}
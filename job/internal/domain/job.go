@@ -13,20 +13,43 @@ const (
 	Converting
 	Completed
 	Failed
+	// Cancelled jobs were stopped by their user, and stay so whatever the workers report afterwards.
+	Cancelled
 )
 
 func (p JobStatus) String() string {
-	return [...]string{"Pending", "Processing", "Converting", "Completed", "Failed"}[p]
+	return [...]string{"Pending", "Processing", "Converting", "Completed", "Failed", "Cancelled"}[p]
+}
+
+// Finished reports whether the status is final, the job being done with for good.
+func (p JobStatus) Finished() bool {
+	return p == Completed || p == Failed || p == Cancelled
 }
 
 func (p JobStatus) Index() int {
 	return int(p)
 }
 
+// JobKind is the conversion a job performs, which decides the worker its request is published to.
+type JobKind int
+
+const (
+	TextToAudio JobKind = iota
+	VoiceConversion
+)
+
+func (k JobKind) String() string {
+	return [...]string{"TextToAudio", "VoiceConversion"}[k]
+}
+
 type Job struct {
 	ID      string
+	UserID  uint64
 	Status  JobStatus
 	FileKey string
+	// InputKey is the file the job was submitted with, which its retries convert again.
+	// The file key starts out as the same, and is replaced by what the workers report.
+	InputKey string
 	// File key in S3.
 	// Will store the initial file key.
 	// Serve the converted filekey only if status = Completed by giving it a prefix.
@@ -34,20 +57,181 @@ type Job struct {
 	// Maybe we can re-encrypt the initial key with the prefix so that it gives different key.
 	// Instead of "prefix/encrypted_key", it'll be just "encrypted_key"
 
+	// ParentID is the split job a chapter belongs to, and Index its position within it.
+	ParentID string
+	Index    int
+	Title    string
+	// ChildIDs are the chapters of a split job in reading order.
+	// A split job has no file of its own, and its status is derived from its chapters.
+	ChildIDs []string
+
+	// Kind is the conversion the job performs, its file being the text to narrate or the audio whose voice is converted.
+	Kind JobKind
+	// ModelKey is the voice model a voice conversion converts its audio with, empty for the model bundled with the worker.
+	ModelKey string
+
+	// Voice is what the job is narrated with, the chapters of a split job sharing the settings of their job.
+	// It is kept so that the job converts the same way however many times it is retried or rendered again.
+	Voice VoiceSettings
+	// TextHash is the hash of the normalised text a text to audio job narrates, as computed by the gateway.
+	// Along with the voice, it finds the audio of the same text narrated before. It is empty when unknown.
+	TextHash string
+	// PreviousID is the job of the previous version of the document, whose unchanged paragraphs the job reuses.
+	PreviousID string
+
+	// CallbackURL is notified once the job completes or fails, instead of the webhook of the account.
+	// Chapters are never notified of, only the split job they belong to.
+	CallbackURL string
+
+	// Attempt counts the attempts at converting the job, starting from 1 when it is created.
+	Attempt int
+	// Attempts is the history of the previous attempts, oldest first.
+	Attempts []JobAttempt
+
+	// Version counts the saved changes of the job, starting from 1 when it is created.
+	// It orders the status events of the job.
+	Version uint64
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-func NewJob(fileKey string) *Job {
+// VoiceSettings choose how a job is narrated.
+// They are validated against the voice catalog of the gateway before reaching the job service,
+// and zero values leave the choice to the worker.
+type VoiceSettings struct {
+	// Voice is the name of a voice of the worker, as in "af_bella".
+	Voice string
+	// Speed scales the pace of the speech, 1 being the natural pace of the voice.
+	Speed float64
+	// Language is the code of the language the text is read as, as in "a" for American English.
+	Language string
+}
+
+// JobFilter narrows down the jobs listed to a user, zero values leaving them all.
+type JobFilter struct {
+	// Statuses only lists the jobs in one of them.
+	Statuses []JobStatus
+	// CreatedAfter and CreatedBefore only list the jobs created in between, to the millisecond.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// JobAttempt is a past attempt at converting a job, kept in its history once the job is retried.
+type JobAttempt struct {
+	Number int
+	// Status is how the attempt ended.
+	Status  JobStatus
+	EndedAt time.Time
+}
+
+// Text is a text submitted to be narrated.
+type Text struct {
+	FileKey string
+	// Hash is the hash of the normalised text, as in Job.TextHash.
+	Hash string
+	// Paragraphs are the hashes of the paragraphs of the text in reading order, each on a line of its own in the file.
+	// The audio of each paragraph is stored on its own, so that the versions of a document share what didn't change.
+	Paragraphs []string
+}
+
+// Chapter is a part of a document that is converted by a job of its own.
+type Chapter struct {
+	Title string
+	Text
+}
+
+func NewJob(userID uint64, fileKey string) *Job {
 	return &Job{
 		ID:        uuid.NewString(),
+		UserID:    userID,
 		Status:    Pending,
 		FileKey:   fileKey,
+		InputKey:  fileKey,
+		Attempt:   1,
+		Version:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 }
 
+// NewVoiceConversionJob creates a pending job converting the voice of the audio file with the voice model.
+func NewVoiceConversionJob(userID uint64, fileKey, modelKey string) *Job {
+	job := NewJob(userID, fileKey)
+	job.Kind = VoiceConversion
+	job.ModelKey = modelKey
+	return job
+}
+
+// NewSplitJob creates a pending job converted as the given chapters, along with the job of each chapter.
+// Every chapter is narrated with the voice of the job.
+func NewSplitJob(userID uint64, chapters []Chapter, voice VoiceSettings) (*Job, []*Job) {
+	parent := NewJob(userID, "")
+	parent.Voice = voice
+	children := make([]*Job, 0, len(chapters))
+	for i, c := range chapters {
+		child := NewJob(userID, c.FileKey)
+		child.Voice = voice
+		child.ParentID = parent.ID
+		child.Index = i
+		child.Title = c.Title
+		child.TextHash = c.Hash
+		children = append(children, child)
+		parent.ChildIDs = append(parent.ChildIDs, child.ID)
+	}
+
+	return parent, children
+}
+
+// IsSplit reports whether the job is converted as chapters.
+func (j *Job) IsSplit() bool {
+	return len(j.ChildIDs) > 0
+}
+
+// DeriveStatus returns the status of a split job from those of its chapters.
+// It is completed once every chapter is, cancelled as soon as one of them is cancelled,
+// and failed as soon as one of them fails, the chapters that did convert keeping their audio.
+// Until then it is pending while no chapter has been picked up, and processing or converting otherwise.
+func DeriveStatus(chapters []*Job) JobStatus {
+	counts := make(map[JobStatus]int, 6)
+	for _, c := range chapters {
+		counts[c.Status]++
+	}
+
+	switch {
+	case counts[Cancelled] > 0:
+		return Cancelled
+	case counts[Failed] > 0:
+		return Failed
+	case counts[Completed] == len(chapters):
+		return Completed
+	case counts[Pending] == len(chapters):
+		return Pending
+	case counts[Converting] > 0 || counts[Completed] > 0:
+		return Converting
+	default:
+		return Processing
+	}
+}
+
+// Retry records the current attempt in the history of the job, and starts the next one from its input.
+func (j *Job) Retry() {
+	j.Attempts = append(j.Attempts, JobAttempt{
+		Number:  j.Attempt,
+		Status:  j.Status,
+		EndedAt: j.UpdatedAt,
+	})
+	j.Attempt++
+	j.FileKey = j.InputKey
+	j.SetStatus(Pending)
+}
+
+// CompleteFrom completes the job with cached audio, which it then shares with the jobs that narrated the same text.
+func (j *Job) CompleteFrom(audio *CachedAudio) {
+	j.FileKey = audio.FileKey
+	j.SetStatus(Completed)
+}
+
 func (j *Job) SetStatus(status JobStatus) {
 	j.Status = status
 	j.UpdatedAt = time.Now()
@@ -57,3 +241,13 @@ func (j *Job) SetFileKey(fileKey string) {
 	j.FileKey = fileKey
 	j.UpdatedAt = time.Now()
 }
+
+func (j *Job) SetVoice(voice VoiceSettings) {
+	j.Voice = voice
+	j.UpdatedAt = time.Now()
+}
+
+func (j *Job) SetCallbackURL(url string) {
+	j.CallbackURL = url
+	j.UpdatedAt = time.Now()
+}
@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+// Segment is the audio of a paragraph, stored on its own under its key,
+// so that every job narrating the paragraph the same way shares it.
+type Segment struct {
+	// Key identifies the paragraph and the voice settings it is narrated with, as returned by CacheKey.
+	Key string
+	// Reused segments were converted for a previous version of the document, and aren't converted again.
+	Reused bool
+}
+
+// Manifest lists the segments the audio of a job is assembled from, one per paragraph in reading order.
+type Manifest struct {
+	JobID     string
+	Segments  []Segment
+	CreatedAt time.Time
+}
+
+// NewManifest returns the manifest of a job narrating the paragraphs with the given hashes,
+// reusing the segments of a previous version of the document whose keys are given.
+func NewManifest(job *Job, paragraphs []string, previous map[string]bool) *Manifest {
+	segments := make([]Segment, 0, len(paragraphs))
+	for _, p := range paragraphs {
+		key := CacheKey(p, job.Voice)
+		segments = append(segments, Segment{Key: key, Reused: previous[key]})
+	}
+
+	return &Manifest{
+		JobID:     job.ID,
+		Segments:  segments,
+		CreatedAt: time.Now(),
+	}
+}
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxKind is the message an outbox entry publishes.
+type OutboxKind int
+
+const (
+	// OutboxConversion asks a worker to convert the job.
+	OutboxConversion OutboxKind = iota
+	// OutboxCancellation tells the workers to stop converting the job.
+	OutboxCancellation
+)
+
+func (k OutboxKind) String() string {
+	return [...]string{"Conversion", "Cancellation"}[k]
+}
+
+// OutboxEntry records that a job's conversion request, or its cancellation, still has to be published to the queue.
+// It is saved in the same transaction as the job, so a request is never lost between the two.
+type OutboxEntry struct {
+	ID    string
+	JobID string
+	Kind  OutboxKind
+
+	// Attempts counts how many times the entry has been claimed for publishing.
+	Attempts int
+	// NextAttemptAt is when the entry may be claimed again.
+	// Claiming pushes it forward, which both leases the entry and backs off retries.
+	NextAttemptAt time.Time
+	// SentAt is set once the publish is acknowledged, and is nil while the entry is pending.
+	SentAt *time.Time
+
+	CreatedAt time.Time
+}
+
+func NewOutboxEntry(jobID string) *OutboxEntry {
+	now := time.Now()
+	return &OutboxEntry{
+		ID:            uuid.NewString(),
+		JobID:         jobID,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// NewCancellationEntry records that the workers have to be told the job was cancelled.
+func NewCancellationEntry(jobID string) *OutboxEntry {
+	entry := NewOutboxEntry(jobID)
+	entry.Kind = OutboxCancellation
+	return entry
+}
+
+// Claim leases the entry until the given time.
+func (e *OutboxEntry) Claim(until time.Time) {
+	e.Attempts++
+	e.NextAttemptAt = until
+}
@@ -0,0 +1,141 @@
+package domain
+
+import (
+	"crypto/rand"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is where, and how, an account is notified of the jobs it submitted finishing.
+type Webhook struct {
+	UserID uint64
+	// URL receives the notifications of jobs submitted without a callback URL of their own.
+	// It is empty for accounts that only give callback URLs per job.
+	URL string
+	// Secret signs the notifications of the account, whichever URL they go to.
+	Secret string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func NewWebhook(userID uint64, url string) *Webhook {
+	now := time.Now()
+	return &Webhook{
+		UserID:    userID,
+		URL:       url,
+		Secret:    "whsec_" + rand.Text(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func (w *Webhook) SetURL(url string) {
+	w.URL = url
+	w.UpdatedAt = time.Now()
+}
+
+type DeliveryStatus int
+
+const (
+	DeliveryPending DeliveryStatus = iota
+	DeliveryDelivered
+	DeliveryFailed
+)
+
+func (s DeliveryStatus) String() string {
+	return [...]string{"Pending", "Delivered", "Failed"}[s]
+}
+
+// DeliveryAttempt is a single POST of a delivery, as recorded in its log.
+type DeliveryAttempt struct {
+	At time.Time
+	// StatusCode is the status the receiver answered with, or 0 when it couldn't be reached.
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
+
+// Delivery is a notification of a job finishing, sent to a webhook until it is received or given up on.
+type Delivery struct {
+	ID     string
+	UserID uint64
+	JobID  string
+	URL    string
+	// Event is the kind of notification, as in "job.completed".
+	Event   string
+	Payload []byte
+	Status  DeliveryStatus
+
+	// Attempts logs the latest attempts at sending the delivery, oldest first.
+	Attempts []DeliveryAttempt
+	// Tries counts the attempts since the delivery was last queued, which its backoff grows with.
+	Tries int
+	// NextAttemptAt is when a pending delivery is sent next.
+	// Claiming it pushes it forward, which both leases the delivery and backs off retries.
+	NextAttemptAt time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// NewDelivery creates a pending delivery of the event of an attempt at a job.
+// Its ID is derived from the three, so that an event is delivered once however many times it is noticed,
+// and once more for every time the job is retried.
+func NewDelivery(userID uint64, jobID string, attempt int, url, event string, payload []byte) *Delivery {
+	now := time.Now()
+	return &Delivery{
+		ID:            DeliveryID(jobID, attempt, event),
+		UserID:        userID,
+		JobID:         jobID,
+		URL:           url,
+		Event:         event,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// DeliveryID returns the ID of the delivery of the event of an attempt at a job.
+// The first attempt keeps the IDs deliveries had before jobs were retried.
+func DeliveryID(jobID string, attempt int, event string) string {
+	name := jobID + "/" + event
+	if attempt > 1 {
+		name += "/" + strconv.Itoa(attempt)
+	}
+
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
+}
+
+// Claim leases the delivery until the given time.
+func (d *Delivery) Claim(until time.Time) {
+	d.NextAttemptAt = until
+}
+
+// Record logs an attempt, keeping the latest maxLogged of them.
+func (d *Delivery) Record(attempt DeliveryAttempt, maxLogged int) {
+	d.Tries++
+	d.Attempts = append(d.Attempts, attempt)
+	if len(d.Attempts) > maxLogged {
+		d.Attempts = d.Attempts[len(d.Attempts)-maxLogged:]
+	}
+	d.UpdatedAt = time.Now()
+}
+
+func (d *Delivery) SetStatus(status DeliveryStatus) {
+	d.Status = status
+	d.UpdatedAt = time.Now()
+}
+
+// Replay queues the delivery to be sent again now, whether it was received or given up on.
+// Its log is kept, and its backoff starts over.
+func (d *Delivery) Replay() {
+	d.Status = DeliveryPending
+	d.Tries = 0
+	d.NextAttemptAt = time.Now()
+	d.UpdatedAt = d.NextAttemptAt
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type BatchDTO struct {
+	ID        string         `dynamodbav:"ID"`
+	UserID    uint64         `dynamodbav:"UserID"`
+	Items     []BatchItemDTO `dynamodbav:"Items"`
+	CreatedAt time.Time      `dynamodbav:"CreatedAt"`
+}
+
+type BatchItemDTO struct {
+	Title string `dynamodbav:"Title"`
+	JobID string `dynamodbav:"JobID"`
+}
+
+func NewBatchDTO(batch *domain.Batch) BatchDTO {
+	items := make([]BatchItemDTO, 0, len(batch.Items))
+	for _, item := range batch.Items {
+		items = append(items, BatchItemDTO{Title: item.Title, JobID: item.JobID})
+	}
+
+	return BatchDTO{
+		ID:        batch.ID,
+		UserID:    batch.UserID,
+		Items:     items,
+		CreatedAt: batch.CreatedAt,
+	}
+}
+
+func (b BatchDTO) ToBatch() *domain.Batch {
+	items := make([]domain.BatchItem, 0, len(b.Items))
+	for _, item := range b.Items {
+		items = append(items, domain.BatchItem{Title: item.Title, JobID: item.JobID})
+	}
+
+	return &domain.Batch{
+		ID:        b.ID,
+		UserID:    b.UserID,
+		Items:     items,
+		CreatedAt: b.CreatedAt,
+	}
+}
+
+type BatchRepository interface {
+	// Save saves a new batch, whose jobs are saved on their own beforehand.
+	Save(ctx context.Context, batch *domain.Batch) error
+	// Load returns a batch, or ErrNotExist.
+	Load(ctx context.Context, id string) (*domain.Batch, error)
+	JobMigrator
+}
+
+type batchRepository struct {
+	t  string
+	cl *dynamodb.Client
+}
+
+func NewBatchRepository(dynamodbClient *dynamodb.Client, tableName string) BatchRepository {
+	return &batchRepository{
+		cl: dynamodbClient,
+		t:  tableName,
+	}
+}
+
+func (b *batchRepository) AutoMigrate(ctx context.Context) error {
+	exists, err := b.TableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return b.CreateTable(ctx)
+}
+
+func (b *batchRepository) TableExists(ctx context.Context) (bool, error) {
+	if _, err := b.cl.DescribeTable(
+		ctx, &dynamodb.DescribeTableInput{TableName: aws.String(b.t)},
+	); err != nil {
+		var notFoundEx *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFoundEx):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (b *batchRepository) CreateTable(ctx context.Context) error {
+	if _, err := b.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(b.t),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String("ID"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("ID"),
+			KeyType:       types.KeyTypeHash,
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}); err != nil {
+		return err
+	}
+
+	if err := dynamodb.NewTableExistsWaiter(b.cl).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(b.t),
+	}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	return nil
+}
+
+func (b *batchRepository) Save(ctx context.Context, batch *domain.Batch) error {
+	item, err := attributevalue.MarshalMap(NewBatchDTO(batch))
+	if err != nil {
+		return fmt.Errorf("failed to marshal batchDTO: %w", err)
+	}
+
+	if _, err = b.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(b.t),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrAlreadyExists
+		default:
+			return fmt.Errorf("failed to put batch: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *batchRepository) Load(ctx context.Context, id string) (*domain.Batch, error) {
+	result, err := b.cl.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(b.t),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, ErrNotExist
+	}
+
+	var dto BatchDTO
+	if err = attributevalue.UnmarshalMap(result.Item, &dto); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal batchDTO: %w", err)
+	}
+
+	return dto.ToBatch(), nil
+}
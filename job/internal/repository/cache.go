@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type CachedAudioDTO struct {
+	Key       string    `dynamodbav:"Key"`
+	FileKey   string    `dynamodbav:"FileKey"`
+	RefCount  int       `dynamodbav:"RefCount"`
+	CreatedAt time.Time `dynamodbav:"CreatedAt"`
+}
+
+func NewCachedAudioDTO(audio *domain.CachedAudio) CachedAudioDTO {
+	return CachedAudioDTO{
+		Key:       audio.Key,
+		FileKey:   audio.FileKey,
+		RefCount:  audio.RefCount,
+		CreatedAt: audio.CreatedAt,
+	}
+}
+
+func (c CachedAudioDTO) ToCachedAudio() *domain.CachedAudio {
+	return &domain.CachedAudio{
+		Key:       c.Key,
+		FileKey:   c.FileKey,
+		RefCount:  c.RefCount,
+		CreatedAt: c.CreatedAt,
+	}
+}
+
+// AudioCacheRepository indexes converted audio by the text and voice settings it was narrated with,
+// counting the jobs that use it.
+type AudioCacheRepository interface {
+	// Acquire counts one more job using the audio cached under the key, and returns it.
+	// It returns ErrNotExist if no audio is cached under the key.
+	Acquire(ctx context.Context, key string) (*domain.CachedAudio, error)
+	// Put caches new audio, returning ErrAlreadyExists if the key already has some.
+	Put(ctx context.Context, audio *domain.CachedAudio) error
+	// Release counts one job less using the audio cached under the key, removing it from the cache once no job does.
+	// It reports whether the file is no longer used, which is also the case when the cache holds another file
+	// under the key, or none, the file then belonging to the released job alone.
+	Release(ctx context.Context, key, fileKey string) (bool, error)
+	JobMigrator
+}
+
+type audioCacheRepository struct {
+	t  string
+	cl *dynamodb.Client
+}
+
+func NewAudioCacheRepository(dynamodbClient *dynamodb.Client, tableName string) AudioCacheRepository {
+	return &audioCacheRepository{
+		cl: dynamodbClient,
+		t:  tableName,
+	}
+}
+
+func (a *audioCacheRepository) AutoMigrate(ctx context.Context) error {
+	exists, err := a.TableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return a.CreateTable(ctx)
+}
+
+func (a *audioCacheRepository) TableExists(ctx context.Context) (bool, error) {
+	if _, err := a.cl.DescribeTable(
+		ctx, &dynamodb.DescribeTableInput{TableName: aws.String(a.t)},
+	); err != nil {
+		var notFoundEx *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFoundEx):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (a *audioCacheRepository) CreateTable(ctx context.Context) error {
+	if _, err := a.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(a.t),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String("Key"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("Key"),
+			KeyType:       types.KeyTypeHash,
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}); err != nil {
+		return err
+	}
+
+	if err := dynamodb.NewTableExistsWaiter(a.cl).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(a.t),
+	}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	return nil
+}
+
+func (a *audioCacheRepository) Acquire(ctx context.Context, key string) (*domain.CachedAudio, error) {
+	result, err := a.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(a.t),
+		Key: map[string]types.AttributeValue{
+			"Key": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression:    aws.String("ADD #refCount :one"),
+		ConditionExpression: aws.String("attribute_exists(#key)"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":      "Key",
+			"#refCount": "RefCount",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return nil, ErrNotExist
+		default:
+			return nil, fmt.Errorf("failed to acquire cached audio: %w", err)
+		}
+	}
+
+	var dto CachedAudioDTO
+	if err = attributevalue.UnmarshalMap(result.Attributes, &dto); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cachedAudioDTO: %w", err)
+	}
+
+	return dto.ToCachedAudio(), nil
+}
+
+func (a *audioCacheRepository) Put(ctx context.Context, audio *domain.CachedAudio) error {
+	item, err := attributevalue.MarshalMap(NewCachedAudioDTO(audio))
+	if err != nil {
+		return fmt.Errorf("failed to marshal cachedAudioDTO: %w", err)
+	}
+
+	if _, err = a.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(a.t),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]string{
+			"#key": "Key",
+		},
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrAlreadyExists
+		default:
+			return fmt.Errorf("failed to put cached audio: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (a *audioCacheRepository) Release(ctx context.Context, key, fileKey string) (bool, error) {
+	result, err := a.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(a.t),
+		Key: map[string]types.AttributeValue{
+			"Key": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression:    aws.String("ADD #refCount :minusOne"),
+		ConditionExpression: aws.String("#fileKey = :fileKey"),
+		ExpressionAttributeNames: map[string]string{
+			"#fileKey":  "FileKey",
+			"#refCount": "RefCount",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":fileKey":  &types.AttributeValueMemberS{Value: fileKey},
+			":minusOne": &types.AttributeValueMemberN{Value: "-1"},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return true, nil
+		default:
+			return false, fmt.Errorf("failed to release cached audio: %w", err)
+		}
+	}
+
+	var refCount int
+	if err = attributevalue.Unmarshal(result.Attributes["RefCount"], &refCount); err != nil {
+		return false, fmt.Errorf("failed to unmarshal reference count: %w", err)
+	}
+
+	if refCount > 0 {
+		return false, nil
+	}
+
+	// a job that acquired the audio meanwhile keeps it cached
+	if _, err = a.cl.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(a.t),
+		Key: map[string]types.AttributeValue{
+			"Key": &types.AttributeValueMemberS{Value: key},
+		},
+		ConditionExpression: aws.String("#refCount <= :zero"),
+		ExpressionAttributeNames: map[string]string{
+			"#refCount": "RefCount",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return false, nil
+		default:
+			return false, fmt.Errorf("failed to delete cached audio: %w", err)
+		}
+	}
+
+	return true, nil
+}
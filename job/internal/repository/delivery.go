@@ -0,0 +1,416 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// userIndex orders the deliveries, and the jobs, of each user by when they were created.
+const userIndex = "UserIndex"
+
+type DeliveryAttemptDTO struct {
+	At         time.Time `dynamodbav:"At"`
+	StatusCode int       `dynamodbav:"StatusCode"`
+	Error      string    `dynamodbav:"Error,omitempty"`
+	Duration   int64     `dynamodbav:"Duration"` // milliseconds
+}
+
+type DeliveryDTO struct {
+	ID            string                `dynamodbav:"ID"`
+	UserID        uint64                `dynamodbav:"UserID"`
+	JobID         string                `dynamodbav:"JobID"`
+	URL           string                `dynamodbav:"URL"`
+	Event         string                `dynamodbav:"Event"`
+	Payload       []byte                `dynamodbav:"Payload"`
+	Status        domain.DeliveryStatus `dynamodbav:"Status"`
+	Pending       string                `dynamodbav:"Pending,omitempty"`
+	Attempts      []DeliveryAttemptDTO  `dynamodbav:"Attempts"`
+	Tries         int                   `dynamodbav:"Tries"`
+	NextAttemptAt int64                 `dynamodbav:"NextAttemptAt"` // unix milliseconds, the sort key of pendingIndex
+	CreatedAt     int64                 `dynamodbav:"CreatedAt"`     // unix milliseconds, the sort key of userIndex
+	UpdatedAt     time.Time             `dynamodbav:"UpdatedAt"`
+}
+
+func NewDeliveryDTO(delivery *domain.Delivery) DeliveryDTO {
+	dto := DeliveryDTO{
+		ID:            delivery.ID,
+		UserID:        delivery.UserID,
+		JobID:         delivery.JobID,
+		URL:           delivery.URL,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        delivery.Status,
+		Attempts:      make([]DeliveryAttemptDTO, 0, len(delivery.Attempts)),
+		Tries:         delivery.Tries,
+		NextAttemptAt: delivery.NextAttemptAt.UnixMilli(),
+		CreatedAt:     delivery.CreatedAt.UnixMilli(),
+		UpdatedAt:     delivery.UpdatedAt,
+	}
+
+	for _, a := range delivery.Attempts {
+		dto.Attempts = append(dto.Attempts, DeliveryAttemptDTO{
+			At:         a.At,
+			StatusCode: a.StatusCode,
+			Error:      a.Error,
+			Duration:   a.Duration.Milliseconds(),
+		})
+	}
+
+	if delivery.Status == domain.DeliveryPending {
+		dto.Pending = pendingPartition
+	}
+
+	return dto
+}
+
+func (d DeliveryDTO) ToDelivery() *domain.Delivery {
+	delivery := &domain.Delivery{
+		ID:            d.ID,
+		UserID:        d.UserID,
+		JobID:         d.JobID,
+		URL:           d.URL,
+		Event:         d.Event,
+		Payload:       d.Payload,
+		Status:        d.Status,
+		Attempts:      make([]domain.DeliveryAttempt, 0, len(d.Attempts)),
+		Tries:         d.Tries,
+		NextAttemptAt: time.UnixMilli(d.NextAttemptAt),
+		CreatedAt:     time.UnixMilli(d.CreatedAt),
+		UpdatedAt:     d.UpdatedAt,
+	}
+
+	for _, a := range d.Attempts {
+		delivery.Attempts = append(delivery.Attempts, domain.DeliveryAttempt{
+			At:         a.At,
+			StatusCode: a.StatusCode,
+			Error:      a.Error,
+			Duration:   time.Duration(a.Duration) * time.Millisecond,
+		})
+	}
+
+	return delivery
+}
+
+type DeliveryReader interface {
+	// Load returns a delivery, or ErrNotExist.
+	Load(ctx context.Context, id string) (*domain.Delivery, error)
+	// Due returns up to limit pending deliveries whose next attempt is at or before now, oldest first.
+	Due(ctx context.Context, now time.Time, limit int) ([]*domain.Delivery, error)
+	// ListByUser returns up to limit deliveries of the user, newest first.
+	// When jobID is not empty, only the deliveries of that job are returned.
+	ListByUser(ctx context.Context, userID uint64, jobID string, limit int) ([]*domain.Delivery, error)
+}
+
+type DeliveryWriter interface {
+	// Save saves a new delivery, returning ErrAlreadyExists if one with the same ID was saved before.
+	Save(ctx context.Context, delivery *domain.Delivery) error
+	// Claim leases a delivery until the given time.
+	// It returns ErrAlreadyClaimed if another dispatcher claimed the delivery since it was read.
+	Claim(ctx context.Context, delivery *domain.Delivery, until time.Time) error
+	// Update saves the status, log and schedule of a delivery.
+	Update(ctx context.Context, delivery *domain.Delivery) error
+}
+
+type DeliveryRepository interface {
+	DeliveryReader
+	DeliveryWriter
+	JobMigrator
+}
+
+type deliveryRepository struct {
+	t  string
+	cl *dynamodb.Client
+}
+
+func NewDeliveryRepository(dynamodbClient *dynamodb.Client, tableName string) DeliveryRepository {
+	return &deliveryRepository{
+		cl: dynamodbClient,
+		t:  tableName,
+	}
+}
+
+func (d *deliveryRepository) AutoMigrate(ctx context.Context) error {
+	exists, err := d.TableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return d.CreateTable(ctx)
+}
+
+func (d *deliveryRepository) TableExists(ctx context.Context) (bool, error) {
+	if _, err := d.cl.DescribeTable(
+		ctx, &dynamodb.DescribeTableInput{TableName: aws.String(d.t)},
+	); err != nil {
+		var notFoundEx *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFoundEx):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (d *deliveryRepository) CreateTable(ctx context.Context) error {
+	if _, err := d.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(d.t),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String("ID"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}, {
+			AttributeName: aws.String("Pending"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}, {
+			AttributeName: aws.String("NextAttemptAt"),
+			AttributeType: types.ScalarAttributeTypeN,
+		}, {
+			AttributeName: aws.String("UserID"),
+			AttributeType: types.ScalarAttributeTypeN,
+		}, {
+			AttributeName: aws.String("CreatedAt"),
+			AttributeType: types.ScalarAttributeTypeN,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("ID"),
+			KeyType:       types.KeyTypeHash,
+		}},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(pendingIndex),
+			KeySchema: []types.KeySchemaElement{{
+				AttributeName: aws.String("Pending"),
+				KeyType:       types.KeyTypeHash,
+			}, {
+				AttributeName: aws.String("NextAttemptAt"),
+				KeyType:       types.KeyTypeRange,
+			}},
+			Projection: &types.Projection{
+				ProjectionType: types.ProjectionTypeAll,
+			},
+		}, {
+			IndexName: aws.String(userIndex),
+			KeySchema: []types.KeySchemaElement{{
+				AttributeName: aws.String("UserID"),
+				KeyType:       types.KeyTypeHash,
+			}, {
+				AttributeName: aws.String("CreatedAt"),
+				KeyType:       types.KeyTypeRange,
+			}},
+			Projection: &types.Projection{
+				ProjectionType: types.ProjectionTypeAll,
+			},
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}); err != nil {
+		return err
+	}
+
+	if err := dynamodb.NewTableExistsWaiter(d.cl).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(d.t),
+	}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	return nil
+}
+
+func (d *deliveryRepository) Save(ctx context.Context, delivery *domain.Delivery) error {
+	item, err := attributevalue.MarshalMap(NewDeliveryDTO(delivery))
+	if err != nil {
+		return fmt.Errorf("failed to marshal deliveryDTO: %w", err)
+	}
+
+	if _, err = d.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.t),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrAlreadyExists
+		default:
+			return fmt.Errorf("failed to put delivery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (d *deliveryRepository) Load(ctx context.Context, id string) (*domain.Delivery, error) {
+	result, err := d.cl.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.t),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, ErrNotExist
+	}
+
+	var dto DeliveryDTO
+	if err = attributevalue.UnmarshalMap(result.Item, &dto); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deliveryDTO: %w", err)
+	}
+
+	return dto.ToDelivery(), nil
+}
+
+func (d *deliveryRepository) Due(ctx context.Context, now time.Time, limit int) ([]*domain.Delivery, error) {
+	result, err := d.cl.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(d.t),
+		IndexName:              aws.String(pendingIndex),
+		KeyConditionExpression: aws.String("#pending = :pending AND #nextAttemptAt <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#pending":       "Pending",
+			"#nextAttemptAt": "NextAttemptAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: pendingPartition},
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+		},
+		Limit: aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending deliveries: %w", err)
+	}
+
+	return toDeliveries(result.Items)
+}
+
+func (d *deliveryRepository) ListByUser(ctx context.Context, userID uint64, jobID string, limit int) ([]*domain.Delivery, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.t),
+		IndexName:              aws.String(userIndex),
+		KeyConditionExpression: aws.String("#userID = :userID"),
+		ExpressionAttributeNames: map[string]string{
+			"#userID": "UserID",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userID": &types.AttributeValueMemberN{Value: strconv.FormatUint(userID, 10)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	if jobID != "" {
+		input.FilterExpression = aws.String("#jobID = :jobID")
+		input.ExpressionAttributeNames["#jobID"] = "JobID"
+		input.ExpressionAttributeValues[":jobID"] = &types.AttributeValueMemberS{Value: jobID}
+	}
+
+	// the limit applies before the filter, so filtered pages may come back short of it
+	var deliveries []*domain.Delivery
+	for {
+		result, err := d.cl.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query deliveries: %w", err)
+		}
+
+		page, err := toDeliveries(result.Items)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, page...)
+		if len(deliveries) >= limit || result.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+func (d *deliveryRepository) Claim(ctx context.Context, delivery *domain.Delivery, until time.Time) error {
+	previous := delivery.NextAttemptAt.UnixMilli()
+
+	if _, err := d.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.t),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: delivery.ID},
+		},
+		UpdateExpression:    aws.String("SET #nextAttemptAt = :until"),
+		ConditionExpression: aws.String("attribute_exists(#pending) AND #nextAttemptAt = :previous"),
+		ExpressionAttributeNames: map[string]string{
+			"#pending":       "Pending",
+			"#nextAttemptAt": "NextAttemptAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until":    &types.AttributeValueMemberN{Value: strconv.FormatInt(until.UnixMilli(), 10)},
+			":previous": &types.AttributeValueMemberN{Value: strconv.FormatInt(previous, 10)},
+		},
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrAlreadyClaimed
+		default:
+			return fmt.Errorf("failed to claim delivery: %w", err)
+		}
+	}
+
+	delivery.Claim(until)
+	return nil
+}
+
+func (d *deliveryRepository) Update(ctx context.Context, delivery *domain.Delivery) error {
+	item, err := attributevalue.MarshalMap(NewDeliveryDTO(delivery))
+	if err != nil {
+		return fmt.Errorf("failed to marshal deliveryDTO: %w", err)
+	}
+
+	if _, err = d.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.t),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrNotExist
+		default:
+			return fmt.Errorf("failed to update delivery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func toDeliveries(items []map[string]types.AttributeValue) ([]*domain.Delivery, error) {
+	var dtos []DeliveryDTO
+	if err := attributevalue.UnmarshalListOfMaps(items, &dtos); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deliveries: %w", err)
+	}
+
+	deliveries := make([]*domain.Delivery, 0, len(dtos))
+	for _, dto := range dtos {
+		deliveries = append(deliveries, dto.ToDelivery())
+	}
+
+	return deliveries, nil
+}
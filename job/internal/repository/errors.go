@@ -0,0 +1,12 @@
+package repository
+
+import "fmt"
+
+var (
+	ErrNotExist       = fmt.Errorf("does not exist")
+	ErrAlreadyClaimed = fmt.Errorf("already claimed")
+	ErrStatusChanged  = fmt.Errorf("status changed")
+	ErrAlreadyExists  = fmt.Errorf("already exists")
+	ErrInvalidCursor  = fmt.Errorf("invalid cursor")
+	ErrCancelled      = fmt.Errorf("cancelled")
+)
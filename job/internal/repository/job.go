@@ -2,8 +2,13 @@ package repository
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ziliscite/bard_narate/job/internal/domain"
@@ -14,27 +19,189 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+const (
+	// maxTransactItems is the most items a DynamoDB transaction may write.
+	maxTransactItems = 100
+	// maxBatchGetItems is the most items a DynamoDB batch get may read.
+	maxBatchGetItems = 100
+)
+
+var (
+	// userIndexAttributes are the keys of userIndex on the job table.
+	userIndexAttributes = []types.AttributeDefinition{{
+		AttributeName: aws.String("UserID"),
+		AttributeType: types.ScalarAttributeTypeN,
+	}, {
+		AttributeName: aws.String("ListedAt"),
+		AttributeType: types.ScalarAttributeTypeN,
+	}}
+
+	userJobIndex = types.GlobalSecondaryIndex{
+		IndexName: aws.String(userIndex),
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("UserID"),
+			KeyType:       types.KeyTypeHash,
+		}, {
+			AttributeName: aws.String("ListedAt"),
+			KeyType:       types.KeyTypeRange,
+		}},
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeAll,
+		},
+	}
+)
+
 type JobDTO struct {
-	ID        string    `dynamodbav:"ID"`
-	Status    string    `dynamodbav:"Status"`
-	FileKey   string    `dynamodbav:"FileKey"`
-	CreatedAt time.Time `dynamodbav:"CreatedAt"`
-	UpdatedAt time.Time `dynamodbav:"UpdatedAt"`
+	ID          string    `dynamodbav:"ID"`
+	UserID      uint64    `dynamodbav:"UserID"`
+	Status      string    `dynamodbav:"Status"`
+	FileKey     string    `dynamodbav:"FileKey"`
+	ParentID    string    `dynamodbav:"ParentID,omitempty"`
+	Index       int       `dynamodbav:"Index,omitempty"`
+	Title       string    `dynamodbav:"Title,omitempty"`
+	ChildIDs    []string  `dynamodbav:"ChildIDs,omitempty"`
+	Kind        string    `dynamodbav:"Kind,omitempty"`
+	ModelKey    string    `dynamodbav:"ModelKey,omitempty"`
+	Voice       string    `dynamodbav:"Voice,omitempty"`
+	Speed       float64   `dynamodbav:"Speed,omitempty"`
+	Language    string    `dynamodbav:"Language,omitempty"`
+	TextHash    string    `dynamodbav:"TextHash,omitempty"`
+	PreviousID  string    `dynamodbav:"PreviousID,omitempty"`
+	CallbackURL string    `dynamodbav:"CallbackURL,omitempty"`
+	Version     uint64    `dynamodbav:"Version"`
+	CreatedAt   time.Time `dynamodbav:"CreatedAt"`
+	UpdatedAt   time.Time `dynamodbav:"UpdatedAt"`
+
+	// ListedAt is the creation of the job in unix milliseconds, the sort key of userIndex.
+	// Chapters go without, so that the index only lists the jobs users submitted.
+	ListedAt int64 `dynamodbav:"ListedAt,omitempty"`
+
+	// jobs saved before they could be retried have no input key, and are on their first attempt
+	InputKey string          `dynamodbav:"InputKey,omitempty"`
+	Attempt  int             `dynamodbav:"Attempt,omitempty"`
+	Attempts []JobAttemptDTO `dynamodbav:"Attempts,omitempty"`
+}
+
+type JobAttemptDTO struct {
+	Number  int       `dynamodbav:"Number"`
+	Status  string    `dynamodbav:"Status"`
+	EndedAt time.Time `dynamodbav:"EndedAt"`
 }
 
 func NewJobDTO(job *domain.Job) JobDTO {
-	return JobDTO{
-		ID:        job.ID,
-		Status:    job.Status.String(),
-		FileKey:   job.FileKey,
-		CreatedAt: job.CreatedAt,
-		UpdatedAt: job.UpdatedAt,
+	dto := JobDTO{
+		ID:          job.ID,
+		UserID:      job.UserID,
+		Status:      job.Status.String(),
+		FileKey:     job.FileKey,
+		ParentID:    job.ParentID,
+		Index:       job.Index,
+		Title:       job.Title,
+		ChildIDs:    job.ChildIDs,
+		Kind:        job.Kind.String(),
+		ModelKey:    job.ModelKey,
+		Voice:       job.Voice.Voice,
+		Speed:       job.Voice.Speed,
+		Language:    job.Voice.Language,
+		TextHash:    job.TextHash,
+		PreviousID:  job.PreviousID,
+		CallbackURL: job.CallbackURL,
+		Version:     job.Version,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	}
+
+	if job.ParentID == "" {
+		dto.ListedAt = job.CreatedAt.UnixMilli()
 	}
+
+	dto.InputKey = job.InputKey
+	dto.Attempt = job.Attempt
+	dto.Attempts = newJobAttemptDTOs(job.Attempts)
+
+	return dto
+}
+
+func newJobAttemptDTOs(attempts []domain.JobAttempt) []JobAttemptDTO {
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	dtos := make([]JobAttemptDTO, len(attempts))
+	for i, a := range attempts {
+		dtos[i] = JobAttemptDTO{
+			Number:  a.Number,
+			Status:  a.Status.String(),
+			EndedAt: a.EndedAt,
+		}
+	}
+
+	return dtos
 }
 
 func (j JobDTO) ToJob() (*domain.Job, error) {
+	status, err := parseJobStatus(j.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	// jobs saved before voice conversions have no kind
+	var kind domain.JobKind
+	switch j.Kind {
+	case "", "TextToAudio":
+		kind = domain.TextToAudio
+	case "VoiceConversion":
+		kind = domain.VoiceConversion
+	default:
+		return nil, fmt.Errorf("unknown JobKind: %s", j.Kind)
+	}
+
+	attempt := max(j.Attempt, 1)
+	var attempts []domain.JobAttempt
+	for _, a := range j.Attempts {
+		attemptStatus, err := parseJobStatus(a.Status)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, domain.JobAttempt{
+			Number:  a.Number,
+			Status:  attemptStatus,
+			EndedAt: a.EndedAt,
+		})
+	}
+
+	return &domain.Job{
+		ID:       j.ID,
+		UserID:   j.UserID,
+		Status:   status,
+		FileKey:  j.FileKey,
+		InputKey: j.InputKey,
+		ParentID: j.ParentID,
+		Index:    j.Index,
+		Title:    j.Title,
+		ChildIDs: j.ChildIDs,
+		Kind:     kind,
+		ModelKey: j.ModelKey,
+		Voice: domain.VoiceSettings{
+			Voice:    j.Voice,
+			Speed:    j.Speed,
+			Language: j.Language,
+		},
+		TextHash:    j.TextHash,
+		PreviousID:  j.PreviousID,
+		CallbackURL: j.CallbackURL,
+		Attempt:     attempt,
+		Attempts:    attempts,
+		Version:     j.Version,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+	}, nil
+}
+
+func parseJobStatus(s string) (domain.JobStatus, error) {
 	var status domain.JobStatus
-	switch j.Status {
+	switch s {
 	case "Pending":
 		status = domain.Pending
 	case "Processing":
@@ -45,16 +212,13 @@ func (j JobDTO) ToJob() (*domain.Job, error) {
 		status = domain.Completed
 	case "Failed":
 		status = domain.Failed
+	case "Cancelled":
+		status = domain.Cancelled
 	default:
-		return nil, fmt.Errorf("unknown JobStatus: %s", j.Status)
+		return 0, fmt.Errorf("unknown JobStatus: %s", s)
 	}
 
-	return &domain.Job{
-		ID:        j.ID,
-		Status:    status,
-		CreatedAt: j.CreatedAt,
-		UpdatedAt: j.UpdatedAt,
-	}, nil
+	return status, nil
 }
 
 // JobMigrator is an interface for migrating the job table
@@ -64,17 +228,50 @@ type JobMigrator interface {
 	CreateTable(ctx context.Context) error
 }
 
+// JobWriter saves the webhook delivery of a job finishing, if any, in the same transaction as its status,
+// so that finished jobs are always notified of. A delivery saved before, by an earlier save of the job
+// with the same status, is left as it is.
 type JobWriter interface {
-	Save(ctx context.Context, job *domain.Job) error
-	Update(ctx context.Context, job *domain.Job) error
+	// Save saves a new job, along with its delivery if it is created finished.
+	Save(ctx context.Context, job *domain.Job, delivery *domain.Delivery) error
+	// SaveWithOutbox saves a new job together with its outbox entry in a single transaction.
+	SaveWithOutbox(ctx context.Context, job *domain.Job, entry *domain.OutboxEntry) error
+	// SaveSplit saves a new split job together with its chapters, their outbox entries and the delivery of the job
+	// in a single transaction.
+	SaveSplit(ctx context.Context, job *domain.Job, chapters []*domain.Job, entries []*domain.OutboxEntry, delivery *domain.Delivery) error
+	// Update saves the status and file key of a job along with its delivery, bumping its version.
+	// It returns ErrCancelled if the job was cancelled, which no update undoes.
+	Update(ctx context.Context, job *domain.Job, delivery *domain.Delivery) error
+	// Cancel saves jobs as cancelled together with their outbox entries and the delivery of the first job
+	// in a single transaction, bumping their versions.
+	// It returns ErrStatusChanged if any job was saved since it was loaded.
+	Cancel(ctx context.Context, jobs []*domain.Job, entries []*domain.OutboxEntry, delivery *domain.Delivery) error
+	// Retry saves the next attempt at jobs together with their outbox entries in a single transaction,
+	// bumping their versions. It returns ErrStatusChanged if any job was saved since it was loaded.
+	Retry(ctx context.Context, jobs []*domain.Job, entries []*domain.OutboxEntry) error
+	// UpdateStatusFrom updates the status of a job along with its delivery, provided it still is the given one,
+	// bumping its version. It returns ErrStatusChanged otherwise.
+	UpdateStatusFrom(ctx context.Context, job *domain.Job, from domain.JobStatus, delivery *domain.Delivery) error
 }
 
 type JobReader interface {
 	Load(ctx context.Context, id string) (*domain.Job, error)
+	// LoadMany loads the jobs with the given ids, in the same order.
+	// It returns ErrNotExist if any of them is missing.
+	LoadMany(ctx context.Context, ids []string) ([]*domain.Job, error)
+	// LoadExisting loads those of the jobs with the given ids that exist, in the same order, skipping deleted ones.
+	LoadExisting(ctx context.Context, ids []string) ([]*domain.Job, error)
+	// ListByUser returns up to limit jobs of the user, newest first, along with the cursor of the next page,
+	// which is empty on the last one. Chapters are left out, as they belong to a split job that is listed.
+	// It returns ErrInvalidCursor if the cursor wasn't returned by a previous listing.
+	ListByUser(ctx context.Context, userID uint64, filter domain.JobFilter, cursor string, limit int) ([]*domain.Job, string, error)
 }
 
 type JobDeleter interface {
 	Delete(ctx context.Context, id string) error
+	// DeleteAll deletes jobs in a single transaction.
+	// It returns ErrStatusChanged if any job was saved since it was loaded.
+	DeleteAll(ctx context.Context, jobs []*domain.Job) error
 }
 
 type JobRepository interface {
@@ -82,17 +279,27 @@ type JobRepository interface {
 	JobReader
 	JobDeleter
 	JobMigrator
+	// BackfillListedAt sets the ListedAt of the jobs saved before they were listed, chapters aside,
+	// so that userIndex lists them along with the jobs saved since. Jobs that already have one are left as they are.
+	// It scans the whole table, and is run once, after the index was added, rather than on every start.
+	BackfillListedAt(ctx context.Context) error
 }
 
 type jobRepository struct {
 	t  string
+	o  string
+	d  string
 	cl *dynamodb.Client
 }
 
-func NewJobRepository(dynamodbClient *dynamodb.Client, tableName string) JobRepository {
+// NewJobRepository stores jobs in tableName. Outbox entries saved alongside jobs go to outboxTableName,
+// and webhook deliveries to deliveryTableName.
+func NewJobRepository(dynamodbClient *dynamodb.Client, tableName, outboxTableName, deliveryTableName string) JobRepository {
 	return &jobRepository{
 		cl: dynamodbClient,
 		t:  tableName,
+		o:  outboxTableName,
+		d:  deliveryTableName,
 	}
 }
 
@@ -103,12 +310,99 @@ func (j *jobRepository) AutoMigrate(ctx context.Context) error {
 	}
 
 	if exists {
-		return nil // table already exists, no need to create it, just return nil
+		// tables created before jobs were listed lack the index, and their jobs the key they are listed by,
+		// which BackfillListedAt sets once
+		return j.ensureUserIndex(ctx)
 	}
 
 	return j.CreateTable(ctx)
 }
 
+// ensureUserIndex adds userIndex to a table created without it.
+// The index is built in the background, listing jobs once BackfillListedAt gave them their ListedAt.
+func (j *jobRepository) ensureUserIndex(ctx context.Context) error {
+	table, err := j.cl.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(j.t)})
+	if err != nil {
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+
+	for _, index := range table.Table.GlobalSecondaryIndexes {
+		if aws.ToString(index.IndexName) == userIndex {
+			return nil
+		}
+	}
+
+	if _, err = j.cl.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName:            aws.String(j.t),
+		AttributeDefinitions: userIndexAttributes,
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+			Create: &types.CreateGlobalSecondaryIndexAction{
+				IndexName:  userJobIndex.IndexName,
+				KeySchema:  userJobIndex.KeySchema,
+				Projection: userJobIndex.Projection,
+			},
+		}},
+	}); err != nil {
+		return fmt.Errorf("failed to add user index: %w", err)
+	}
+
+	return nil
+}
+
+func (j *jobRepository) BackfillListedAt(ctx context.Context) error {
+	paginator := dynamodb.NewScanPaginator(j.cl, &dynamodb.ScanInput{
+		TableName:            aws.String(j.t),
+		FilterExpression:     aws.String("attribute_not_exists(#listedAt) AND attribute_not_exists(#parentID)"),
+		ProjectionExpression: aws.String("ID, #createdAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#listedAt":  "ListedAt",
+			"#parentID":  "ParentID",
+			"#createdAt": "CreatedAt",
+		},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan jobs without ListedAt: %w", err)
+		}
+
+		var jobs []struct {
+			ID        string    `dynamodbav:"ID"`
+			CreatedAt time.Time `dynamodbav:"CreatedAt"`
+		}
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &jobs); err != nil {
+			return fmt.Errorf("failed to unmarshal jobs without ListedAt: %w", err)
+		}
+
+		for _, job := range jobs {
+			if _, err = j.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(j.t),
+				Key: map[string]types.AttributeValue{
+					"ID": &types.AttributeValueMemberS{Value: job.ID},
+				},
+				UpdateExpression: aws.String("SET #listedAt = :listedAt"),
+				// jobs deleted meanwhile stay deleted
+				ConditionExpression: aws.String("attribute_exists(ID) AND attribute_not_exists(#listedAt)"),
+				ExpressionAttributeNames: map[string]string{
+					"#listedAt": "ListedAt",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":listedAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(job.CreatedAt.UnixMilli(), 10)},
+				},
+			}); err != nil {
+				var condEx *types.ConditionalCheckFailedException
+				if errors.As(err, &condEx) {
+					continue
+				}
+				return fmt.Errorf("failed to backfill ListedAt of job %s: %w", job.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (j *jobRepository) TableExists(ctx context.Context) (bool, error) {
 	if _, err := j.cl.DescribeTable(
 		ctx, &dynamodb.DescribeTableInput{TableName: aws.String(j.t)},
@@ -128,27 +422,16 @@ func (j *jobRepository) TableExists(ctx context.Context) (bool, error) {
 func (j *jobRepository) CreateTable(ctx context.Context) error {
 	if _, err := j.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
 		TableName: aws.String(j.t),
-		AttributeDefinitions: []types.AttributeDefinition{{
-			AttributeName: aws.String("id"),
-			AttributeType: types.ScalarAttributeTypeS,
-		}, {
-			AttributeName: aws.String("status"),
-			AttributeType: types.ScalarAttributeTypeS,
-		}, {
-			AttributeName: aws.String("file_key"),
-			AttributeType: types.ScalarAttributeTypeS,
-		}, {
-			AttributeName: aws.String("created_at"),
+		AttributeDefinitions: append([]types.AttributeDefinition{{
+			AttributeName: aws.String("ID"),
 			AttributeType: types.ScalarAttributeTypeS,
-		}, {
-			AttributeName: aws.String("updated_at"),
-			AttributeType: types.ScalarAttributeTypeS,
-		}},
+		}}, userIndexAttributes...),
 		KeySchema: []types.KeySchemaElement{{
-			AttributeName: aws.String("id"),
+			AttributeName: aws.String("ID"),
 			KeyType:       types.KeyTypeHash,
 		}},
-		BillingMode: types.BillingModePayPerRequest,
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{userJobIndex},
+		BillingMode:            types.BillingModePayPerRequest,
 	}); err != nil {
 		return err
 	}
@@ -162,7 +445,7 @@ func (j *jobRepository) CreateTable(ctx context.Context) error {
 	return nil
 }
 
-func (j *jobRepository) Save(ctx context.Context, job *domain.Job) error {
+func (j *jobRepository) Save(ctx context.Context, job *domain.Job, delivery *domain.Delivery) error {
 	jobDTO := NewJobDTO(job)
 
 	av, err := attributevalue.MarshalMap(jobDTO)
@@ -170,18 +453,95 @@ func (j *jobRepository) Save(ctx context.Context, job *domain.Job) error {
 		return fmt.Errorf("failed to marshal jobDTO: %w", err)
 	}
 
-	if _, err = j.cl.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName:           aws.String(j.t),
-		Item:                av,
-		ConditionExpression: aws.String("attribute_not_exists(ID)"),
-		ReturnValues:        types.ReturnValueNone,
-	}); err != nil {
+	if err = j.transact(ctx, []types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName:           aws.String(j.t),
+			Item:                av,
+			ConditionExpression: aws.String("attribute_not_exists(ID)"),
+		},
+	}}, delivery); err != nil {
 		return fmt.Errorf("failed to put item: %w", err)
 	}
 
 	return nil
 }
 
+func (j *jobRepository) SaveWithOutbox(ctx context.Context, job *domain.Job, entry *domain.OutboxEntry) error {
+	jobItem, err := attributevalue.MarshalMap(NewJobDTO(job))
+	if err != nil {
+		return fmt.Errorf("failed to marshal jobDTO: %w", err)
+	}
+
+	entryItem, err := attributevalue.MarshalMap(NewOutboxDTO(entry))
+	if err != nil {
+		return fmt.Errorf("failed to marshal outboxDTO: %w", err)
+	}
+
+	if _, err = j.cl.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{{
+			Put: &types.Put{
+				TableName:           aws.String(j.t),
+				Item:                jobItem,
+				ConditionExpression: aws.String("attribute_not_exists(ID)"),
+			},
+		}, {
+			Put: &types.Put{
+				TableName:           aws.String(j.o),
+				Item:                entryItem,
+				ConditionExpression: aws.String("attribute_not_exists(ID)"),
+			},
+		}},
+	}); err != nil {
+		return fmt.Errorf("failed to save job with outbox entry: %w", err)
+	}
+
+	return nil
+}
+
+func (j *jobRepository) SaveSplit(ctx context.Context, job *domain.Job, chapters []*domain.Job, entries []*domain.OutboxEntry, delivery *domain.Delivery) error {
+	items := make([]types.TransactWriteItem, 0, 2+len(chapters)+len(entries))
+	for _, job := range append([]*domain.Job{job}, chapters...) {
+		item, err := attributevalue.MarshalMap(NewJobDTO(job))
+		if err != nil {
+			return fmt.Errorf("failed to marshal jobDTO: %w", err)
+		}
+
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(j.t),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(ID)"),
+			},
+		})
+	}
+
+	for _, entry := range entries {
+		item, err := attributevalue.MarshalMap(NewOutboxDTO(entry))
+		if err != nil {
+			return fmt.Errorf("failed to marshal outboxDTO: %w", err)
+		}
+
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(j.o),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(ID)"),
+			},
+		})
+	}
+
+	// the delivery takes one more item
+	if len(items)+1 > maxTransactItems {
+		return fmt.Errorf("split job of %d chapters exceeds the %d items of a transaction", len(chapters), maxTransactItems)
+	}
+
+	if err := j.transact(ctx, items, delivery); err != nil {
+		return fmt.Errorf("failed to save split job: %w", err)
+	}
+
+	return nil
+}
+
 func (j *jobRepository) Load(ctx context.Context, jobID string) (*domain.Job, error) {
 	input := &dynamodb.GetItemInput{
 		TableName: aws.String(j.t),
@@ -197,7 +557,7 @@ func (j *jobRepository) Load(ctx context.Context, jobID string) (*domain.Job, er
 	}
 
 	if result.Item == nil {
-		return nil, fmt.Errorf("job not found")
+		return nil, ErrNotExist
 	}
 
 	var jobDTO JobDTO
@@ -208,31 +568,471 @@ func (j *jobRepository) Load(ctx context.Context, jobID string) (*domain.Job, er
 	return jobDTO.ToJob()
 }
 
-func (j *jobRepository) Update(ctx context.Context, job *domain.Job) error {
+func (j *jobRepository) LoadMany(ctx context.Context, ids []string) ([]*domain.Job, error) {
+	jobs, err := j.LoadExisting(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jobs) != len(ids) {
+		return nil, ErrNotExist
+	}
+
+	return jobs, nil
+}
+
+func (j *jobRepository) LoadExisting(ctx context.Context, ids []string) ([]*domain.Job, error) {
+	found := make(map[string]*domain.Job, len(ids))
+	for batch := range slices.Chunk(ids, maxBatchGetItems) {
+		keys := make([]map[string]types.AttributeValue, 0, len(batch))
+		for _, id := range batch {
+			keys = append(keys, map[string]types.AttributeValue{
+				"ID": &types.AttributeValueMemberS{Value: id},
+			})
+		}
+
+		request := map[string]types.KeysAndAttributes{
+			j.t: {Keys: keys, ConsistentRead: aws.Bool(true)},
+		}
+
+		// keys the table couldn't serve in time are handed back to be asked for again
+		for len(request) > 0 {
+			result, err := j.cl.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to batch get items: %w", err)
+			}
+
+			for _, item := range result.Responses[j.t] {
+				var jobDTO JobDTO
+				if err = attributevalue.UnmarshalMap(item, &jobDTO); err != nil {
+					return nil, fmt.Errorf("failed to unmarshal jobDTO: %w", err)
+				}
+
+				job, err := jobDTO.ToJob()
+				if err != nil {
+					return nil, err
+				}
+				found[job.ID] = job
+			}
+
+			request = result.UnprocessedKeys
+		}
+	}
+
+	jobs := make([]*domain.Job, 0, len(ids))
+	for _, id := range ids {
+		if job, ok := found[id]; ok {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+func (j *jobRepository) ListByUser(ctx context.Context, userID uint64, filter domain.JobFilter, cursor string, limit int) ([]*domain.Job, string, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(j.t),
+		IndexName:              aws.String(userIndex),
+		KeyConditionExpression: aws.String("#userID = :userID"),
+		ExpressionAttributeNames: map[string]string{
+			"#userID": "UserID",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userID": &types.AttributeValueMemberN{Value: strconv.FormatUint(userID, 10)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	// both bounds are exclusive, and BETWEEN isn't
+	after, before := int64(0), int64(math.MaxInt64)
+	if !filter.CreatedAfter.IsZero() {
+		after = filter.CreatedAfter.UnixMilli() + 1
+	}
+	if !filter.CreatedBefore.IsZero() {
+		before = filter.CreatedBefore.UnixMilli() - 1
+	}
+	if !filter.CreatedAfter.IsZero() || !filter.CreatedBefore.IsZero() {
+		input.KeyConditionExpression = aws.String("#userID = :userID AND #listedAt BETWEEN :after AND :before")
+		input.ExpressionAttributeNames["#listedAt"] = "ListedAt"
+		input.ExpressionAttributeValues[":after"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(after, 10)}
+		input.ExpressionAttributeValues[":before"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(before, 10)}
+	}
+
+	if len(filter.Statuses) > 0 {
+		placeholders := make([]string, 0, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			placeholder := fmt.Sprintf(":status%d", i)
+			placeholders = append(placeholders, placeholder)
+			input.ExpressionAttributeValues[placeholder] = &types.AttributeValueMemberS{Value: status.String()}
+		}
+		input.FilterExpression = aws.String("#status IN (" + strings.Join(placeholders, ", ") + ")")
+		input.ExpressionAttributeNames["#status"] = "Status"
+	}
+
+	if cursor != "" {
+		key, err := decodeJobCursor(userID, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		input.ExclusiveStartKey = key
+	}
+
+	// the limit applies before the filter, so filtered pages may come back short of it
+	var jobs []*domain.Job
+	for {
+		result, err := j.cl.Query(ctx, input)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query jobs: %w", err)
+		}
+
+		for _, item := range result.Items {
+			var jobDTO JobDTO
+			if err = attributevalue.UnmarshalMap(item, &jobDTO); err != nil {
+				return nil, "", fmt.Errorf("failed to unmarshal jobDTO: %w", err)
+			}
+
+			job, err := jobDTO.ToJob()
+			if err != nil {
+				return nil, "", err
+			}
+			jobs = append(jobs, job)
+		}
+
+		if result.LastEvaluatedKey == nil {
+			if len(jobs) <= limit {
+				return jobs, "", nil
+			}
+			break
+		}
+
+		if len(jobs) >= limit {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	// the next page starts after the last job returned, whatever the query read past it
+	jobs = jobs[:min(len(jobs), limit)]
+	return jobs, encodeJobCursor(jobs[len(jobs)-1]), nil
+}
+
+// encodeJobCursor returns the cursor of the listing that continues after the job.
+// It holds the index key of the job but for the user, whose listing it only continues.
+func encodeJobCursor(job *domain.Job) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(job.CreatedAt.UnixMilli(), 10) + "." + job.ID))
+}
+
+// decodeJobCursor returns the index key a listing of the user continues after.
+func decodeJobCursor(userID uint64, cursor string) (map[string]types.AttributeValue, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	listedAt, id, ok := strings.Cut(string(raw), ".")
+	if _, err = strconv.ParseInt(listedAt, 10, 64); err != nil || !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return map[string]types.AttributeValue{
+		"ID":       &types.AttributeValueMemberS{Value: id},
+		"UserID":   &types.AttributeValueMemberN{Value: strconv.FormatUint(userID, 10)},
+		"ListedAt": &types.AttributeValueMemberN{Value: listedAt},
+	}, nil
+}
+
+func (j *jobRepository) Update(ctx context.Context, job *domain.Job, delivery *domain.Delivery) error {
 	jobDTO := NewJobDTO(job)
 
-	if _, err := j.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	updatedAt, err := attributevalue.Marshal(jobDTO.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal updated time: %w", err)
+	}
+
+	update := &types.Update{
 		TableName: aws.String(j.t),
 		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: jobDTO.ID},
+			"ID": &types.AttributeValueMemberS{Value: jobDTO.ID},
 		},
-		UpdateExpression: aws.String("SET #status = :newStatus, #updatedAt = :updatedAt"),
+		UpdateExpression:    aws.String("SET #status = :newStatus, #fileKey = :fileKey, #updatedAt = :updatedAt ADD #version :one"),
+		ConditionExpression: aws.String("attribute_exists(ID) AND #status <> :cancelled"),
 		ExpressionAttributeNames: map[string]string{
-			"#status":    "status",
-			"#updatedAt": "updated_at",
+			"#status":    "Status",
+			"#fileKey":   "FileKey",
+			"#updatedAt": "UpdatedAt",
+			"#version":   "Version",
 		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":newStatus": &types.AttributeValueMemberS{Value: jobDTO.Status},
-			":updatedAt": &types.AttributeValueMemberS{Value: jobDTO.UpdatedAt.Format(time.RFC3339)},
+			":fileKey":   &types.AttributeValueMemberS{Value: jobDTO.FileKey},
+			":updatedAt": updatedAt,
+			":cancelled": &types.AttributeValueMemberS{Value: domain.Cancelled.String()},
+			":one":       &types.AttributeValueMemberN{Value: "1"},
 		},
-		ReturnValues: types.ReturnValueUpdatedNew,
-	}); err != nil {
-		return fmt.Errorf("failed to update job status: %w", err)
+		// the job that failed the condition tells a missing job from a cancelled one
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	if err = j.updateOne(ctx, job, update, delivery); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx) && condEx.Item == nil:
+			return ErrNotExist
+		case errors.As(err, &condEx):
+			return ErrCancelled
+		default:
+			return fmt.Errorf("failed to update job status: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// updateOne applies the update to the job along with the delivery, if any, and sets the version it bumped.
+// The update is made in a transaction only when there is a delivery to save. Transactions don't return
+// what they updated, so the version is then the one the job was loaded with, bumped once.
+// The job failing the condition of the update is returned as a ConditionalCheckFailedException either way.
+func (j *jobRepository) updateOne(ctx context.Context, job *domain.Job, update *types.Update, delivery *domain.Delivery) error {
+	if delivery == nil {
+		result, err := j.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                           update.TableName,
+			Key:                                 update.Key,
+			UpdateExpression:                    update.UpdateExpression,
+			ConditionExpression:                 update.ConditionExpression,
+			ExpressionAttributeNames:            update.ExpressionAttributeNames,
+			ExpressionAttributeValues:           update.ExpressionAttributeValues,
+			ReturnValues:                        types.ReturnValueUpdatedNew,
+			ReturnValuesOnConditionCheckFailure: update.ReturnValuesOnConditionCheckFailure,
+		})
+		if err != nil {
+			return err
+		}
+
+		return setVersion(job, result.Attributes)
+	}
+
+	if err := j.transact(ctx, []types.TransactWriteItem{{Update: update}}, delivery); err != nil {
+		var canceledEx *types.TransactionCanceledException
+		if errors.As(err, &canceledEx) && len(canceledEx.CancellationReasons) > 0 {
+			if reason := canceledEx.CancellationReasons[0]; aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return &types.ConditionalCheckFailedException{Message: reason.Message, Item: reason.Item}
+			}
+		}
+		return err
+	}
+
+	job.Version++
+	return nil
+}
+
+// setVersion sets the version of a job to the one an update returned.
+func setVersion(job *domain.Job, attributes map[string]types.AttributeValue) error {
+	if err := attributevalue.Unmarshal(attributes["Version"], &job.Version); err != nil {
+		return fmt.Errorf("failed to unmarshal job version: %w", err)
+	}
+
+	return nil
+}
+
+func (j *jobRepository) UpdateStatusFrom(ctx context.Context, job *domain.Job, from domain.JobStatus, delivery *domain.Delivery) error {
+	updatedAt, err := attributevalue.Marshal(job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal updated time: %w", err)
+	}
+
+	if err = j.updateOne(ctx, job, &types.Update{
+		TableName: aws.String(j.t),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: job.ID},
+		},
+		UpdateExpression:    aws.String("SET #status = :newStatus, #updatedAt = :updatedAt ADD #version :one"),
+		ConditionExpression: aws.String("#status = :oldStatus"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":    "Status",
+			"#updatedAt": "UpdatedAt",
+			"#version":   "Version",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":newStatus": &types.AttributeValueMemberS{Value: job.Status.String()},
+			":oldStatus": &types.AttributeValueMemberS{Value: from.String()},
+			":updatedAt": updatedAt,
+			":one":       &types.AttributeValueMemberN{Value: "1"},
+		},
+	}, delivery); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrStatusChanged
+		default:
+			return fmt.Errorf("failed to update job status: %w", err)
+		}
 	}
 
 	return nil
 }
 
+func (j *jobRepository) Cancel(ctx context.Context, jobs []*domain.Job, entries []*domain.OutboxEntry, delivery *domain.Delivery) error {
+	return j.updateWithOutbox(ctx, jobs, entries, delivery, func(job *domain.Job) (*types.Update, error) {
+		updatedAt, err := attributevalue.Marshal(job.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal updated time: %w", err)
+		}
+
+		return &types.Update{
+			UpdateExpression: aws.String("SET #status = :cancelled, #updatedAt = :updatedAt ADD #version :one"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":    "Status",
+				"#updatedAt": "UpdatedAt",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":cancelled": &types.AttributeValueMemberS{Value: domain.Cancelled.String()},
+				":updatedAt": updatedAt,
+			},
+		}, nil
+	})
+}
+
+func (j *jobRepository) Retry(ctx context.Context, jobs []*domain.Job, entries []*domain.OutboxEntry) error {
+	return j.updateWithOutbox(ctx, jobs, entries, nil, func(job *domain.Job) (*types.Update, error) {
+		jobDTO := NewJobDTO(job)
+
+		updatedAt, err := attributevalue.Marshal(jobDTO.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal updated time: %w", err)
+		}
+
+		attempts, err := attributevalue.Marshal(jobDTO.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job attempts: %w", err)
+		}
+
+		return &types.Update{
+			UpdateExpression: aws.String("SET #status = :status, #fileKey = :fileKey, #attempt = :attempt, #attempts = :attempts, #updatedAt = :updatedAt ADD #version :one"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":    "Status",
+				"#fileKey":   "FileKey",
+				"#attempt":   "Attempt",
+				"#attempts":  "Attempts",
+				"#updatedAt": "UpdatedAt",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":status":    &types.AttributeValueMemberS{Value: jobDTO.Status},
+				":fileKey":   &types.AttributeValueMemberS{Value: jobDTO.FileKey},
+				":attempt":   &types.AttributeValueMemberN{Value: strconv.Itoa(jobDTO.Attempt)},
+				":attempts":  attempts,
+				":updatedAt": updatedAt,
+			},
+		}, nil
+	})
+}
+
+// updateWithOutbox applies the update of every job, provided its version is still the one it was loaded with,
+// and puts the outbox entries and the delivery, if any, in a single transaction. The updates are given the key
+// of their job, and are expected to bump its version as #version by :one.
+func (j *jobRepository) updateWithOutbox(ctx context.Context, jobs []*domain.Job, entries []*domain.OutboxEntry, delivery *domain.Delivery, update func(job *domain.Job) (*types.Update, error)) error {
+	items := make([]types.TransactWriteItem, 0, len(jobs)+len(entries))
+	for _, job := range jobs {
+		u, err := update(job)
+		if err != nil {
+			return err
+		}
+
+		u.TableName = aws.String(j.t)
+		u.Key = map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: job.ID},
+		}
+		u.ConditionExpression = aws.String("#version = :version")
+		u.ExpressionAttributeNames["#version"] = "Version"
+		u.ExpressionAttributeValues[":version"] = &types.AttributeValueMemberN{Value: strconv.FormatUint(job.Version, 10)}
+		u.ExpressionAttributeValues[":one"] = &types.AttributeValueMemberN{Value: "1"}
+
+		items = append(items, types.TransactWriteItem{Update: u})
+	}
+
+	for _, entry := range entries {
+		item, err := attributevalue.MarshalMap(NewOutboxDTO(entry))
+		if err != nil {
+			return fmt.Errorf("failed to marshal outboxDTO: %w", err)
+		}
+
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(j.o),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(ID)"),
+			},
+		})
+	}
+
+	// the delivery takes one more item
+	if len(items)+1 > maxTransactItems {
+		return fmt.Errorf("update of %d jobs exceeds the %d items of a transaction", len(jobs), maxTransactItems)
+	}
+
+	if err := j.transact(ctx, items, delivery); err != nil {
+		var canceledEx *types.TransactionCanceledException
+		if errors.As(err, &canceledEx) && slices.ContainsFunc(canceledEx.CancellationReasons, func(r types.CancellationReason) bool {
+			return aws.ToString(r.Code) == "ConditionalCheckFailed"
+		}) {
+			return ErrStatusChanged
+		}
+		return fmt.Errorf("failed to update jobs: %w", err)
+	}
+
+	// the versions were bumped along with the jobs
+	for _, job := range jobs {
+		job.Version++
+	}
+
+	return nil
+}
+
+// transact writes the items along with the delivery, if any, in a single transaction.
+// A delivery saved before is left as it is, the items being written again without it.
+func (j *jobRepository) transact(ctx context.Context, items []types.TransactWriteItem, delivery *domain.Delivery) error {
+	if delivery != nil {
+		item, err := attributevalue.MarshalMap(NewDeliveryDTO(delivery))
+		if err != nil {
+			return fmt.Errorf("failed to marshal deliveryDTO: %w", err)
+		}
+
+		_, err = j.cl.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append(slices.Clip(items), types.TransactWriteItem{
+				Put: &types.Put{
+					TableName:           aws.String(j.d),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(ID)"),
+				},
+			}),
+		})
+
+		var canceledEx *types.TransactionCanceledException
+		if !errors.As(err, &canceledEx) || !deliveryExists(canceledEx.CancellationReasons, len(items)) {
+			return err
+		}
+	}
+
+	_, err := j.cl.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	return err
+}
+
+// deliveryExists tells whether a transaction was cancelled only because the delivery put after its first n items
+// was saved before.
+func deliveryExists(reasons []types.CancellationReason, n int) bool {
+	if len(reasons) <= n || aws.ToString(reasons[n].Code) != "ConditionalCheckFailed" {
+		return false
+	}
+
+	return !slices.ContainsFunc(reasons[:n], func(r types.CancellationReason) bool {
+		return aws.ToString(r.Code) != "None"
+	})
+}
+
 func (j *jobRepository) Delete(ctx context.Context, jobID string) error {
 	if _, err := j.cl.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(j.t),
@@ -246,3 +1046,42 @@ func (j *jobRepository) Delete(ctx context.Context, jobID string) error {
 
 	return nil
 }
+
+func (j *jobRepository) DeleteAll(ctx context.Context, jobs []*domain.Job) error {
+	if len(jobs) > maxTransactItems {
+		return fmt.Errorf("deletion of %d jobs exceeds the %d items of a transaction", len(jobs), maxTransactItems)
+	}
+
+	items := make([]types.TransactWriteItem, 0, len(jobs))
+	for _, job := range jobs {
+		items = append(items, types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: aws.String(j.t),
+				Key: map[string]types.AttributeValue{
+					"ID": &types.AttributeValueMemberS{Value: job.ID},
+				},
+				ConditionExpression: aws.String("#version = :version"),
+				ExpressionAttributeNames: map[string]string{
+					"#version": "Version",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":version": &types.AttributeValueMemberN{Value: strconv.FormatUint(job.Version, 10)},
+				},
+			},
+		})
+	}
+
+	if _, err := j.cl.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}); err != nil {
+		var canceledEx *types.TransactionCanceledException
+		if errors.As(err, &canceledEx) && slices.ContainsFunc(canceledEx.CancellationReasons, func(r types.CancellationReason) bool {
+			return aws.ToString(r.Code) == "ConditionalCheckFailed"
+		}) {
+			return ErrStatusChanged
+		}
+		return fmt.Errorf("failed to delete jobs: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type ManifestDTO struct {
+	JobID     string       `dynamodbav:"JobID"`
+	Segments  []SegmentDTO `dynamodbav:"Segments"`
+	CreatedAt time.Time    `dynamodbav:"CreatedAt"`
+}
+
+type SegmentDTO struct {
+	Key    string `dynamodbav:"Key"`
+	Reused bool   `dynamodbav:"Reused,omitempty"`
+}
+
+func NewManifestDTO(manifest *domain.Manifest) ManifestDTO {
+	segments := make([]SegmentDTO, 0, len(manifest.Segments))
+	for _, s := range manifest.Segments {
+		segments = append(segments, SegmentDTO{Key: s.Key, Reused: s.Reused})
+	}
+
+	return ManifestDTO{
+		JobID:     manifest.JobID,
+		Segments:  segments,
+		CreatedAt: manifest.CreatedAt,
+	}
+}
+
+func (m ManifestDTO) ToManifest() *domain.Manifest {
+	segments := make([]domain.Segment, 0, len(m.Segments))
+	for _, s := range m.Segments {
+		segments = append(segments, domain.Segment{Key: s.Key, Reused: s.Reused})
+	}
+
+	return &domain.Manifest{
+		JobID:     m.JobID,
+		Segments:  segments,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+type ManifestRepository interface {
+	// Save saves the manifest of a job, whose job is saved afterwards.
+	Save(ctx context.Context, manifest *domain.Manifest) error
+	// Load returns the manifest of a job, or ErrNotExist for jobs submitted without paragraphs.
+	Load(ctx context.Context, jobID string) (*domain.Manifest, error)
+	// LoadExisting returns the manifests of those of the jobs that have one.
+	LoadExisting(ctx context.Context, jobIDs []string) ([]*domain.Manifest, error)
+	// Delete deletes the manifest of a job, if any.
+	Delete(ctx context.Context, jobID string) error
+	JobMigrator
+}
+
+type manifestRepository struct {
+	t  string
+	cl *dynamodb.Client
+}
+
+func NewManifestRepository(dynamodbClient *dynamodb.Client, tableName string) ManifestRepository {
+	return &manifestRepository{
+		cl: dynamodbClient,
+		t:  tableName,
+	}
+}
+
+func (m *manifestRepository) AutoMigrate(ctx context.Context) error {
+	exists, err := m.TableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return m.CreateTable(ctx)
+}
+
+func (m *manifestRepository) TableExists(ctx context.Context) (bool, error) {
+	if _, err := m.cl.DescribeTable(
+		ctx, &dynamodb.DescribeTableInput{TableName: aws.String(m.t)},
+	); err != nil {
+		var notFoundEx *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFoundEx):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (m *manifestRepository) CreateTable(ctx context.Context) error {
+	if _, err := m.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(m.t),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String("JobID"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("JobID"),
+			KeyType:       types.KeyTypeHash,
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}); err != nil {
+		return err
+	}
+
+	if err := dynamodb.NewTableExistsWaiter(m.cl).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(m.t),
+	}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	return nil
+}
+
+func (m *manifestRepository) Save(ctx context.Context, manifest *domain.Manifest) error {
+	item, err := attributevalue.MarshalMap(NewManifestDTO(manifest))
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifestDTO: %w", err)
+	}
+
+	if _, err = m.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.t),
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("failed to put manifest: %w", err)
+	}
+
+	return nil
+}
+
+func (m *manifestRepository) Load(ctx context.Context, jobID string) (*domain.Manifest, error) {
+	result, err := m.cl.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(m.t),
+		Key: map[string]types.AttributeValue{
+			"JobID": &types.AttributeValueMemberS{Value: jobID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, ErrNotExist
+	}
+
+	var dto ManifestDTO
+	if err = attributevalue.UnmarshalMap(result.Item, &dto); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifestDTO: %w", err)
+	}
+
+	return dto.ToManifest(), nil
+}
+
+func (m *manifestRepository) LoadExisting(ctx context.Context, jobIDs []string) ([]*domain.Manifest, error) {
+	var manifests []*domain.Manifest
+	for batch := range slices.Chunk(jobIDs, maxBatchGetItems) {
+		keys := make([]map[string]types.AttributeValue, 0, len(batch))
+		for _, id := range batch {
+			keys = append(keys, map[string]types.AttributeValue{
+				"JobID": &types.AttributeValueMemberS{Value: id},
+			})
+		}
+
+		request := map[string]types.KeysAndAttributes{
+			m.t: {Keys: keys},
+		}
+
+		// keys the table couldn't serve in time are handed back to be asked for again
+		for len(request) > 0 {
+			result, err := m.cl.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to batch get manifests: %w", err)
+			}
+
+			for _, item := range result.Responses[m.t] {
+				var dto ManifestDTO
+				if err = attributevalue.UnmarshalMap(item, &dto); err != nil {
+					return nil, fmt.Errorf("failed to unmarshal manifestDTO: %w", err)
+				}
+				manifests = append(manifests, dto.ToManifest())
+			}
+
+			request = result.UnprocessedKeys
+		}
+	}
+
+	return manifests, nil
+}
+
+func (m *manifestRepository) Delete(ctx context.Context, jobID string) error {
+	if _, err := m.cl.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(m.t),
+		Key: map[string]types.AttributeValue{
+			"JobID": &types.AttributeValueMemberS{Value: jobID},
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to delete manifest: %w", err)
+	}
+
+	return nil
+}
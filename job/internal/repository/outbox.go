@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// pendingIndex is a sparse index over entries that still have to be published,
+	// ordered by when they are next due.
+	pendingIndex = "PendingIndex"
+	// pendingPartition is the value of the Pending attribute of every pending entry.
+	// The attribute is removed once an entry is sent, which drops it from pendingIndex.
+	pendingPartition = "pending"
+)
+
+type OutboxDTO struct {
+	ID            string     `dynamodbav:"ID"`
+	JobID         string     `dynamodbav:"JobID"`
+	Kind          string     `dynamodbav:"Kind,omitempty"`
+	Pending       string     `dynamodbav:"Pending,omitempty"`
+	Attempts      int        `dynamodbav:"Attempts"`
+	NextAttemptAt int64      `dynamodbav:"NextAttemptAt"` // unix milliseconds, the sort key of pendingIndex
+	SentAt        *time.Time `dynamodbav:"SentAt,omitempty"`
+	CreatedAt     time.Time  `dynamodbav:"CreatedAt"`
+}
+
+func NewOutboxDTO(entry *domain.OutboxEntry) OutboxDTO {
+	dto := OutboxDTO{
+		ID:            entry.ID,
+		JobID:         entry.JobID,
+		Kind:          entry.Kind.String(),
+		Attempts:      entry.Attempts,
+		NextAttemptAt: entry.NextAttemptAt.UnixMilli(),
+		SentAt:        entry.SentAt,
+		CreatedAt:     entry.CreatedAt,
+	}
+
+	if entry.SentAt == nil {
+		dto.Pending = pendingPartition
+	}
+
+	return dto
+}
+
+func (o OutboxDTO) ToOutboxEntry() *domain.OutboxEntry {
+	// entries saved before cancellations have no kind
+	kind := domain.OutboxConversion
+	if o.Kind == "Cancellation" {
+		kind = domain.OutboxCancellation
+	}
+
+	return &domain.OutboxEntry{
+		ID:            o.ID,
+		JobID:         o.JobID,
+		Kind:          kind,
+		Attempts:      o.Attempts,
+		NextAttemptAt: time.UnixMilli(o.NextAttemptAt),
+		SentAt:        o.SentAt,
+		CreatedAt:     o.CreatedAt,
+	}
+}
+
+type OutboxReader interface {
+	// Due returns up to limit pending entries whose next attempt is at or before now, oldest first.
+	Due(ctx context.Context, now time.Time, limit int) ([]*domain.OutboxEntry, error)
+}
+
+type OutboxWriter interface {
+	// Claim leases an entry until the given time.
+	// It returns ErrAlreadyClaimed if another relay claimed the entry since it was read.
+	Claim(ctx context.Context, entry *domain.OutboxEntry, until time.Time) error
+	// MarkSent records that the entry was published, removing it from the pending entries.
+	MarkSent(ctx context.Context, id string) error
+}
+
+type OutboxRepository interface {
+	OutboxReader
+	OutboxWriter
+	JobMigrator
+}
+
+type outboxRepository struct {
+	t  string
+	cl *dynamodb.Client
+}
+
+func NewOutboxRepository(dynamodbClient *dynamodb.Client, tableName string) OutboxRepository {
+	return &outboxRepository{
+		cl: dynamodbClient,
+		t:  tableName,
+	}
+}
+
+func (o *outboxRepository) AutoMigrate(ctx context.Context) error {
+	exists, err := o.TableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return o.CreateTable(ctx)
+}
+
+func (o *outboxRepository) TableExists(ctx context.Context) (bool, error) {
+	if _, err := o.cl.DescribeTable(
+		ctx, &dynamodb.DescribeTableInput{TableName: aws.String(o.t)},
+	); err != nil {
+		var notFoundEx *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFoundEx):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (o *outboxRepository) CreateTable(ctx context.Context) error {
+	if _, err := o.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(o.t),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String("ID"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}, {
+			AttributeName: aws.String("Pending"),
+			AttributeType: types.ScalarAttributeTypeS,
+		}, {
+			AttributeName: aws.String("NextAttemptAt"),
+			AttributeType: types.ScalarAttributeTypeN,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("ID"),
+			KeyType:       types.KeyTypeHash,
+		}},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(pendingIndex),
+			KeySchema: []types.KeySchemaElement{{
+				AttributeName: aws.String("Pending"),
+				KeyType:       types.KeyTypeHash,
+			}, {
+				AttributeName: aws.String("NextAttemptAt"),
+				KeyType:       types.KeyTypeRange,
+			}},
+			Projection: &types.Projection{
+				ProjectionType: types.ProjectionTypeAll,
+			},
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}); err != nil {
+		return err
+	}
+
+	if err := dynamodb.NewTableExistsWaiter(o.cl).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(o.t),
+	}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	return nil
+}
+
+func (o *outboxRepository) Due(ctx context.Context, now time.Time, limit int) ([]*domain.OutboxEntry, error) {
+	result, err := o.cl.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(o.t),
+		IndexName:              aws.String(pendingIndex),
+		KeyConditionExpression: aws.String("#pending = :pending AND #nextAttemptAt <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#pending":       "Pending",
+			"#nextAttemptAt": "NextAttemptAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: pendingPartition},
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+		},
+		Limit: aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending outbox entries: %w", err)
+	}
+
+	var dtos []OutboxDTO
+	if err = attributevalue.UnmarshalListOfMaps(result.Items, &dtos); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal outbox entries: %w", err)
+	}
+
+	entries := make([]*domain.OutboxEntry, 0, len(dtos))
+	for _, dto := range dtos {
+		entries = append(entries, dto.ToOutboxEntry())
+	}
+
+	return entries, nil
+}
+
+func (o *outboxRepository) Claim(ctx context.Context, entry *domain.OutboxEntry, until time.Time) error {
+	previous := entry.NextAttemptAt.UnixMilli()
+
+	if _, err := o.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(o.t),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: entry.ID},
+		},
+		UpdateExpression:    aws.String("SET #nextAttemptAt = :until ADD #attempts :one"),
+		ConditionExpression: aws.String("attribute_exists(#pending) AND #nextAttemptAt = :previous"),
+		ExpressionAttributeNames: map[string]string{
+			"#pending":       "Pending",
+			"#nextAttemptAt": "NextAttemptAt",
+			"#attempts":      "Attempts",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until":    &types.AttributeValueMemberN{Value: strconv.FormatInt(until.UnixMilli(), 10)},
+			":previous": &types.AttributeValueMemberN{Value: strconv.FormatInt(previous, 10)},
+			":one":      &types.AttributeValueMemberN{Value: "1"},
+		},
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrAlreadyClaimed
+		default:
+			return fmt.Errorf("failed to claim outbox entry: %w", err)
+		}
+	}
+
+	entry.Claim(until)
+	return nil
+}
+
+func (o *outboxRepository) MarkSent(ctx context.Context, id string) error {
+	sentAt, err := attributevalue.Marshal(time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to marshal sent time: %w", err)
+	}
+
+	if _, err = o.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(o.t),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET #sentAt = :sentAt REMOVE #pending"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+		ExpressionAttributeNames: map[string]string{
+			"#pending": "Pending",
+			"#sentAt":  "SentAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sentAt": sentAt,
+		},
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrNotExist
+		default:
+			return fmt.Errorf("failed to mark outbox entry as sent: %w", err)
+		}
+	}
+
+	return nil
+}
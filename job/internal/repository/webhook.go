@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type WebhookDTO struct {
+	UserID    uint64    `dynamodbav:"UserID"`
+	URL       string    `dynamodbav:"URL,omitempty"`
+	Secret    string    `dynamodbav:"Secret"`
+	CreatedAt time.Time `dynamodbav:"CreatedAt"`
+	UpdatedAt time.Time `dynamodbav:"UpdatedAt"`
+}
+
+func NewWebhookDTO(webhook *domain.Webhook) WebhookDTO {
+	return WebhookDTO{
+		UserID:    webhook.UserID,
+		URL:       webhook.URL,
+		Secret:    webhook.Secret,
+		CreatedAt: webhook.CreatedAt,
+		UpdatedAt: webhook.UpdatedAt,
+	}
+}
+
+func (w WebhookDTO) ToWebhook() *domain.Webhook {
+	return &domain.Webhook{
+		UserID:    w.UserID,
+		URL:       w.URL,
+		Secret:    w.Secret,
+		CreatedAt: w.CreatedAt,
+		UpdatedAt: w.UpdatedAt,
+	}
+}
+
+type WebhookRepository interface {
+	// Create saves a new webhook, returning ErrAlreadyExists if the account has one.
+	Create(ctx context.Context, webhook *domain.Webhook) error
+	// Load returns the webhook of an account, or ErrNotExist.
+	Load(ctx context.Context, userID uint64) (*domain.Webhook, error)
+	// Update saves the URL of a webhook.
+	Update(ctx context.Context, webhook *domain.Webhook) error
+	// Delete removes the webhook of an account, returning ErrNotExist if it has none.
+	Delete(ctx context.Context, userID uint64) error
+	JobMigrator
+}
+
+type webhookRepository struct {
+	t  string
+	cl *dynamodb.Client
+}
+
+func NewWebhookRepository(dynamodbClient *dynamodb.Client, tableName string) WebhookRepository {
+	return &webhookRepository{
+		cl: dynamodbClient,
+		t:  tableName,
+	}
+}
+
+func (w *webhookRepository) AutoMigrate(ctx context.Context) error {
+	exists, err := w.TableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return w.CreateTable(ctx)
+}
+
+func (w *webhookRepository) TableExists(ctx context.Context) (bool, error) {
+	if _, err := w.cl.DescribeTable(
+		ctx, &dynamodb.DescribeTableInput{TableName: aws.String(w.t)},
+	); err != nil {
+		var notFoundEx *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFoundEx):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (w *webhookRepository) CreateTable(ctx context.Context) error {
+	if _, err := w.cl.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(w.t),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String("UserID"),
+			AttributeType: types.ScalarAttributeTypeN,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String("UserID"),
+			KeyType:       types.KeyTypeHash,
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}); err != nil {
+		return err
+	}
+
+	if err := dynamodb.NewTableExistsWaiter(w.cl).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(w.t),
+	}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	return nil
+}
+
+func (w *webhookRepository) Create(ctx context.Context, webhook *domain.Webhook) error {
+	item, err := attributevalue.MarshalMap(NewWebhookDTO(webhook))
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhookDTO: %w", err)
+	}
+
+	if _, err = w.cl.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(w.t),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(UserID)"),
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrAlreadyExists
+		default:
+			return fmt.Errorf("failed to put webhook: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (w *webhookRepository) Load(ctx context.Context, userID uint64) (*domain.Webhook, error) {
+	result, err := w.cl.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(w.t),
+		Key:       webhookKey(userID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, ErrNotExist
+	}
+
+	var dto WebhookDTO
+	if err = attributevalue.UnmarshalMap(result.Item, &dto); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhookDTO: %w", err)
+	}
+
+	return dto.ToWebhook(), nil
+}
+
+func (w *webhookRepository) Update(ctx context.Context, webhook *domain.Webhook) error {
+	updatedAt, err := attributevalue.Marshal(webhook.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal updated time: %w", err)
+	}
+
+	if _, err = w.cl.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(w.t),
+		Key:                 webhookKey(webhook.UserID),
+		UpdateExpression:    aws.String("SET #url = :url, #updatedAt = :updatedAt"),
+		ConditionExpression: aws.String("attribute_exists(UserID)"),
+		ExpressionAttributeNames: map[string]string{
+			"#url":       "URL",
+			"#updatedAt": "UpdatedAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":url":       &types.AttributeValueMemberS{Value: webhook.URL},
+			":updatedAt": updatedAt,
+		},
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrNotExist
+		default:
+			return fmt.Errorf("failed to update webhook: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (w *webhookRepository) Delete(ctx context.Context, userID uint64) error {
+	if _, err := w.cl.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(w.t),
+		Key:                 webhookKey(userID),
+		ConditionExpression: aws.String("attribute_exists(UserID)"),
+	}); err != nil {
+		var condEx *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &condEx):
+			return ErrNotExist
+		default:
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func webhookKey(userID uint64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"UserID": &types.AttributeValueMemberN{Value: strconv.FormatUint(userID, 10)},
+	}
+}
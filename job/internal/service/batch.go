@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+	"github.com/ziliscite/bard_narate/job/internal/repository"
+)
+
+// MaxBatchEntries is the most documents a batch may have.
+const MaxBatchEntries = 100
+
+type BatchService interface {
+	// New creates a job for every entry of the batch, in order, then the batch grouping them.
+	// Entries are converted as any job submitted on its own would be, and those that come as chapters as split jobs.
+	// Every job is notified of on its own, to the callback URL if any, and narrated with the same voice.
+	// The entries are checked before any job is created, so that a batch is either created whole or not at all.
+	// When saving a job or the batch fails midway, the jobs created so far are cancelled before anything
+	// converts them, so that a retried submission doesn't convert its documents twice.
+	New(ctx context.Context, userID uint64, entries []domain.BatchEntry, callbackURL string, voice domain.VoiceSettings) (*domain.Batch, []*domain.Job, error)
+	// Get returns a batch of the user along with its jobs, in the order of its entries.
+	// Jobs deleted since the batch was created are left out of it.
+	// It returns ErrBatchNotFound if the batch doesn't exist or belongs to another user.
+	Get(ctx context.Context, userID uint64, id string) (*domain.Batch, []*domain.Job, error)
+}
+
+type batchService struct {
+	br repository.BatchRepository
+	jr repository.JobReader
+	js JobService
+}
+
+// NewBatchService creates the jobs of batches through js.
+func NewBatchService(br repository.BatchRepository, jr repository.JobReader, js JobService) BatchService {
+	return &batchService{
+		br: br,
+		jr: jr,
+		js: js,
+	}
+}
+
+func (bs *batchService) New(ctx context.Context, userID uint64, entries []domain.BatchEntry, callbackURL string, voice domain.VoiceSettings) (*domain.Batch, []*domain.Job, error) {
+	switch {
+	case len(entries) == 0:
+		return nil, nil, ErrNoEntries
+	case len(entries) > MaxBatchEntries:
+		return nil, nil, ErrTooManyEntries
+	}
+
+	for i, entry := range entries {
+		switch {
+		case entry.FileKey == "" && len(entry.Chapters) == 0:
+			return nil, nil, fmt.Errorf("%w: entry %d has neither a file nor chapters", ErrInvalidEntry, i)
+		case entry.FileKey != "" && len(entry.Chapters) > 0:
+			return nil, nil, fmt.Errorf("%w: entry %d has both a file and chapters", ErrInvalidEntry, i)
+		case len(entry.Chapters) > MaxChapters:
+			return nil, nil, fmt.Errorf("%w: entry %d", ErrTooManyChapters, i)
+		}
+	}
+
+	if callbackURL != "" {
+		if err := ValidateCallbackURL(callbackURL); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	batch := domain.NewBatch(userID)
+	jobs := make([]*domain.Job, 0, len(entries))
+	for _, entry := range entries {
+		var job *domain.Job
+		var err error
+		if len(entry.Chapters) > 0 {
+			job, _, err = bs.js.NewSplit(ctx, userID, entry.Chapters, "", callbackURL, voice)
+		} else {
+			job, err = bs.js.New(ctx, userID, entry.Text, "", callbackURL, voice)
+		}
+		if err != nil {
+			bs.cancel(ctx, jobs)
+			return nil, nil, err
+		}
+
+		batch.Add(entry.Title, job.ID)
+		jobs = append(jobs, job)
+	}
+
+	// saved last, so that a batch only lists jobs that exist
+	if err := bs.br.Save(ctx, batch); err != nil {
+		bs.cancel(ctx, jobs)
+		return nil, nil, err
+	}
+
+	return batch, jobs, nil
+}
+
+// cancel cancels the jobs of a batch that failed to be created, along with their conversion requests.
+// Jobs that fail to be cancelled are logged, and converted as if submitted on their own.
+func (bs *batchService) cancel(ctx context.Context, jobs []*domain.Job) {
+	// the request may have been cancelled, which is what failed the batch
+	ctx = context.WithoutCancel(ctx)
+	for _, job := range jobs {
+		if _, err := bs.js.Cancel(ctx, job.ID); err != nil {
+			slog.Error("Failed to cancel job of failed batch", "job", job.ID, "error", err)
+		}
+	}
+}
+
+func (bs *batchService) Get(ctx context.Context, userID uint64, id string) (*domain.Batch, []*domain.Job, error) {
+	batch, err := bs.br.Load(ctx, id)
+	switch {
+	case errors.Is(err, repository.ErrNotExist):
+		return nil, nil, ErrBatchNotFound
+	case err != nil:
+		return nil, nil, err
+	case batch.UserID != userID:
+		return nil, nil, ErrBatchNotFound
+	}
+
+	// jobs deleted since are left out of the batch
+	jobs, err := bs.jr.LoadExisting(ctx, batch.JobIDs())
+	if err != nil {
+		return nil, nil, err
+	}
+	batch.Keep(jobs)
+
+	return batch, jobs, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+	"github.com/ziliscite/bard_narate/job/internal/repository"
+)
+
+// fakeJobs creates jobs until it is told to fail, and records the jobs it cancels.
+type fakeJobs struct {
+	JobService
+	// failAt is the number of the job that fails to be created, 0 never failing.
+	failAt    int
+	created   []string
+	cancelled []string
+}
+
+func (f *fakeJobs) New(_ context.Context, userID uint64, text domain.Text, _, _ string, _ domain.VoiceSettings) (*domain.Job, error) {
+	if len(f.created)+1 == f.failAt {
+		return nil, errors.New("failed to save job")
+	}
+
+	job := domain.NewJob(userID, text.FileKey)
+	f.created = append(f.created, job.ID)
+	return job, nil
+}
+
+func (f *fakeJobs) Cancel(_ context.Context, id string) (*domain.Job, error) {
+	f.cancelled = append(f.cancelled, id)
+	return &domain.Job{ID: id, Status: domain.Cancelled}, nil
+}
+
+type fakeBatches struct {
+	repository.JobMigrator
+	down  bool
+	saved []*domain.Batch
+}
+
+func (f *fakeBatches) Save(_ context.Context, batch *domain.Batch) error {
+	if f.down {
+		return errors.New("failed to save batch")
+	}
+	f.saved = append(f.saved, batch)
+	return nil
+}
+
+func (f *fakeBatches) Load(context.Context, string) (*domain.Batch, error) {
+	return nil, repository.ErrNotExist
+}
+
+func TestBatchService(t *testing.T) {
+	entries := []domain.BatchEntry{
+		{Title: "a.txt", Text: domain.Text{FileKey: "a"}},
+		{Title: "b.txt", Text: domain.Text{FileKey: "b"}},
+		{Title: "c.txt", Text: domain.Text{FileKey: "c"}},
+	}
+
+	t.Run("creates the jobs then the batch", func(t *testing.T) {
+		js, br := &fakeJobs{}, &fakeBatches{}
+		batch, jobs, err := NewBatchService(br, nil, js).New(context.Background(), 7, entries, "", domain.VoiceSettings{})
+		if err != nil {
+			t.Fatalf("Failed to create batch: %v", err)
+		}
+
+		if len(jobs) != 3 || !slices.Equal(batch.JobIDs(), js.created) || len(br.saved) != 1 {
+			t.Errorf("Expected a saved batch of the 3 jobs, got %v", batch.JobIDs())
+		}
+
+		if len(js.cancelled) != 0 {
+			t.Errorf("Expected no job cancelled, got %v", js.cancelled)
+		}
+	})
+
+	t.Run("cancels the jobs created before one fails", func(t *testing.T) {
+		js, br := &fakeJobs{failAt: 3}, &fakeBatches{}
+		if _, _, err := NewBatchService(br, nil, js).New(context.Background(), 7, entries, "", domain.VoiceSettings{}); err == nil {
+			t.Fatal("Expected the batch to fail")
+		}
+
+		if len(js.created) != 2 || !slices.Equal(js.cancelled, js.created) {
+			t.Errorf("Expected the 2 jobs created to be cancelled, got %v of %v", js.cancelled, js.created)
+		}
+
+		if len(br.saved) != 0 {
+			t.Errorf("Expected no batch saved, got %d", len(br.saved))
+		}
+	})
+
+	t.Run("cancels the jobs when the batch fails to be saved", func(t *testing.T) {
+		js, br := &fakeJobs{}, &fakeBatches{down: true}
+		if _, _, err := NewBatchService(br, nil, js).New(context.Background(), 7, entries, "", domain.VoiceSettings{}); err == nil {
+			t.Fatal("Expected the batch to fail")
+		}
+
+		if len(js.created) != 3 || !slices.Equal(js.cancelled, js.created) {
+			t.Errorf("Expected every job to be cancelled, got %v of %v", js.cancelled, js.created)
+		}
+	})
+}
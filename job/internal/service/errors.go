@@ -0,0 +1,28 @@
+package service
+
+import "errors"
+
+var (
+	ErrNoChapters      = errors.New("split job has no chapters")
+	ErrTooManyChapters = errors.New("split job has too many chapters")
+
+	ErrNoEntries      = errors.New("batch has no entries")
+	ErrTooManyEntries = errors.New("batch has too many entries")
+	ErrInvalidEntry   = errors.New("invalid batch entry")
+	ErrBatchNotFound  = errors.New("batch not found")
+
+	ErrInvalidCallbackURL = errors.New("invalid callback url")
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrDeliveryNotFound   = errors.New("delivery not found")
+
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	ErrNotCancellable = errors.New("job already completed or failed")
+	ErrCancelled      = errors.New("job cancelled")
+
+	ErrNotDeletable     = errors.New("job has not finished or is a chapter")
+	ErrPreviousNotFound = errors.New("previous version not found")
+
+	ErrNotRetryable    = errors.New("job has not failed")
+	ErrTooManyAttempts = errors.New("job has no attempts left")
+)
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobEvent is published whenever a job is created or its status changes.
+// It carries the state of the job as of that change, so the latest event of a job supersedes all before it.
+type JobEvent struct {
+	JobID    string    `json:"job_id"`
+	UserID   uint64    `json:"user_id"`
+	ParentID string    `json:"parent_id,omitempty"`
+	Status   string    `json:"job_status"`
+	FileKey  string    `json:"file_key,omitempty"`
+	Version  uint64    `json:"version"`
+	At       time.Time `json:"at"`
+}
+
+type EventPublisher interface {
+	// Publish publishes the state of the job as an event, routed by its status as "job.<status>".
+	Publish(ctx context.Context, job *domain.Job) error
+}
+
+type eventPublisher struct {
+	mu       sync.Mutex
+	con      *amqp.Connection
+	ch       *amqp.Channel
+	exchange string
+}
+
+// NewEventPublisher declares the topic exchange job events are published to.
+func NewEventPublisher(con *amqp.Connection, exchange string) (EventPublisher, error) {
+	ch, err := con.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	return &eventPublisher{
+		con:      con,
+		ch:       ch,
+		exchange: exchange,
+	}, nil
+}
+
+func (ep *eventPublisher) Publish(ctx context.Context, job *domain.Job) error {
+	body, err := json.Marshal(JobEvent{
+		JobID:    job.ID,
+		UserID:   job.UserID,
+		ParentID: job.ParentID,
+		Status:   job.Status.String(),
+		FileKey:  job.FileKey,
+		Version:  job.Version,
+		At:       job.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	// a failed publish closes the channel, so it is opened again for the next one
+	if ep.ch.IsClosed() {
+		if ep.ch, err = ep.con.Channel(); err != nil {
+			return fmt.Errorf("failed to open channel: %w", err)
+		}
+	}
+
+	return ep.ch.PublishWithContext(ctx, ep.exchange, "job."+strings.ToLower(job.Status.String()), false, false, amqp.Publishing{
+		ContentType: "application/json",
+		MessageId:   fmt.Sprintf("%s/%d", job.ID, job.Version),
+		Timestamp:   job.UpdatedAt,
+		Body:        body,
+	})
+}
@@ -2,39 +2,241 @@ package service
 
 import (
 	"context"
+	"errors"
 	"github.com/ziliscite/bard_narate/job/internal/domain"
 	"github.com/ziliscite/bard_narate/job/internal/repository"
+	"log/slog"
 )
 
+// MaxChapters is the most chapters a split job may have.
+// A split job is saved in a single transaction along with its chapters and their outbox entries.
+const MaxChapters = 32
+
+// maxRefreshAttempts is how many times the status of a split job is derived again
+// when its chapters keep changing underneath.
+const maxRefreshAttempts = 3
+
+// MaxAttempts is how many times a job may be converted, its first attempt included.
+const MaxAttempts = 3
+
 type JobService interface {
-	New(ctx context.Context, fileKey string) (*domain.Job, error)
+	// New creates a pending job for the given text on behalf of the user,
+	// recording its conversion request in the outbox in the same transaction.
+	// The callback URL, if any, is notified once the job completes or fails, instead of the user's webhook.
+	// When the same text, as told by its hash, was narrated with the same voice before, the job completes right away
+	// with the cached audio instead.
+	//
+	// The job narrating a previous version of the document, if any, is given by its id. The paragraphs it narrated
+	// with the same voice are reused rather than converted again, as listed in the manifest of the job.
+	// It returns ErrPreviousNotFound if that job doesn't exist or belongs to another user.
+	New(ctx context.Context, userID uint64, text domain.Text, previousID, callbackURL string, voice domain.VoiceSettings) (*domain.Job, error)
+	// NewSplit creates a pending job converted as the given chapters, in reading order.
+	// Each chapter is a job of its own, whose conversion request is recorded in the outbox,
+	// so that the chapters convert in parallel and fail independently.
+	// Only the split job is notified of, to the callback URL if any, and every chapter is narrated with the same voice.
+	// Chapters whose audio is cached complete right away, and chapters reuse the paragraphs of the previous version
+	// of the document, as jobs created by New do, wherever those paragraphs moved to.
+	NewSplit(ctx context.Context, userID uint64, chapters []domain.Chapter, previousID, callbackURL string, voice domain.VoiceSettings) (*domain.Job, []*domain.Job, error)
+	// NewVoiceConversion creates a pending job converting the voice of the audio file with the voice model,
+	// recording its conversion request in the outbox in the same transaction.
+	NewVoiceConversion(ctx context.Context, userID uint64, fileKey, modelKey, callbackURL string) (*domain.Job, error)
 	Get(ctx context.Context, id string) (*domain.Job, error)
+	// List returns up to limit jobs of the user, newest first, along with the cursor of the next page,
+	// which is empty on the last one. Chapters are left out, and listed along with their split job when it is fetched.
+	// It returns ErrInvalidCursor if the cursor wasn't returned by a previous listing.
+	List(ctx context.Context, userID uint64, filter domain.JobFilter, cursor string, limit int) ([]*domain.Job, string, error)
+	// GetWithChapters returns a job along with its chapters, if it is split.
+	// The status of a split job is derived from its chapters as they are read.
+	GetWithChapters(ctx context.Context, id string) (*domain.Job, []*domain.Job, error)
+	// Update saves the job. When it is a chapter, the status of its split job is derived again.
+	// Jobs that complete or fail are notified of to their webhook, whose delivery is saved along with them.
+	// It returns ErrCancelled if the job was cancelled, which stays so whatever the workers report afterwards.
 	Update(ctx context.Context, job *domain.Job) error
+	// Cancel cancels a job that hasn't finished yet, along with its unfinished chapters,
+	// recording in the outbox that the workers converting them have to stop, in the same transaction.
+	// Cancelling a cancelled job does nothing, and it returns ErrNotCancellable if the job completed or failed.
+	Cancel(ctx context.Context, id string) (*domain.Job, error)
+	// Retry converts a failed job again from the file it was submitted with, and with the same settings,
+	// recording its conversion request in the outbox in the same transaction.
+	// Only the failed chapters of a split job are converted again. Previous attempts are kept in the history of the job.
+	// It returns ErrNotRetryable if the job hasn't failed, and ErrTooManyAttempts once it was attempted MaxAttempts times.
+	Retry(ctx context.Context, id string) (*domain.Job, error)
+	// Delete deletes a finished job along with its chapters and their manifests, returning the file keys of the audio
+	// no job uses anymore, which the caller deletes. Audio shared with other jobs is kept for them,
+	// as are the segments of paragraphs, which any job may reuse.
+	// It returns ErrNotDeletable if the job hasn't finished, or is a chapter, which goes with its split job.
+	Delete(ctx context.Context, id string) ([]string, error)
 }
 
 type jobService struct {
 	jr repository.JobRepository
+	cr repository.AudioCacheRepository
+	mr repository.ManifestRepository
+	ep EventPublisher
+	ws WebhookService
 }
 
-func NewJobService(jr repository.JobRepository) JobService {
+// NewJobService publishes an event through ep whenever a job is created or its status changes,
+// and notifies ws of the jobs that finish. The audio of completed text to audio jobs is cached in cr,
+// and the paragraphs they are assembled from listed in mr.
+func NewJobService(jr repository.JobRepository, cr repository.AudioCacheRepository, mr repository.ManifestRepository, ep EventPublisher, ws WebhookService) JobService {
 	return &jobService{
 		jr: jr,
+		cr: cr,
+		mr: mr,
+		ep: ep,
+		ws: ws,
 	}
 }
 
-func (js *jobService) New(ctx context.Context, fileKey string) (*domain.Job, error) {
-	job := domain.NewJob(fileKey)
-	if err := js.jr.Save(ctx, job); err != nil {
+func (js *jobService) New(ctx context.Context, userID uint64, text domain.Text, previousID, callbackURL string, voice domain.VoiceSettings) (*domain.Job, error) {
+	if callbackURL != "" {
+		if err := ValidateCallbackURL(callbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	previous, err := js.previousSegments(ctx, userID, previousID)
+	if err != nil {
+		return nil, err
+	}
+
+	job := domain.NewJob(userID, text.FileKey)
+	job.TextHash = text.Hash
+	job.PreviousID = previousID
+	job.SetVoice(voice)
+	job.SetCallbackURL(callbackURL)
+
+	if err = js.saveManifest(ctx, job, text.Paragraphs, previous); err != nil {
 		return nil, err
 	}
 
+	if js.acquire(ctx, job) {
+		delivery, err := js.ws.Delivery(ctx, job)
+		if err == nil {
+			err = js.jr.Save(ctx, job, delivery)
+		}
+		if err != nil {
+			js.release(ctx, job)
+			return nil, err
+		}
+
+		js.publish(ctx, job)
+		js.wake(delivery)
+		return job, nil
+	}
+
+	if err := js.jr.SaveWithOutbox(ctx, job, domain.NewOutboxEntry(job.ID)); err != nil {
+		return nil, err
+	}
+
+	js.publish(ctx, job)
 	return job, nil
 }
 
+func (js *jobService) NewVoiceConversion(ctx context.Context, userID uint64, fileKey, modelKey, callbackURL string) (*domain.Job, error) {
+	if callbackURL != "" {
+		if err := ValidateCallbackURL(callbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	job := domain.NewVoiceConversionJob(userID, fileKey, modelKey)
+	job.SetCallbackURL(callbackURL)
+	if err := js.jr.SaveWithOutbox(ctx, job, domain.NewOutboxEntry(job.ID)); err != nil {
+		return nil, err
+	}
+
+	js.publish(ctx, job)
+	return job, nil
+}
+
+func (js *jobService) NewSplit(ctx context.Context, userID uint64, chapters []domain.Chapter, previousID, callbackURL string, voice domain.VoiceSettings) (*domain.Job, []*domain.Job, error) {
+	switch {
+	case len(chapters) == 0:
+		return nil, nil, ErrNoChapters
+	case len(chapters) > MaxChapters:
+		return nil, nil, ErrTooManyChapters
+	}
+
+	if callbackURL != "" {
+		if err := ValidateCallbackURL(callbackURL); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	previous, err := js.previousSegments(ctx, userID, previousID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job, children := domain.NewSplitJob(userID, chapters, voice)
+	job.PreviousID = previousID
+	job.SetCallbackURL(callbackURL)
+	for i, child := range children {
+		if err = js.saveManifest(ctx, child, chapters[i].Paragraphs, previous); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	entries := make([]*domain.OutboxEntry, 0, len(children))
+	var cached []*domain.Job
+	for _, child := range children {
+		if js.acquire(ctx, child) {
+			cached = append(cached, child)
+			continue
+		}
+		entries = append(entries, domain.NewOutboxEntry(child.ID))
+	}
+	var delivery *domain.Delivery
+	if len(cached) > 0 {
+		job.SetStatus(domain.DeriveStatus(children))
+		delivery, err = js.ws.Delivery(ctx, job)
+	}
+
+	if err == nil {
+		err = js.jr.SaveSplit(ctx, job, children, entries, delivery)
+	}
+	if err != nil {
+		for _, child := range cached {
+			js.release(ctx, child)
+		}
+		return nil, nil, err
+	}
+
+	js.publish(ctx, append([]*domain.Job{job}, children...)...)
+	js.wake(delivery)
+	return job, children, nil
+}
+
 func (js *jobService) Get(ctx context.Context, id string) (*domain.Job, error) {
 	return js.jr.Load(ctx, id)
 }
 
+func (js *jobService) List(ctx context.Context, userID uint64, filter domain.JobFilter, cursor string, limit int) ([]*domain.Job, string, error) {
+	jobs, next, err := js.jr.ListByUser(ctx, userID, filter, cursor, limit)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return nil, "", ErrInvalidCursor
+	}
+
+	return jobs, next, err
+}
+
+func (js *jobService) GetWithChapters(ctx context.Context, id string) (*domain.Job, []*domain.Job, error) {
+	job, err := js.jr.Load(ctx, id)
+	if err != nil || !job.IsSplit() {
+		return job, nil, err
+	}
+
+	chapters, err := js.jr.LoadMany(ctx, job.ChildIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job.Status = domain.DeriveStatus(chapters)
+	return job, chapters, nil
+}
+
 func (js *jobService) UpdateStatus(ctx context.Context, id string, status domain.JobStatus) error {
 	job, err := js.jr.Load(ctx, id)
 	if err != nil {
@@ -42,9 +244,342 @@ func (js *jobService) UpdateStatus(ctx context.Context, id string, status domain
 	}
 
 	job.SetStatus(status)
-	return js.jr.Update(ctx, job)
+	return js.Update(ctx, job)
 }
 
 func (js *jobService) Update(ctx context.Context, job *domain.Job) error {
-	return js.jr.Update(ctx, job)
+	delivery, err := js.delivery(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	if err = js.jr.Update(ctx, job, delivery); err != nil {
+		if errors.Is(err, repository.ErrCancelled) {
+			return ErrCancelled
+		}
+		return err
+	}
+	js.publish(ctx, job)
+	js.wake(delivery)
+	if job.Status == domain.Completed {
+		js.cache(ctx, job)
+	}
+
+	if job.ParentID != "" {
+		// the chapter is saved either way, and split jobs derive their status again when read
+		if err := js.refresh(ctx, job.ParentID); err != nil {
+			slog.Warn("Failed to refresh split job status", "job", job.ParentID, "chapter", job.ID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (js *jobService) Cancel(ctx context.Context, id string) (*domain.Job, error) {
+	for range maxRefreshAttempts {
+		job, chapters, err := js.GetWithChapters(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch job.Status {
+		case domain.Cancelled:
+			return job, nil
+		case domain.Completed, domain.Failed:
+			return nil, ErrNotCancellable
+		}
+
+		// a split job has no worker of its own, only its chapters do
+		jobs := []*domain.Job{job}
+		var entries []*domain.OutboxEntry
+		if !job.IsSplit() {
+			entries = append(entries, domain.NewCancellationEntry(job.ID))
+		}
+		for _, chapter := range chapters {
+			if !chapter.Status.Finished() {
+				jobs = append(jobs, chapter)
+				entries = append(entries, domain.NewCancellationEntry(chapter.ID))
+			}
+		}
+
+		for _, j := range jobs {
+			j.SetStatus(domain.Cancelled)
+		}
+
+		delivery, err := js.delivery(ctx, job)
+		if err != nil {
+			return nil, err
+		}
+
+		// a worker reported on the job meanwhile, whose status is read again
+		if err = js.jr.Cancel(ctx, jobs, entries, delivery); errors.Is(err, repository.ErrStatusChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		js.publish(ctx, jobs...)
+		js.wake(delivery)
+		return job, nil
+	}
+
+	return nil, repository.ErrStatusChanged
+}
+
+func (js *jobService) Retry(ctx context.Context, id string) (*domain.Job, error) {
+	for range maxRefreshAttempts {
+		job, chapters, err := js.GetWithChapters(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case job.Status != domain.Failed:
+			return nil, ErrNotRetryable
+		case job.Attempt >= MaxAttempts:
+			return nil, ErrTooManyAttempts
+		}
+
+		// a split job has no worker of its own, only its failed chapters are converted again
+		jobs := []*domain.Job{job}
+		var entries []*domain.OutboxEntry
+		if !job.IsSplit() {
+			entries = append(entries, domain.NewOutboxEntry(job.ID))
+		}
+		for _, chapter := range chapters {
+			if chapter.Status == domain.Failed {
+				jobs = append(jobs, chapter)
+				entries = append(entries, domain.NewOutboxEntry(chapter.ID))
+			}
+		}
+
+		for _, j := range jobs {
+			// jobs submitted before retries were recorded don't know which file they were submitted with
+			if !j.IsSplit() && j.InputKey == "" {
+				return nil, ErrNotRetryable
+			}
+			j.Retry()
+		}
+		if job.IsSplit() {
+			job.SetStatus(domain.DeriveStatus(chapters))
+		}
+
+		// a worker reported on the job meanwhile, whose status is read again
+		if err = js.jr.Retry(ctx, jobs, entries); errors.Is(err, repository.ErrStatusChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		js.publish(ctx, jobs...)
+		return job, nil
+	}
+
+	return nil, repository.ErrStatusChanged
+}
+
+func (js *jobService) Delete(ctx context.Context, id string) ([]string, error) {
+	for range maxRefreshAttempts {
+		job, chapters, err := js.GetWithChapters(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if job.ParentID != "" || !job.Status.Finished() {
+			return nil, ErrNotDeletable
+		}
+
+		// a job retried meanwhile is no longer finished, and is read again
+		jobs := append(chapters, job)
+		if err = js.jr.DeleteAll(ctx, jobs); errors.Is(err, repository.ErrStatusChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var unused []string
+		for _, j := range jobs {
+			if j.Status == domain.Completed && j.FileKey != "" && js.release(ctx, j) {
+				unused = append(unused, j.FileKey)
+			}
+
+			if err = js.mr.Delete(ctx, j.ID); err != nil {
+				slog.Warn("Failed to delete manifest", "job", j.ID, "error", err)
+			}
+		}
+
+		return unused, nil
+	}
+
+	return nil, repository.ErrStatusChanged
+}
+
+// previousSegments returns the keys of the segments the job of the previous version of a document converted,
+// which the versions after it reuse. Only completed jobs, or the completed chapters of a split job, have them all.
+func (js *jobService) previousSegments(ctx context.Context, userID uint64, previousID string) (map[string]bool, error) {
+	if previousID == "" {
+		return nil, nil
+	}
+
+	previous, chapters, err := js.GetWithChapters(ctx, previousID)
+	switch {
+	case errors.Is(err, repository.ErrNotExist):
+		return nil, ErrPreviousNotFound
+	case err != nil:
+		return nil, err
+	case previous.UserID != userID || previous.ParentID != "":
+		return nil, ErrPreviousNotFound
+	}
+
+	var ids []string
+	for _, j := range append(chapters, previous) {
+		if j.Status == domain.Completed && !j.IsSplit() {
+			ids = append(ids, j.ID)
+		}
+	}
+
+	manifests, err := js.mr.LoadExisting(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for _, m := range manifests {
+		for _, s := range m.Segments {
+			keys[s.Key] = true
+		}
+	}
+
+	return keys, nil
+}
+
+// saveManifest saves the manifest of a text to audio job narrating the paragraphs with the given hashes,
+// unless it was submitted without them, its whole text being converted then.
+func (js *jobService) saveManifest(ctx context.Context, job *domain.Job, paragraphs []string, previous map[string]bool) error {
+	if len(paragraphs) == 0 || job.Kind != domain.TextToAudio {
+		return nil
+	}
+
+	return js.mr.Save(ctx, domain.NewManifest(job, paragraphs, previous))
+}
+
+// acquire completes the job with the cached audio of its text, if any, reporting whether it did.
+// The cache only spares a conversion, so failing to read it is logged and the job converted as usual.
+func (js *jobService) acquire(ctx context.Context, job *domain.Job) bool {
+	key := job.CacheKey()
+	if key == "" {
+		return false
+	}
+
+	audio, err := js.cr.Acquire(ctx, key)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotExist) {
+			slog.Warn("Failed to look up cached audio", "job", job.ID, "error", err)
+		}
+		return false
+	}
+
+	job.CompleteFrom(audio)
+	return true
+}
+
+// cache caches the audio of a completed job for the jobs that narrate the same text the same way.
+// Audio already cached under the key is kept, the job keeping its own audio to itself.
+func (js *jobService) cache(ctx context.Context, job *domain.Job) {
+	key := job.CacheKey()
+	if key == "" || job.FileKey == "" {
+		return
+	}
+
+	if err := js.cr.Put(ctx, domain.NewCachedAudio(key, job.FileKey)); err != nil && !errors.Is(err, repository.ErrAlreadyExists) {
+		slog.Warn("Failed to cache audio", "job", job.ID, "error", err)
+	}
+}
+
+// release gives up the cached audio the job uses, reporting whether no other job uses its audio.
+// Audio that couldn't be released is kept rather than deleted from under the jobs that may still use it.
+func (js *jobService) release(ctx context.Context, job *domain.Job) bool {
+	key := job.CacheKey()
+	if key == "" {
+		return true
+	}
+
+	unused, err := js.cr.Release(ctx, key, job.FileKey)
+	if err != nil {
+		slog.Error("Failed to release cached audio", "job", job.ID, "file", job.FileKey, "error", err)
+		return false
+	}
+
+	return unused
+}
+
+// publish publishes the events of the jobs.
+// Events are only a notification, the saved jobs staying the source of truth, so failures are logged and ignored.
+func (js *jobService) publish(ctx context.Context, jobs ...*domain.Job) {
+	for _, job := range jobs {
+		if err := js.ep.Publish(ctx, job); err != nil {
+			slog.Warn("Failed to publish job event", "job", job.ID, "status", job.Status.String(), "error", err)
+		}
+	}
+}
+
+// delivery returns the webhook delivery of a job finishing with an update, saved along with it, if any.
+// Its payload has the version the update gives the job.
+func (js *jobService) delivery(ctx context.Context, job *domain.Job) (*domain.Delivery, error) {
+	updated := *job
+	updated.Version++
+	return js.ws.Delivery(ctx, &updated)
+}
+
+// wake has the delivery saved along with a job, if any, sent right away.
+func (js *jobService) wake(delivery *domain.Delivery) {
+	if delivery != nil {
+		js.ws.Wake()
+	}
+}
+
+// refresh derives the status of a split job from its chapters and saves it.
+// Chapters update concurrently, so the status is only saved if no one else changed it meanwhile,
+// and derived again from fresh chapters otherwise.
+func (js *jobService) refresh(ctx context.Context, id string) error {
+	for range maxRefreshAttempts {
+		job, err := js.jr.Load(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		chapters, err := js.jr.LoadMany(ctx, job.ChildIDs)
+		if err != nil {
+			return err
+		}
+
+		// cancelled split jobs stay so, whatever their chapters were doing
+		from := job.Status
+		if from == domain.Cancelled {
+			return nil
+		}
+
+		if status := domain.DeriveStatus(chapters); status != from {
+			job.SetStatus(status)
+
+			var delivery *domain.Delivery
+			if delivery, err = js.delivery(ctx, job); err != nil {
+				return err
+			}
+
+			if err = js.jr.UpdateStatusFrom(ctx, job, from, delivery); err == nil {
+				js.publish(ctx, job)
+				js.wake(delivery)
+			}
+		}
+
+		if !errors.Is(err, repository.ErrStatusChanged) {
+			return err
+		}
+	}
+
+	return repository.ErrStatusChanged
 }
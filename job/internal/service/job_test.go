@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+	"github.com/ziliscite/bard_narate/job/internal/repository"
+)
+
+// fakeJobRepository records the deliveries jobs are updated along with.
+type fakeJobRepository struct {
+	repository.JobRepository
+	updated    []*domain.Job
+	deliveries []*domain.Delivery
+}
+
+func (f *fakeJobRepository) Update(_ context.Context, job *domain.Job, delivery *domain.Delivery) error {
+	job.Version++
+	f.updated = append(f.updated, job)
+	f.deliveries = append(f.deliveries, delivery)
+	return nil
+}
+
+type fakePublisher struct{}
+
+func (fakePublisher) Publish(context.Context, *domain.Job) error {
+	return nil
+}
+
+// brokenWebhooks fails to load any webhook.
+type brokenWebhooks struct {
+	fakeWebhooks
+}
+
+func (*brokenWebhooks) Load(context.Context, uint64) (*domain.Webhook, error) {
+	return nil, errors.New("failed to load webhook")
+}
+
+func TestJobServiceUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("saves the delivery of a finished job along with it", func(t *testing.T) {
+		rc := &receiver{}
+		ws, _, srv := newTestWebhookService(t, rc)
+		if _, err := ws.Register(ctx, 1, srv.URL); err != nil {
+			t.Fatalf("Failed to register webhook: %v", err)
+		}
+
+		jr := &fakeJobRepository{}
+		js := NewJobService(jr, nil, nil, fakePublisher{}, ws)
+
+		job := finishedJob(1, domain.Failed)
+		if err := js.Update(ctx, job); err != nil {
+			t.Fatalf("Failed to update job: %v", err)
+		}
+
+		delivery := jr.deliveries[0]
+		if delivery == nil || delivery.ID != domain.DeliveryID(job.ID, job.Attempt, EventJobFailed) {
+			t.Fatalf("Expected the delivery of the failed job, got %+v", delivery)
+		}
+
+		var payload WebhookPayload
+		if err := json.Unmarshal(delivery.Payload, &payload); err != nil {
+			t.Fatalf("Failed to decode payload: %v", err)
+		}
+
+		if payload.Job.Version != job.Version {
+			t.Errorf("Expected the payload to have the saved version %d, got %d", job.Version, payload.Job.Version)
+		}
+	})
+
+	t.Run("saves unfinished jobs without a delivery", func(t *testing.T) {
+		ws, _, _ := newTestWebhookService(t, &receiver{})
+		jr := &fakeJobRepository{}
+
+		if err := NewJobService(jr, nil, nil, fakePublisher{}, ws).Update(ctx, finishedJob(1, domain.Converting)); err != nil {
+			t.Fatalf("Failed to update job: %v", err)
+		}
+
+		if jr.deliveries[0] != nil {
+			t.Errorf("Expected no delivery, got %+v", jr.deliveries[0])
+		}
+	})
+
+	t.Run("doesn't save a finished job whose delivery can't be made", func(t *testing.T) {
+		ws := NewWebhookService(&brokenWebhooks{}, &fakeDeliveries{}, nil)
+		jr := &fakeJobRepository{}
+
+		if err := NewJobService(jr, nil, nil, fakePublisher{}, ws).Update(ctx, finishedJob(1, domain.Completed)); err == nil {
+			t.Fatal("Expected the update to fail")
+		}
+
+		if len(jr.updated) != 0 {
+			t.Errorf("Expected no job saved, got %d", len(jr.updated))
+		}
+	})
+}
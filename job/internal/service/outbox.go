@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+	"github.com/ziliscite/bard_narate/job/internal/repository"
+)
+
+const (
+	// claimBackoff is how long the first claim of an entry leases it.
+	// Each further claim doubles it, up to maxClaimBackoff, so failing publishes are retried less and less often.
+	claimBackoff    = 10 * time.Second
+	maxClaimBackoff = 5 * time.Minute
+)
+
+// ClaimedEntry is an outbox entry leased for publishing, along with the job it publishes.
+type ClaimedEntry struct {
+	Entry *domain.OutboxEntry
+	Job   *domain.Job
+	// Manifest lists the paragraphs the audio of a text to audio job is assembled from,
+	// and is nil for jobs converted as a whole.
+	Manifest *domain.Manifest
+}
+
+type OutboxService interface {
+	// Claim leases up to limit due outbox entries for publishing.
+	// An entry that is not acknowledged before its lease runs out is handed out again.
+	Claim(ctx context.Context, limit int) ([]ClaimedEntry, error)
+	// Ack marks published entries as sent.
+	Ack(ctx context.Context, ids []string) error
+}
+
+type outboxService struct {
+	or repository.OutboxRepository
+	jr repository.JobRepository
+	mr repository.ManifestRepository
+}
+
+func NewOutboxService(or repository.OutboxRepository, jr repository.JobRepository, mr repository.ManifestRepository) OutboxService {
+	return &outboxService{
+		or: or,
+		jr: jr,
+		mr: mr,
+	}
+}
+
+func (ob *outboxService) Claim(ctx context.Context, limit int) ([]ClaimedEntry, error) {
+	now := time.Now()
+	entries, err := ob.or.Due(ctx, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]ClaimedEntry, 0, len(entries))
+	for _, entry := range entries {
+		job, err := ob.jr.Load(ctx, entry.JobID)
+		switch {
+		case errors.Is(err, repository.ErrNotExist):
+			slog.Warn("Dropping outbox entry of missing job", "entry", entry.ID, "job", entry.JobID)
+			err = ob.or.MarkSent(ctx, entry.ID)
+		case err != nil:
+		case entry.Kind == domain.OutboxConversion && job.Status != domain.Pending:
+			// an earlier publish went through but was never acknowledged, and a worker has picked the job up,
+			// or the job was cancelled before any did
+			err = ob.or.MarkSent(ctx, entry.ID)
+		default:
+			var manifest *domain.Manifest
+			if manifest, err = ob.manifest(ctx, entry, job); err != nil {
+				break
+			}
+
+			if err = ob.or.Claim(ctx, entry, now.Add(backoff(entry.Attempts))); err == nil {
+				claimed = append(claimed, ClaimedEntry{Entry: entry, Job: job, Manifest: manifest})
+			}
+		}
+
+		// the entry is left for a later claim
+		if err != nil && !errors.Is(err, repository.ErrAlreadyClaimed) {
+			slog.Error("Failed to claim outbox entry", "entry", entry.ID, "error", err)
+		}
+	}
+
+	return claimed, nil
+}
+
+func (ob *outboxService) Ack(ctx context.Context, ids []string) error {
+	var errs []error
+	for _, id := range ids {
+		if err := ob.or.MarkSent(ctx, id); err != nil && !errors.Is(err, repository.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// manifest returns the manifest a conversion request is published with, if the job has one.
+func (ob *outboxService) manifest(ctx context.Context, entry *domain.OutboxEntry, job *domain.Job) (*domain.Manifest, error) {
+	if entry.Kind != domain.OutboxConversion || job.Kind != domain.TextToAudio {
+		return nil, nil
+	}
+
+	manifest, err := ob.mr.Load(ctx, job.ID)
+	if errors.Is(err, repository.ErrNotExist) {
+		return nil, nil
+	}
+
+	return manifest, err
+}
+
+// backoff returns the lease of an entry claimed the given number of times before.
+func backoff(attempts int) time.Duration {
+	d := claimBackoff
+	for range attempts {
+		if d >= maxClaimBackoff {
+			return maxClaimBackoff
+		}
+		d *= 2
+	}
+
+	return min(d, maxClaimBackoff)
+}
@@ -0,0 +1,484 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ziliscite/bard_narate/job/internal/domain"
+	"github.com/ziliscite/bard_narate/job/internal/repository"
+)
+
+const (
+	// SignatureHeader carries the signature of a delivery, as "t=<unix seconds>,v1=<hex HMAC-SHA256>".
+	// The HMAC is keyed with the webhook secret, over the timestamp and the body joined by a dot,
+	// so receivers can reject deliveries replayed by anyone but the service.
+	SignatureHeader = "X-Bard-Signature"
+	// DeliveryHeader carries the ID of a delivery, which is the same for every attempt at it.
+	DeliveryHeader = "X-Bard-Delivery"
+	// EventHeader carries the event of a delivery.
+	EventHeader = "X-Bard-Event"
+
+	EventJobCompleted = "job.completed"
+	EventJobFailed    = "job.failed"
+	EventJobCancelled = "job.cancelled"
+
+	// deliveryTimeout bounds a single attempt at a delivery, receivers being expected to answer quickly.
+	deliveryTimeout = 10 * time.Second
+	// deliveryLease is how long a claimed delivery is left to its dispatcher, which outlasts any attempt.
+	deliveryLease = 3 * deliveryTimeout
+	// retryBackoff is how long a delivery waits after its first failed attempt.
+	// Each further attempt doubles it, up to maxRetryBackoff.
+	retryBackoff    = 30 * time.Second
+	maxRetryBackoff = time.Hour
+	// maxDeliveryTries is how many attempts a delivery gets before it is given up on, a few hours after the first.
+	maxDeliveryTries = 10
+	// maxLoggedAttempts is how many of the latest attempts of a delivery are kept in its log.
+	maxLoggedAttempts = 20
+	// dispatchBatch is how many due deliveries are claimed, and sent concurrently, at a time.
+	dispatchBatch = 10
+	// dispatchInterval is how often due deliveries are looked for, retries being due in between nudges.
+	dispatchInterval = 5 * time.Second
+	// maxResponseSize is how much of a receiver's response is read, so that the connection can be reused.
+	maxResponseSize = 64 << 10
+)
+
+// ErrForbiddenAddress is returned when a callback URL resolves to an address inside the network of the service.
+var ErrForbiddenAddress = errors.New("address is not public")
+
+// WebhookPayload is the body of a delivery.
+type WebhookPayload struct {
+	// ID is the ID of the delivery, receivers may use it to ignore the ones they got already.
+	ID        string     `json:"id"`
+	Event     string     `json:"event"`
+	CreatedAt time.Time  `json:"created_at"`
+	Job       WebhookJob `json:"job"`
+}
+
+// WebhookJob is the state of a job as of the event it is delivered with.
+type WebhookJob struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	Title      string    `json:"title,omitempty"`
+	ChapterIDs []string  `json:"chapter_ids,omitempty"`
+	Version    uint64    `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Attempt is the attempt at the job the event ended, starting from 1.
+	Attempt int `json:"attempt"`
+}
+
+type WebhookService interface {
+	// Register sets the URL the user's jobs are notified to, unless they were submitted with a callback URL.
+	// The webhook is created, along with its secret, on first registration.
+	Register(ctx context.Context, userID uint64, rawURL string) (*domain.Webhook, error)
+	// Get returns the webhook of the user, or ErrWebhookNotFound.
+	Get(ctx context.Context, userID uint64) (*domain.Webhook, error)
+	// Remove deletes the webhook of the user, secret included.
+	// Pending deliveries are given up on, and jobs submitted with a callback URL afterwards are signed with a new secret.
+	Remove(ctx context.Context, userID uint64) error
+	// Delivery returns the delivery of a job completing, failing or being cancelled, to its callback URL or else
+	// to the user's webhook, which the job is saved along with. The job is given as the save leaves it.
+	// Chapters and unfinished jobs are not notified of, nor jobs of users without anywhere to notify,
+	// for which it returns nil. A job is notified of once per event and attempt, the delivery having the same ID
+	// however many times the job is saved with the same status.
+	Delivery(ctx context.Context, job *domain.Job) (*domain.Delivery, error)
+	// Wake sends due deliveries now, rather than at the next interval, once a delivery was saved.
+	Wake()
+	// Deliveries returns up to limit deliveries of the user, newest first, only those of the given job if any.
+	Deliveries(ctx context.Context, userID uint64, jobID string, limit int) ([]*domain.Delivery, error)
+	// Replay sends a delivery of the user again, whether it was received or given up on, with a new signature.
+	Replay(ctx context.Context, userID uint64, id string) (*domain.Delivery, error)
+	// Run sends due deliveries every interval, and whenever one is queued, until the context is done.
+	Run(ctx context.Context)
+}
+
+type webhookService struct {
+	wr    repository.WebhookRepository
+	dr    repository.DeliveryRepository
+	cl    *http.Client
+	nudge chan struct{}
+}
+
+// NewWebhookService sends deliveries with the given client, see NewWebhookClient.
+func NewWebhookService(wr repository.WebhookRepository, dr repository.DeliveryRepository, cl *http.Client) WebhookService {
+	return &webhookService{
+		wr:    wr,
+		dr:    dr,
+		cl:    cl,
+		nudge: make(chan struct{}, 1),
+	}
+}
+
+// NewWebhookClient returns a client that refuses to connect to loopback, private or otherwise non-public addresses,
+// so that callback URLs can't be used to reach into the network the service runs in.
+// Redirects aren't followed, a receiver answering with one failing the attempt.
+func NewWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: deliveryTimeout,
+		// checked on the resolved address being dialed, so a host name can't resolve to another in between
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+
+			if ip = ip.Unmap(); !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: deliveryTimeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// ValidateCallbackURL checks that a URL may be delivered to, being absolute and over https.
+func ValidateCallbackURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w: %w", ErrInvalidCallbackURL, err)
+	case u.Scheme != "https":
+		return fmt.Errorf("%w: scheme must be https", ErrInvalidCallbackURL)
+	case u.Hostname() == "":
+		return fmt.Errorf("%w: host is required", ErrInvalidCallbackURL)
+	case u.User != nil:
+		return fmt.Errorf("%w: credentials are not allowed", ErrInvalidCallbackURL)
+	}
+
+	return nil
+}
+
+func (ws *webhookService) Register(ctx context.Context, userID uint64, rawURL string) (*domain.Webhook, error) {
+	if err := ValidateCallbackURL(rawURL); err != nil {
+		return nil, err
+	}
+
+	webhook, err := ws.wr.Load(ctx, userID)
+	switch {
+	case errors.Is(err, repository.ErrNotExist):
+		webhook = domain.NewWebhook(userID, rawURL)
+		switch err = ws.wr.Create(ctx, webhook); {
+		case err == nil:
+			return webhook, nil
+		case !errors.Is(err, repository.ErrAlreadyExists):
+			return nil, err
+		}
+
+		// registered concurrently, the URL is set on the webhook that won
+		if webhook, err = ws.wr.Load(ctx, userID); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	webhook.SetURL(rawURL)
+	if err = ws.wr.Update(ctx, webhook); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (ws *webhookService) Get(ctx context.Context, userID uint64) (*domain.Webhook, error) {
+	webhook, err := ws.wr.Load(ctx, userID)
+	if errors.Is(err, repository.ErrNotExist) {
+		return nil, ErrWebhookNotFound
+	}
+
+	return webhook, err
+}
+
+func (ws *webhookService) Remove(ctx context.Context, userID uint64) error {
+	if err := ws.wr.Delete(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrNotExist) {
+			return ErrWebhookNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (ws *webhookService) Delivery(ctx context.Context, job *domain.Job) (*domain.Delivery, error) {
+	var event string
+	switch {
+	case job.ParentID != "":
+		return nil, nil
+	case job.Status == domain.Completed:
+		event = EventJobCompleted
+	case job.Status == domain.Failed:
+		event = EventJobFailed
+	case job.Status == domain.Cancelled:
+		event = EventJobCancelled
+	default:
+		return nil, nil
+	}
+
+	webhook, err := ws.webhook(ctx, job)
+	if err != nil || webhook == nil {
+		return nil, err
+	}
+
+	target := job.CallbackURL
+	if target == "" {
+		target = webhook.URL
+	}
+
+	id := domain.DeliveryID(job.ID, job.Attempt, event)
+	payload, err := json.Marshal(WebhookPayload{
+		ID:        id,
+		Event:     event,
+		CreatedAt: time.Now(),
+		Job: WebhookJob{
+			ID:         job.ID,
+			Status:     job.Status.String(),
+			Title:      job.Title,
+			ChapterIDs: job.ChildIDs,
+			Version:    job.Version,
+			UpdatedAt:  job.UpdatedAt,
+			Attempt:    job.Attempt,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return domain.NewDelivery(job.UserID, job.ID, job.Attempt, target, event, payload), nil
+}
+
+// webhook returns the webhook whose secret signs the deliveries of the job.
+// Jobs submitted with a callback URL by users without a webhook get one, with a secret but no URL of its own.
+// It returns nil when the job has nowhere to be delivered to.
+func (ws *webhookService) webhook(ctx context.Context, job *domain.Job) (*domain.Webhook, error) {
+	webhook, err := ws.wr.Load(ctx, job.UserID)
+	switch {
+	case err == nil:
+		if job.CallbackURL == "" && webhook.URL == "" {
+			return nil, nil
+		}
+		return webhook, nil
+	case !errors.Is(err, repository.ErrNotExist):
+		return nil, err
+	case job.CallbackURL == "":
+		return nil, nil
+	}
+
+	webhook = domain.NewWebhook(job.UserID, "")
+	if err = ws.wr.Create(ctx, webhook); errors.Is(err, repository.ErrAlreadyExists) {
+		return ws.wr.Load(ctx, job.UserID)
+	}
+
+	return webhook, err
+}
+
+func (ws *webhookService) Deliveries(ctx context.Context, userID uint64, jobID string, limit int) ([]*domain.Delivery, error) {
+	return ws.dr.ListByUser(ctx, userID, jobID, limit)
+}
+
+func (ws *webhookService) Replay(ctx context.Context, userID uint64, id string) (*domain.Delivery, error) {
+	delivery, err := ws.dr.Load(ctx, id)
+	switch {
+	case errors.Is(err, repository.ErrNotExist):
+		return nil, ErrDeliveryNotFound
+	case err != nil:
+		return nil, err
+	case delivery.UserID != userID:
+		return nil, ErrDeliveryNotFound
+	}
+
+	delivery.Replay()
+	if err = ws.dr.Update(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	ws.Wake()
+	return delivery, nil
+}
+
+func (ws *webhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ws.nudge:
+		}
+
+		ws.drain(ctx, time.Now())
+	}
+}
+
+func (ws *webhookService) Wake() {
+	select {
+	case ws.nudge <- struct{}{}:
+	default: // a drain is already pending
+	}
+}
+
+// drain sends the deliveries due as of now until a batch comes back short.
+func (ws *webhookService) drain(ctx context.Context, now time.Time) {
+	for ctx.Err() == nil {
+		due, err := ws.dr.Due(ctx, now, dispatchBatch)
+		if err != nil {
+			slog.Error("Failed to get due deliveries", "error", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range due {
+			// the delivery is left to whoever claimed it first
+			if err = ws.dr.Claim(ctx, delivery, now.Add(deliveryLease)); err != nil {
+				if !errors.Is(err, repository.ErrAlreadyClaimed) {
+					slog.Error("Failed to claim delivery", "delivery", delivery.ID, "error", err)
+				}
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ws.dispatch(ctx, delivery, now)
+			}()
+		}
+		wg.Wait()
+
+		if len(due) < dispatchBatch {
+			return
+		}
+	}
+}
+
+// dispatch makes an attempt at a claimed delivery, and saves its outcome.
+// Failed attempts are retried with a backoff growing with every one of them, until the delivery is given up on.
+func (ws *webhookService) dispatch(ctx context.Context, delivery *domain.Delivery, now time.Time) {
+	attempt := ws.send(ctx, delivery, now)
+	delivery.Record(attempt, maxLoggedAttempts)
+
+	switch {
+	case attempt.Error == "" && attempt.StatusCode >= 200 && attempt.StatusCode < 300:
+		delivery.SetStatus(domain.DeliveryDelivered)
+	case errors.Is(ctx.Err(), context.Canceled):
+		// shutting down, the attempt is made again once the lease runs out
+		return
+	case delivery.Tries >= maxDeliveryTries:
+		delivery.SetStatus(domain.DeliveryFailed)
+	default:
+		delivery.NextAttemptAt = now.Add(deliveryBackoff(delivery.Tries))
+	}
+
+	if err := ws.dr.Update(ctx, delivery); err != nil {
+		slog.Error("Failed to save delivery attempt", "delivery", delivery.ID, "error", err)
+	}
+}
+
+// send POSTs the delivery, signed with the current secret of its user's webhook.
+func (ws *webhookService) send(ctx context.Context, delivery *domain.Delivery, now time.Time) domain.DeliveryAttempt {
+	start := time.Now()
+	attempt := func(status int, err error) domain.DeliveryAttempt {
+		a := domain.DeliveryAttempt{At: start, StatusCode: status, Duration: time.Since(start)}
+		if err != nil {
+			a.Error = err.Error()
+		}
+		return a
+	}
+
+	webhook, err := ws.wr.Load(ctx, delivery.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotExist) {
+			// no secret is left to sign with, so the delivery can never be made
+			delivery.Tries = maxDeliveryTries
+			return attempt(0, errors.New("webhook was removed"))
+		}
+		return attempt(0, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return attempt(0, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Bard-Webhook/1")
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, now, delivery.Payload))
+
+	resp, err := ws.cl.Do(req)
+	if err != nil {
+		return attempt(0, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return attempt(resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status))
+	}
+
+	return attempt(resp.StatusCode, nil)
+}
+
+// Sign returns the signature header of a body sent at the given time.
+func Sign(secret string, at time.Time, body []byte) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryBackoff returns how long a delivery waits after the given number of failed attempts.
+func deliveryBackoff(tries int) time.Duration {
+	d := retryBackoff
+	for range tries - 1 {
+		if d >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+		d *= 2
+	}
+
+	return min(d, maxRetryBackoff)
+}
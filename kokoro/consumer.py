@@ -2,13 +2,18 @@ import os
 import json
 import logging
 import tempfile
+import threading
+import time
 import pika
 import boto3
+from collections import OrderedDict
 from pathlib import Path
 from pika import PlainCredentials
-from typing import Dict, Any
+from typing import Dict, Any, List
+import numpy as np
+import soundfile as sf
 from kokoro import KPipeline
-from inference import Inference
+from inference import Cancelled, Inference
 
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
@@ -26,12 +31,15 @@ class Config:
         self.output_queue = os.getenv("RABBITMQ_OUTPUT_QUEUE", "s3_converting_queue")
         self.input_routing_key = os.getenv("RABBITMQ_INPUT_ROUTING_KEY", "s3_file_key")
         self.output_routing_key = os.getenv("RABBITMQ_OUTPUT_ROUTING_KEY", "processed_file_key")
+        self.cancel_routing_key = os.getenv("RABBITMQ_CANCEL_ROUTING_KEY", "job.cancel")
 
         self.aws_access_key = os.getenv("AWS_ACCESS_KEY_ID")
         self.aws_secret_key = os.getenv("AWS_SECRET_ACCESS_KEY")
         self.aws_region = os.getenv("AWS_REGION", "us-east-1")
         self.s3_bucket = os.getenv("S3_BUCKET_NAME")
         self.processed_prefix = os.getenv("S3_PROCESSED_PREFIX", "processed/")
+        # the audio of single paragraphs, shared by every job narrating them the same way
+        self.chunk_prefix = os.getenv("S3_CHUNK_PREFIX", "chunks/")
 
         self._validate()
 
@@ -97,6 +105,45 @@ class S3Client:
             temp_file.close()
             os.unlink(temp_file.name)
 
+    def download_chunk(self, segment_key: str, path: str) -> bool:
+        """Download the audio of a paragraph to path, returning False if it isn't stored"""
+        try:
+            self._client.download_file(
+                Bucket=self.config.s3_bucket,
+                Key=f"{self.config.chunk_prefix}{segment_key}.wav",
+                Filename=path
+            )
+            return True
+        except Exception as e:
+            logger.warning(f"Failed to download segment {segment_key}: {str(e)}")
+            return False
+
+    def upload_chunk(self, path: str, segment_key: str):
+        """Store the audio of a paragraph for the jobs narrating it later"""
+        self._client.upload_file(
+            Filename=path,
+            Bucket=self.config.s3_bucket,
+            Key=f"{self.config.chunk_prefix}{segment_key}.wav"
+        )
+
+    def delete(self, key: str):
+        """Delete an object that is no longer needed"""
+        self._client.delete_object(Bucket=self.config.s3_bucket, Key=key)
+        logger.info(f"Deleted {key}")
+
+def connection_parameters(config: Config) -> pika.ConnectionParameters:
+    """RabbitMQ connection parameters of the configuration"""
+    credentials = PlainCredentials(
+        username=config.rabbitmq_user,
+        password=config.rabbitmq_pass
+    )
+
+    return pika.ConnectionParameters(
+        host=config.rabbitmq_host,
+        port=config.rabbitmq_port,
+        credentials=credentials
+    )
+
 class RabbitMQClient:
     """Handles RabbitMQ connection and messaging"""
     
@@ -108,18 +155,7 @@ class RabbitMQClient:
 
     def _connect(self):
         """Establish RabbitMQ connection and channel"""
-        credentials = PlainCredentials(
-            username=self.config.rabbitmq_user,
-            password=self.config.rabbitmq_pass
-        )
-
-        parameters = pika.ConnectionParameters(
-            host=self.config.rabbitmq_host,
-            port=self.config.rabbitmq_port,
-            credentials=credentials
-        )
-
-        self._connection = pika.BlockingConnection(parameters)
+        self._connection = pika.BlockingConnection(connection_parameters(self.config))
         self._channel = self._connection.channel()
         self._setup()
 
@@ -181,6 +217,59 @@ class RabbitMQClient:
             self._connection.close()
             logger.info("RabbitMQ connection closed")
 
+class CancellationListener(threading.Thread):
+    """Keeps track of the jobs cancelled while the worker runs, on a connection of its own"""
+
+    def __init__(self, config: Config, remembered: int = 10000):
+        super().__init__(daemon=True)
+        self.config = config
+        self.remembered = remembered
+        self._cancelled: OrderedDict[str, None] = OrderedDict()
+        self._lock = threading.Lock()
+
+    def run(self):
+        """Listen for cancellations, reconnecting whenever the connection drops"""
+        while True:
+            try:
+                connection = pika.BlockingConnection(connection_parameters(self.config))
+                channel = connection.channel()
+                channel.exchange_declare(
+                    exchange=self.config.exchange_name,
+                    exchange_type="topic",
+                    durable=True
+                )
+
+                # every worker gets every cancellation, on a queue that goes away with it
+                queue = channel.queue_declare(queue="", exclusive=True).method.queue
+                channel.queue_bind(
+                    exchange=self.config.exchange_name,
+                    queue=queue,
+                    routing_key=self.config.cancel_routing_key
+                )
+
+                channel.basic_consume(queue=queue, on_message_callback=self._on_message, auto_ack=True)
+                channel.start_consuming()
+            except Exception as e:
+                logger.error(f"Cancellation listener disconnected: {e}")
+                time.sleep(5)
+
+    def _on_message(self, ch, method, properties, body):
+        try:
+            job_id = json.loads(body)["job_id"]
+        except (ValueError, KeyError):
+            logger.warning(f"Ignoring malformed cancellation: {body!r}")
+            return
+
+        logger.info(f"Job {job_id} cancelled")
+        with self._lock:
+            self._cancelled[job_id] = None
+            while len(self._cancelled) > self.remembered:
+                self._cancelled.popitem(last=False)
+
+    def is_cancelled(self, job_id: str) -> bool:
+        with self._lock:
+            return job_id in self._cancelled
+
 # bikin job consumer, dia consume di semua topic, routing key file.#. ambil id sama status
 # tiap consume, ya update ae job dg id yang masuk sesuai dengan status.
 #
@@ -193,10 +282,20 @@ class RabbitMQClient:
 class FileProcessor:
     """Orchestrates file processing workflow"""
     
-    def __init__(self, s3_client: S3Client, mq_client: RabbitMQClient, infer: Inference):
+    def __init__(self, s3_client: S3Client, mq_client: RabbitMQClient, cancellations: CancellationListener, output_dir: str):
         self.s3_client = s3_client
         self.mq_client = mq_client
-        self.inference = infer
+        self.cancellations = cancellations
+        self.output_dir = output_dir
+        # one pipeline per language, loaded the first time a job asks for it
+        self.inferences: Dict[str, Inference] = {}
+
+    def _inference(self, lang_code: str) -> Inference:
+        """Return the inference of a language, loading its pipeline if needed"""
+        if lang_code not in self.inferences:
+            logger.info(f"Loading pipeline for language {lang_code}")
+            self.inferences[lang_code] = Inference(KPipeline(lang_code=lang_code, trf=True), self.output_dir)
+        return self.inferences[lang_code]
 
     def _process_message(self, ch, method, properties, body):
         """Handle incoming message processing"""
@@ -206,18 +305,44 @@ class FileProcessor:
 
             job_id = message["job_id"] # do something w ts
 
-            logger.info(f"Processing file: {original_key}")
+            # the gateway resolves the settings, older messages go without them
+            voice = message.get("voice") or "af_bella"
+            speed = float(message.get("speed") or 1.0)
+            lang_code = message.get("lang_code") or "a"
+            # one per paragraph, those reused being downloaded rather than converted
+            segments = message.get("segments") or []
+            # the silence between paragraphs, as configured on the gateway
+            paragraph_pause = int(message.get("paragraph_pause_ms") or 0) / 1000
+
+            if self.cancellations.is_cancelled(job_id):
+                logger.info(f"Skipping cancelled job {job_id}")
+                ch.basic_ack(delivery_tag=method.delivery_tag)
+                return
+
+            logger.info(f"Processing file: {original_key} with voice {voice} at {speed}x")
 
             # Temp file is the input file
             with self.s3_client.download_to_tempfile(original_key) as temp_file:
-                # Process the file
-                outfile = self._process_file(temp_file)
+                # Process the file, stopping as soon as the job is cancelled
+                try:
+                    outfile = self._process_file(temp_file, voice, speed, lang_code, job_id, segments, paragraph_pause)
+                except Cancelled:
+                    logger.info(f"Stopped cancelled job {job_id}")
+                    ch.basic_ack(delivery_tag=method.delivery_tag)
+                    return
 
                 # Upload processed file and get new key
                 processed_key = self.s3_client.upload_from_tempfile(
                     outfile, original_key
                 )
 
+                # cancelled while uploading, so the output is thrown away
+                if self.cancellations.is_cancelled(job_id):
+                    self.s3_client.delete(processed_key)
+                    logger.info(f"Discarded output of cancelled job {job_id}")
+                    ch.basic_ack(delivery_tag=method.delivery_tag)
+                    return
+
                 # Publish result
                 # Since it has been processed, we can update the job status to Converting
                 self.mq_client.publish_message({"job_id": job_id, "job_status": "Converting", "file_key": processed_key})
@@ -229,13 +354,81 @@ class FileProcessor:
             logger.error(f"Error processing {message.get('key')}: {str(e)}", exc_info=True)
             ch.basic_ack(delivery_tag=method.delivery_tag)
 
-    def _process_file(self, temp_file) -> tempfile._TemporaryFileWrapper[bytes]:
+    def _process_file(self, temp_file, voice: str, speed: float, lang_code: str, job_id: str,
+                      segments: List[Dict[str, Any]], paragraph_pause: float) -> tempfile._TemporaryFileWrapper[bytes]:
         """Convert text into audio and store it. Return absolute filepath"""
         temp_out_file = tempfile.NamedTemporaryFile(suffix=".wav", delete=False)
+        text = open(temp_file.name, 'r').read()
+
+        # the gateway writes a paragraph per line, with a blank line between them
+        paragraphs = text.strip().split("\n\n")
+        if segments and len(paragraphs) != len(segments):
+            logger.warning(f"Job {job_id} has {len(segments)} segments for {len(paragraphs)} paragraphs, converting it whole")
+            segments = []
+
+        try:
+            if segments:
+                self._process_segments(Path(temp_out_file.name), paragraphs, segments, voice, speed, lang_code, job_id, paragraph_pause)
+            else:
+                self._inference(lang_code).generate(
+                    Path(temp_out_file.name), text, voice, speed,
+                    cancelled=lambda: self.cancellations.is_cancelled(job_id)
+                )
+        except Exception:
+            # the partial audio never leaves the worker
+            temp_out_file.close()
+            os.unlink(temp_out_file.name)
+            raise
 
-        self.inference.generate(temp_out_file.name, open(temp_file.name, 'r').read())
         return temp_out_file
 
+    def _process_segments(self, output_file: Path, paragraphs: List[str], segments: List[Dict[str, Any]],
+                          voice: str, speed: float, lang_code: str, job_id: str, paragraph_pause: float):
+        """
+        Assemble the audio of the paragraphs from their segments, downloading those that are reused
+        and converting the others, which are stored for the jobs reusing them later.
+        The paragraphs are separated by paragraph_pause seconds of silence.
+
+        The worker assembles them rather than the gateway, so that a job has a single audio file to store,
+        cache and serve with Range support, its segments only being read back by the jobs reusing them.
+        The format is that of the first segment, which every segment shares as they are narrated by the same pipeline.
+        """
+        cancelled = lambda: self.cancellations.is_cancelled(job_id)
+        chunk_file = tempfile.NamedTemporaryFile(suffix=".wav", delete=False)
+        chunk_file.close()
+        reused = 0
+        out = None
+
+        try:
+            for paragraph, segment in zip(paragraphs, segments):
+                if cancelled():
+                    raise Cancelled()
+
+                # a reused segment that went missing is converted again
+                if segment.get("reused") and self.s3_client.download_chunk(segment["key"], chunk_file.name):
+                    reused += 1
+                else:
+                    self._inference(lang_code).generate(Path(chunk_file.name), paragraph, voice, speed, cancelled=cancelled)
+                    self.s3_client.upload_chunk(chunk_file.name, segment["key"])
+
+                with sf.SoundFile(chunk_file.name) as chunk:
+                    if out is None:
+                        out = sf.SoundFile(str(output_file), mode='w', samplerate=chunk.samplerate,
+                                           channels=chunk.channels, subtype=chunk.subtype)
+                    elif paragraph_pause > 0:
+                        out.write(np.zeros((int(out.samplerate * paragraph_pause), out.channels), dtype='int16'))
+
+                    if (chunk.samplerate, chunk.channels) != (out.samplerate, out.channels):
+                        raise RuntimeError(f"Segment {segment['key']} is {chunk.samplerate} Hz with {chunk.channels} channels "
+                                           f"rather than {out.samplerate} Hz with {out.channels}")
+                    out.write(chunk.read(dtype='int16', always_2d=True))
+
+            logger.info(f"Job {job_id} reused {reused} of {len(segments)} paragraphs")
+        finally:
+            if out is not None:
+                out.close()
+            os.unlink(chunk_file.name)
+
     def start(self):
         """Start the processing loop"""
         self.mq_client.consume_messages(self._process_message)
@@ -246,10 +439,12 @@ def main() -> None:
         s3_client = S3Client(config)
         mq_client = RabbitMQClient(config)
 
-        pipeline = KPipeline(lang_code="a", trf=True)
-        infer = Inference(pipeline, "output")
+        cancellations = CancellationListener(config)
+        cancellations.start()
 
-        processor = FileProcessor(s3_client, mq_client, infer)
+        processor = FileProcessor(s3_client, mq_client, cancellations, "output")
+        # the default language is loaded upfront, the others on demand
+        processor._inference("a")
         processor.start()
 
     except Exception as e:
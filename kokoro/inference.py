@@ -1,13 +1,16 @@
 #!/usr/bin/env python3
 import argparse
 import os
-from typing import Any, Generator
+from typing import Any, Callable, Generator
 from pathlib import Path
 import numpy as np
 import torch
 import soundfile as sf
 from kokoro import KPipeline
 
+class Cancelled(Exception):
+    """Raised when the job being generated is cancelled midway"""
+
 class Inference: 
     def __init__(self, pipeline: KPipeline, output_dir: str):
         self._pipeline = pipeline
@@ -20,10 +23,12 @@ class Inference:
         yield from self._pipeline(text, voice=voice, speed=speed, split_pattern=r"\n+")
 
     def generate(
-        self, output_file: Path, text: str, voice: str | None, speed: float = 1.0
+        self, output_file: Path, text: str, voice: str | None, speed: float = 1.0,
+        cancelled: Callable[[], bool] | None = None
     ) -> None:
         """
         Generate complete audio file from text string.
+        Raise Cancelled as soon as cancelled returns True, checked between segments.
         """
         if voice is None:
             voice = "af_bella"
@@ -31,6 +36,9 @@ class Inference:
         # output_file must have extention, like .wav
         with sf.SoundFile(str(output_file.resolve()), mode='w', samplerate=24000, channels=1, subtype='PCM_16') as sf_file:
             for result in self.__generate(text, voice=voice, speed=speed):
+                if cancelled is not None and cancelled():
+                    raise Cancelled()
+
                 if result.audio is None:
                     continue
 
@@ -2,8 +2,11 @@ import os
 import json
 import logging
 import tempfile
+import threading
+import time
 import pika
 import boto3
+from collections import OrderedDict
 from pathlib import Path
 from pika import PlainCredentials
 from typing import Dict, Any
@@ -26,12 +29,18 @@ class Config:
         self.output_queue = os.getenv("RABBITMQ_OUTPUT_QUEUE", "s3_converting_queue")
         self.input_routing_key = os.getenv("RABBITMQ_INPUT_ROUTING_KEY", "s3_file_key")
         self.output_routing_key = os.getenv("RABBITMQ_OUTPUT_ROUTING_KEY", "processed_file_key")
+        self.cancel_routing_key = os.getenv("RABBITMQ_CANCEL_ROUTING_KEY", "job.cancel")
 
         self.aws_access_key = os.getenv("AWS_ACCESS_KEY_ID")
         self.aws_secret_key = os.getenv("AWS_SECRET_ACCESS_KEY")
         self.aws_region = os.getenv("AWS_REGION", "us-east-1")
-        self.s3_bucket = os.getenv("S3_BUCKET_NAME")
+        # the audio uploaded for conversion and the vetted voice models of the gateway's catalog, the same setting as the gateway's
+        self.s3_bucket = os.getenv("S3_VOICE_BUCKET")
+        # the converted audio, served by the gateway from the same bucket
+        self.output_bucket = os.getenv("S3_CONVERTED_MP3_BUCKET", self.s3_bucket)
         self.processed_prefix = os.getenv("S3_PROCESSED_PREFIX", "processed/")
+        # catalog models are downloaded once and kept here, as they never change under their key
+        self.model_cache_dir = os.getenv("MODEL_CACHE_DIR", "./models/catalog")
 
         self._validate()
 
@@ -53,10 +62,10 @@ class S3Client:
             region_name=config.aws_region
         )
 
-    def download_to_tempfile(self, key: str) -> tempfile._TemporaryFileWrapper[bytes]:
-        """Download S3 object to a temporary file"""
+    def download_to_tempfile(self, key: str, suffix: str | None = None, dir: Path | None = None) -> tempfile._TemporaryFileWrapper[bytes]:
+        """Download S3 object to a temporary file, in the given directory if any"""
         try:
-            temp_file = tempfile.NamedTemporaryFile(delete = False)
+            temp_file = tempfile.NamedTemporaryFile(suffix=suffix, dir=dir, delete = False)
 
             self._client.download_fileobj(
                 Bucket=self.config.s3_bucket,
@@ -86,7 +95,7 @@ class S3Client:
             with open(file_path.resolve(), "rb") as f:
                 self._client.upload_fileobj(
                     Fileobj=f,
-                    Bucket=self.config.s3_bucket,
+                    Bucket=self.config.output_bucket,
                     Key=processed_key
                 )
                 
@@ -98,6 +107,24 @@ class S3Client:
                 os.remove(file_path)
                 logger.info(f"Deleted temporary file {file_path}")
 
+    def delete(self, key: str):
+        """Delete a processed file that is no longer needed"""
+        self._client.delete_object(Bucket=self.config.output_bucket, Key=key)
+        logger.info(f"Deleted {key}")
+
+def connection_parameters(config: Config) -> pika.ConnectionParameters:
+    """RabbitMQ connection parameters of the configuration"""
+    credentials = PlainCredentials(
+        username=config.rabbitmq_user,
+        password=config.rabbitmq_pass
+    )
+
+    return pika.ConnectionParameters(
+        host=config.rabbitmq_host,
+        port=config.rabbitmq_port,
+        credentials=credentials
+    )
+
 class RabbitMQClient:
     """Handles RabbitMQ connection and messaging"""
     
@@ -109,18 +136,7 @@ class RabbitMQClient:
 
     def _connect(self):
         """Establish RabbitMQ connection and channel"""
-        credentials = PlainCredentials(
-            username=self.config.rabbitmq_user,
-            password=self.config.rabbitmq_pass
-        )
-
-        parameters = pika.ConnectionParameters(
-            host=self.config.rabbitmq_host,
-            port=self.config.rabbitmq_port,
-            credentials=credentials
-        )
-
-        self._connection = pika.BlockingConnection(parameters)
+        self._connection = pika.BlockingConnection(connection_parameters(self.config))
         self._channel = self._connection.channel()
         self._setup()
 
@@ -182,13 +198,68 @@ class RabbitMQClient:
             self._connection.close()
             logger.info("RabbitMQ connection closed")
 
+class CancellationListener(threading.Thread):
+    """Keeps track of the jobs cancelled while the worker runs, on a connection of its own"""
+
+    def __init__(self, config: Config, remembered: int = 10000):
+        super().__init__(daemon=True)
+        self.config = config
+        self.remembered = remembered
+        self._cancelled: OrderedDict[str, None] = OrderedDict()
+        self._lock = threading.Lock()
+
+    def run(self):
+        """Listen for cancellations, reconnecting whenever the connection drops"""
+        while True:
+            try:
+                connection = pika.BlockingConnection(connection_parameters(self.config))
+                channel = connection.channel()
+                channel.exchange_declare(
+                    exchange=self.config.exchange_name,
+                    exchange_type="topic",
+                    durable=True
+                )
+
+                # every worker gets every cancellation, on a queue that goes away with it
+                queue = channel.queue_declare(queue="", exclusive=True).method.queue
+                channel.queue_bind(
+                    exchange=self.config.exchange_name,
+                    queue=queue,
+                    routing_key=self.config.cancel_routing_key
+                )
+
+                channel.basic_consume(queue=queue, on_message_callback=self._on_message, auto_ack=True)
+                channel.start_consuming()
+            except Exception as e:
+                logger.error(f"Cancellation listener disconnected: {e}")
+                time.sleep(5)
+
+    def _on_message(self, ch, method, properties, body):
+        try:
+            job_id = json.loads(body)["job_id"]
+        except (ValueError, KeyError):
+            logger.warning(f"Ignoring malformed cancellation: {body!r}")
+            return
+
+        logger.info(f"Job {job_id} cancelled")
+        with self._lock:
+            self._cancelled[job_id] = None
+            while len(self._cancelled) > self.remembered:
+                self._cancelled.popitem(last=False)
+
+    def is_cancelled(self, job_id: str) -> bool:
+        with self._lock:
+            return job_id in self._cancelled
+
 class FileProcessor:
     """Orchestrates file processing workflow"""
     
-    def __init__(self, s3_client: S3Client, mq_client: RabbitMQClient, converter: VoiceConverter):
+    def __init__(self, s3_client: S3Client, mq_client: RabbitMQClient, cancellations: CancellationListener, converter: VoiceConverter, model_cache_dir: str):
         self.s3_client = s3_client
         self.mq_client = mq_client
+        self.cancellations = cancellations
         self.converter = converter
+        self.model_cache_dir = Path(model_cache_dir).resolve()
 
     def _process_message(self, ch, method, properties, body):
         """Handle incoming message processing"""
@@ -198,19 +269,41 @@ class FileProcessor:
 
             job_id = message["job_id"] # do something w ts
 
-            logger.info(f"Processing file: {original_key}")
+            # the vetted voice model of the gateway's catalog, messages without one go with the bundled one
+            model_key = message.get("model_key")
+
+            if self.cancellations.is_cancelled(job_id):
+                logger.info(f"Skipping cancelled job {job_id}")
+                ch.basic_ack(delivery_tag=method.delivery_tag)
+                return
+
+            logger.info(f"Processing file: {original_key} with model {model_key or 'bundled'}")
 
             # Temp file is the input file
-            with self.s3_client.download_to_tempfile(original_key) as temp_file:
+            with self.s3_client.download_to_tempfile(original_key, suffix=Path(original_key).suffix) as temp_file:
                 # Process the file
                 try: 
-                    outfile = self._process_file(temp_file)
+                    outfile = self._process_file(temp_file, model_key)
+
+                    # the conversion runs in one go, so cancellations are only checked around it
+                    if self.cancellations.is_cancelled(job_id):
+                        os.remove(outfile)
+                        logger.info(f"Discarded output of cancelled job {job_id}")
+                        ch.basic_ack(delivery_tag=method.delivery_tag)
+                        return
 
                     # Upload processed file and get new key
                     processed_key = self.s3_client.upload_from_path(
-                        outfile, original_key
+                        Path(outfile), original_key
                     )
 
+                    # cancelled while uploading, so the output is thrown away
+                    if self.cancellations.is_cancelled(job_id):
+                        self.s3_client.delete(processed_key)
+                        logger.info(f"Discarded output of cancelled job {job_id}")
+                        ch.basic_ack(delivery_tag=method.delivery_tag)
+                        return
+
                     # Publish message to RabbitMQ
                     self.mq_client.publish_message({"job_id": job_id, "job_status": "Completed", "file_key": processed_key})
                 except Exception as e:
@@ -225,14 +318,30 @@ class FileProcessor:
             logger.error(f"Error processing {message.get('key')}: {str(e)}", exc_info=True)
             ch.basic_ack(delivery_tag=method.delivery_tag)
 
-    def _process_file(self, temp_file) -> str:
+    def _process_file(self, temp_file, model_key: str | None) -> str:
         """Process the audio file and return the output file path"""
-        
-        # hardcoded model path for now
-        model_path = "./models/model.pth"
-        model_path_idx = "./models/model.index"
-
-        return self.converter.process_audio(open(temp_file.name, 'r').read(), model_path, index_path=model_path_idx)[0]
+        if not model_key:
+            # bundled model
+            return self.converter.process_audio(temp_file.name, "./models/model.pth", index_path="./models/model.index")[0]
+
+        # catalog models come without an index
+        return self.converter.process_audio(temp_file.name, self._model_path(model_key))[0]
+
+    def _model_path(self, model_key: str) -> str:
+        """Path of a catalog model, downloaded on its first use only"""
+        path = (self.model_cache_dir / model_key).resolve()
+        if not path.is_relative_to(self.model_cache_dir):
+            raise ValueError(f"Invalid model key {model_key}")
+
+        if not path.exists():
+            path.parent.mkdir(parents=True, exist_ok=True)
+            model_file = self.s3_client.download_to_tempfile(model_key, suffix=".pth", dir=path.parent)
+            model_file.close()
+            # renamed once complete, so that a failed download is never taken for the model
+            os.replace(model_file.name, path)
+            logger.info(f"Cached model {model_key} at {path}")
+
+        return str(path)
     
     def start(self):
         """Start the processing loop"""
@@ -248,7 +357,10 @@ def main() -> None:
         loader = BaseLoader()
         converter = VoiceConverter(loader=loader, processor=processor)
 
-        processor = FileProcessor(s3_client, mq_client, converter)
+        cancellations = CancellationListener(config)
+        cancellations.start()
+
+        processor = FileProcessor(s3_client, mq_client, cancellations, converter, config.model_cache_dir)
         processor.start()
 
     except Exception as e: